@@ -0,0 +1,248 @@
+// This program performs administrative tasks for the callback service.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ardanlabs/conf"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+)
+
+const logServiceName = "GO-CALLBACK-ADMIN"
+
+var cfg struct {
+	Database struct {
+		User     string `conf:"default:gocallbacksvc"`
+		Password string `conf:"default:secret1234"`
+		Name     string `conf:"default:gocallbacksvc"`
+		Port     string `conf:"default:5432"`
+		Host     string `conf:"default:0.0.0.0"`
+		SSLMode  string `conf:"default:disable"`
+		Timezone string `conf:"default:Europe/Madrid"`
+	}
+	Args conf.Args
+}
+
+const commands = `COMMANDS
+  keys create <client> [tenant]  creates an API key for client, acting on behalf of
+                                 tenant (default tenant if omitted), and prints it
+  keys list                      lists every API key
+  keys revoke <id>               revokes the API key identified by id
+  tenants set <id> [settings]    creates or updates a tenant. Settings are given as
+                                 url=<object service url> retention=<duration>
+                                 max-objects=<number>
+  tenants list                   lists every tenant
+  tenants delete <id>            deletes the tenant identified by id`
+
+func main() {
+	if err := run(); err != nil {
+		log.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+
+	// =========================================================================
+	// Parses configuration arguments and tags
+	if err := conf.Parse(os.Args[1:], logServiceName, &cfg); err != nil {
+		if err == conf.ErrHelpWanted {
+			options, err := conf.Usage(logServiceName, &cfg)
+			if err != nil {
+				return fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(options)
+			fmt.Println(commands)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	// =========================================================================
+	// Storage service
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
+		cfg.Database.Host,
+		cfg.Database.User,
+		cfg.Database.Password,
+		cfg.Database.Name,
+		cfg.Database.Port,
+		cfg.Database.SSLMode,
+		cfg.Database.Timezone,
+	)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("opening database connection through dsl: %w", err)
+	}
+	if err := models.Migrate(db); err != nil {
+		return fmt.Errorf("migrating database: %w", err)
+	}
+
+	ctx := context.Background()
+	aks := models.NewAPIKeyService(db)
+	ts := models.NewTenantService(db)
+
+	switch cfg.Args.Num(0) + " " + cfg.Args.Num(1) {
+	case "keys create":
+		return keysCreate(ctx, os.Stdout, aks, cfg.Args.Num(2), cfg.Args.Num(3))
+	case "keys list":
+		return keysList(ctx, os.Stdout, aks)
+	case "keys revoke":
+		return keysRevoke(ctx, os.Stdout, aks, cfg.Args.Num(2))
+	case "tenants set":
+		var settings []string
+		if len(cfg.Args) > 3 {
+			settings = cfg.Args[3:]
+		}
+		return tenantsSet(ctx, os.Stdout, ts, cfg.Args.Num(2), settings)
+	case "tenants list":
+		return tenantsList(ctx, os.Stdout, ts)
+	case "tenants delete":
+		return tenantsDelete(ctx, os.Stdout, ts, cfg.Args.Num(2))
+	default:
+		fmt.Println(commands)
+		return nil
+	}
+}
+
+// keysCreate creates an API key for client and prints it. The key can't be retrieved later.
+func keysCreate(ctx context.Context, w io.Writer, aks models.APIKeyService, client, tenant string) error {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+
+	ak, key, err := aks.Create(ctx, client, tenant)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "API key %d created for client %q of tenant %q. Store it now, it won't be shown again:\n%s\n",
+		ak.ID, ak.Client, ak.Tenant, key)
+	return nil
+}
+
+// keysList prints every API key.
+func keysList(ctx context.Context, w io.Writer, aks models.APIKeyService) error {
+	keys, err := aks.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCLIENT\tTENANT\tPREFIX\tCREATED\tREVOKED")
+	for _, ak := range keys {
+		revoked := "-"
+		if ak.Revoked() {
+			revoked = ak.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			ak.ID, ak.Client, ak.Tenant, ak.Prefix, ak.CreatedAt.Format(time.RFC3339), revoked)
+	}
+	return tw.Flush()
+}
+
+// keysRevoke revokes the API key identified by rawID.
+func keysRevoke(ctx context.Context, w io.Writer, aks models.APIKeyService, rawID string) error {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid api key id %q", rawID)
+	}
+
+	if err := aks.Revoke(ctx, id); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "API key %d revoked\n", id)
+	return nil
+}
+
+// tenantsSet creates the tenant identified by id, or updates its settings. Settings not given
+// keep their current value.
+func tenantsSet(ctx context.Context, w io.Writer, ts models.TenantService, id string, settings []string) error {
+	if !models.ValidTenantID(id) {
+		return fmt.Errorf("invalid tenant id %q", id)
+	}
+
+	t, err := ts.Get(ctx, id)
+	switch {
+	case err == models.ErrNotFound:
+		t = models.Tenant{ID: id}
+	case err != nil:
+		return err
+	}
+
+	for _, s := range settings {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid tenant setting %q", s)
+		}
+
+		switch key, value := parts[0], parts[1]; key {
+		case "url":
+			t.ObjectServiceURL = value
+		case "retention":
+			if t.Retention, err = time.ParseDuration(value); err != nil {
+				return fmt.Errorf("invalid retention %q: %w", value, err)
+			}
+		case "max-objects":
+			if t.MaxObjectsPerCallback, err = strconv.Atoi(value); err != nil || t.MaxObjectsPerCallback < 0 {
+				return fmt.Errorf("invalid max-objects %q", value)
+			}
+		default:
+			return fmt.Errorf("unknown tenant setting %q", key)
+		}
+	}
+
+	if err := ts.Save(ctx, t); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Tenant %q saved\n", t.ID)
+	return nil
+}
+
+// tenantsList prints every tenant. Settings left to the service default are shown as "-".
+func tenantsList(ctx context.Context, w io.Writer, ts models.TenantService) error {
+	tenants, err := ts.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tOBJECT SERVICE\tRETENTION\tMAX OBJECTS\tCREATED")
+	for _, t := range tenants {
+		url, retention, maxObjects := "-", "-", "-"
+		if t.ObjectServiceURL != "" {
+			url = t.ObjectServiceURL
+		}
+		if t.Retention > 0 {
+			retention = t.Retention.String()
+		}
+		if t.MaxObjectsPerCallback > 0 {
+			maxObjects = strconv.Itoa(t.MaxObjectsPerCallback)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			t.ID, url, retention, maxObjects, t.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// tenantsDelete deletes the tenant identified by id.
+func tenantsDelete(ctx context.Context, w io.Writer, ts models.TenantService, id string) error {
+	if err := ts.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Tenant %q deleted\n", id)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+)
+
+// deprecations parses the deprecated routes of specs, each one as "METHOD /route SINCE [SUNSET
+// [LINK]]", the dates in RFC 3339 or as days (2006-01-02) and "-" leaving one out. Routes are keyed
+// like handlers.Config.Deprecations.
+func deprecations(specs []string) (map[string]mw.DeprecationConfig, error) {
+	deps := make(map[string]mw.DeprecationConfig, len(specs))
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		if len(fields) < 3 || len(fields) > 5 || !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("deprecation %q must be \"METHOD /route SINCE [SUNSET [LINK]]\"", spec)
+		}
+		key := strings.ToUpper(fields[0]) + " " + fields[1]
+		if _, ok := deps[key]; ok {
+			return nil, fmt.Errorf("route %q is deprecated twice", key)
+		}
+
+		var (
+			dc  mw.DeprecationConfig
+			err error
+		)
+		if dc.Since, err = deprecationDate(fields[2]); err != nil {
+			return nil, fmt.Errorf("deprecation %q: %w", spec, err)
+		}
+		if len(fields) > 3 {
+			if dc.Sunset, err = deprecationDate(fields[3]); err != nil {
+				return nil, fmt.Errorf("deprecation %q: %w", spec, err)
+			}
+		}
+		if len(fields) > 4 {
+			dc.Link = fields[4]
+		}
+		if !dc.Enabled() {
+			return nil, fmt.Errorf("deprecation %q sets neither a date nor a sunset", spec)
+		}
+		deps[key] = dc
+	}
+	return deps, nil
+}
+
+// deprecationDate parses s as an RFC 3339 time or a day, "-" being the zero time.
+func deprecationDate(s string) (time.Time, error) {
+	if s == "-" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q is neither RFC 3339 nor 2006-01-02", s)
+	}
+	return t, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof" // Register the pprof handlers
 	"os"
@@ -21,7 +22,10 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/noelruault/go-callback-service/internal/handlers"
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
 	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/objectservice"
+	"github.com/noelruault/go-callback-service/internal/rpc"
 )
 
 const logServiceName = "GO-CALLBACK-SERVER"
@@ -36,10 +40,168 @@ var cfg struct {
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		// MaxBodyBytes limits the size of a callback body, 0 disables the limit.
+		MaxBodyBytes int64 `conf:"default:16777216"`
+		// EventDedupeWindow is how long the id and source of a received CloudEvent are kept.
+		EventDedupeWindow time.Duration `conf:"default:10m"`
+		// IdempotencyWindow is how long the response sent for an Idempotency-Key is kept.
+		IdempotencyWindow time.Duration `conf:"default:24h"`
+		// IdempotencyLease is how long a request or an event being processed holds its key, so
+		// the key of one that never completed is freed.
+		IdempotencyLease time.Duration `conf:"default:1m"`
+		// Deprecations lists the deprecated routes, separated by ";", as
+		// "METHOD /route SINCE [SUNSET [LINK]]": "POST /callback 2026-10-19 2027-04-19". Their
+		// responses carry the Deprecation, Sunset and Link headers.
+		Deprecations []string
+	}
+	GRPC struct {
+		// The gRPC API is served on its own Address, receiving messages of up to
+		// MaxMessageBytes. Calls still running --web-shutdown-timeout after the shutdown
+		// starts, such as the object watches, are cancelled.
+		Address         string `conf:"default:0.0.0.0:9091"`
+		MaxMessageBytes int    `conf:"default:4194304"`
+	}
+	Auth struct {
+		// RequireAPIKey makes /callback, /events and /objects, and every gRPC call, reject
+		// requests without a valid API key. Keys are managed with the callback-admin command.
+		RequireAPIKey bool `conf:"default:false"`
+	}
+	RateLimit struct {
+		// Requests allowed per second and at once to every client on each route, 0 disables
+		// the limit of a route, the default. Clients are told apart by API key, or by remote IP.
+		CallbackRate  float64 `conf:"default:0"`
+		CallbackBurst int     `conf:"default:100"`
+		EventsRate    float64 `conf:"default:0"`
+		EventsBurst   int     `conf:"default:100"`
+		ObjectsRate   float64 `conf:"default:0"`
+		ObjectsBurst  int     `conf:"default:200"`
+		// Shared keeps the limits on Postgres, so they apply across every replica.
+		Shared bool `conf:"default:false"`
+		// TrustedProxies lists the networks of the proxies and load balancers, separated by
+		// ";", whose X-Forwarded-For header names the remote IP: "10.0.0.0/8;192.168.1.4".
+		TrustedProxies []string
+	}
+	Upstream struct {
+		// Settings of the HTTP client shared by every lookup to the object services. A service
+		// failing UnhealthyAfter times in a row is not sent callbacks for HealthRetry.
+		UserAgent           string        `conf:"default:go-callback-service"`
+		Timeout             time.Duration `conf:"default:5s"`
+		DialTimeout         time.Duration `conf:"default:2s"`
+		TLSHandshakeTimeout time.Duration `conf:"default:2s"`
+		MaxIdleConns        int           `conf:"default:100"`
+		MaxIdleConnsPerHost int           `conf:"default:100"`
+		MaxConnsPerHost     int           `conf:"default:0"`
+		IdleConnTimeout     time.Duration `conf:"default:90s"`
+		UnhealthyAfter      int           `conf:"default:5"`
+		HealthRetry         time.Duration `conf:"default:5s"`
+		// Objects are looked up BatchSize at a time on services serving GET /objects?ids=, 0
+		// disables batches. Services not serving them are asked again after BatchRetry.
+		BatchSize  int           `conf:"default:100"`
+		BatchRetry time.Duration `conf:"default:10m"`
+		// Lookups the object service could not answer are sent again up to Retries times, after
+		// RetryBackoff and twice as long before every other retry.
+		Retries      int           `conf:"default:1"`
+		RetryBackoff time.Duration `conf:"default:100ms"`
+		// Credentials and transport of the requests. Token is sent as a bearer token, or
+		// TokenFile is read for it and read again when it changes. Headers are "Name: value"
+		// pairs separated by ";". CAFile is a PEM bundle of the authorities trusted besides the
+		// system ones, CertFile and KeyFile the client certificate presented for mTLS. Proxy
+		// replaces the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, and may hold
+		// its own credentials. The object services of the tenants are sent none of them but the
+		// proxy.
+		Token       string `conf:"mask"`
+		TokenFile   string
+		TokenReload time.Duration `conf:"default:30s"`
+		Headers     []string      `conf:"mask"`
+		CAFile      string
+		CertFile    string
+		KeyFile     string
+		Proxy       string `conf:"mask"`
+		// The status lookups in flight toward every object service are tuned between
+		// MinConcurrency and MaxConcurrency from their latency and errors. See /debug/limiter.
+		InitialConcurrency int     `conf:"default:20"`
+		MinConcurrency     int     `conf:"default:1"`
+		MaxConcurrency     int     `conf:"default:500"`
+		LatencyTolerance   float64 `conf:"default:2"`
+		Backoff            float64 `conf:"default:0.9"`
+		// Lookups running longer than HedgePercentile of the recent ones are sent again, for at
+		// most HedgeMaxRatio of the lookups. A percentile of 0 disables hedging.
+		HedgePercentile float64 `conf:"default:0"`
+		HedgeMaxRatio   float64 `conf:"default:0.05"`
+		// ServicesFile is a JSON document listing named object services, each with its own
+		// client settings, and the routes sending objects to them. Objects routed nowhere are
+		// looked up on the default upstream.
+		ServicesFile string
+	}
+	Admission struct {
+		// Callbacks and events are answered with 503 while MaxPending objects wait for their
+		// status, or while any do and the average lookup takes MaxLatency. 0 disables a check.
+		MaxPending int64         `conf:"default:10000"`
+		MaxLatency time.Duration `conf:"default:10s"`
+		RetryAfter time.Duration `conf:"default:5s"`
+	}
+	Webhooks struct {
+		// Deliveries failing MaxAttempts times are given up. The wait between two attempts starts
+		// at Backoff and doubles after every failure, up to MaxBackoff. Webhooks can only target
+		// public addresses unless AllowPrivateNetworks is set. Deliveries done with are deleted,
+		// with their attempts, after Retention.
+		Timeout              time.Duration `conf:"default:10s"`
+		MaxAttempts          int           `conf:"default:8"`
+		Backoff              time.Duration `conf:"default:10s"`
+		MaxBackoff           time.Duration `conf:"default:1h"`
+		PollInterval         time.Duration `conf:"default:1s"`
+		Retention            time.Duration `conf:"default:168h"`
+		AllowPrivateNetworks bool          `conf:"default:false"`
+	}
+	Outbox struct {
+		// Sinks lists where the object events are published, separated by ";": webhook, file
+		// (appending them to File) and stdout. Published events are deleted after Retention. A
+		// replica publishes to a sink until it stops for Lease.
+		Sinks        []string      `conf:"default:webhook"`
+		PollInterval time.Duration `conf:"default:1s"`
+		BatchSize    int           `conf:"default:100"`
+		Retention    time.Duration `conf:"default:24h"`
+		Lease        time.Duration `conf:"default:30s"`
+		File         string
+	}
+	Stream struct {
+		// Streams on /objects/stream stay open past --web-write-timeout, which bounds each of
+		// their writes. Clients reconnecting after Retry resume from the latest Buffer events.
+		Heartbeat time.Duration `conf:"default:15s"`
+		Retry     time.Duration `conf:"default:1s"`
+		Buffer    int           `conf:"default:1000"`
+	}
+	Listen struct {
+		// Every replica listens to the events written by all of them, so they are streamed by
+		// all of them, and reads the latest ones every PollInterval in case notifications are
+		// dropped. Disabled replicas only stream the events they relay.
+		Enabled             bool          `conf:"default:true"`
+		PollInterval        time.Duration `conf:"default:5s"`
+		Lookback            time.Duration `conf:"default:30s"`
+		ReconnectBackoff    time.Duration `conf:"default:1s"`
+		MaxReconnectBackoff time.Duration `conf:"default:30s"`
+	}
+	Socket struct {
+		// Connections to /callback/ws send frames of up to MaxFrameBytes, at FrameRate frames
+		// per second with bursts of FrameBurst (0 disables the limit), and follow up to
+		// MaxSubscriptions objects. Connections not reading SendBuffer messages are closed.
+		MaxFrameBytes    int64         `conf:"default:1048576"`
+		MaxSubscriptions int           `conf:"default:10000"`
+		FrameRate        float64       `conf:"default:0"`
+		FrameBurst       int           `conf:"default:0"`
+		SendBuffer       int           `conf:"default:64"`
+		PingInterval     time.Duration `conf:"default:30s"`
+	}
+	Signature struct {
+		// CallbackSecrets and EventsSecrets are the secrets accepted to sign the requests of each
+		// route, separated by ";". Routes without secrets accept unsigned requests.
+		CallbackSecrets []string      `conf:"mask"`
+		EventsSecrets   []string      `conf:"mask"`
+		Tolerance       time.Duration `conf:"default:5m"`
 	}
 	Database struct {
 		User     string `conf:"default:gocallbacksvc"`
-		Password string `conf:"default:secret1234"`
+		Password string `conf:"default:secret1234,mask"`
 		Name     string `conf:"default:gocallbacksvc"`
 		Port     string `conf:"default:5432"`
 		Host     string `conf:"default:0.0.0.0"`
@@ -103,7 +265,10 @@ func run() error {
 		return fmt.Errorf("opening database connection through dsl: %w", err)
 	}
 
-	db.AutoMigrate(&models.Callback{}) // Automatically migrate the schema, keeps it up to date.
+	// Automatically migrate the schema, keeps it up to date.
+	if err := models.Migrate(db); err != nil {
+		return fmt.Errorf("migrating database: %w", err)
+	}
 
 	// =========================================================================
 	// Start Tracing Support
@@ -118,10 +283,23 @@ func run() error {
 	}
 	defer closer()
 
+	limits := models.ConcurrencyLimitConfig{
+		Initial:   cfg.Upstream.InitialConcurrency,
+		Min:       cfg.Upstream.MinConcurrency,
+		Max:       cfg.Upstream.MaxConcurrency,
+		Tolerance: cfg.Upstream.LatencyTolerance,
+		Backoff:   cfg.Upstream.Backoff,
+	}
+	// Every upstream registers its limiter, the ones of the services of the tenants once used.
+	limiters := models.NewLimiterRegistry()
+	http.Handle("/debug/limiter", handlers.Limiters(limiters))
+
 	// =========================================================================
 	// Start Debug Service
 	//
 	// /debug/pprof - Added to the default mux by importing the net/http/pprof package.
+	// /debug/vars - Added to the default mux by importing the expvar package.
+	// /debug/limiter - Concurrency limits toward the object services.
 	//
 	// Not concerned with shutting this down when the application is shutdown.
 	go func() {
@@ -138,22 +316,202 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
+	headers, err := parseHeaders(cfg.Upstream.Headers)
+	if err != nil {
+		return err
+	}
+	proxy, err := parseProxy(cfg.Upstream.Proxy)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := objectservice.LoadTLSConfig(cfg.Upstream.CAFile, cfg.Upstream.CertFile, cfg.Upstream.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	clientCfg := objectservice.Config{
+		UserAgent:           cfg.Upstream.UserAgent,
+		Timeout:             cfg.Upstream.Timeout,
+		DialTimeout:         cfg.Upstream.DialTimeout,
+		TLSHandshakeTimeout: cfg.Upstream.TLSHandshakeTimeout,
+		MaxIdleConns:        cfg.Upstream.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.Upstream.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.Upstream.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.Upstream.IdleConnTimeout,
+		UnhealthyAfter:      cfg.Upstream.UnhealthyAfter,
+		HealthRetry:         cfg.Upstream.HealthRetry,
+		BatchRetry:          cfg.Upstream.BatchRetry,
+		Retries:             cfg.Upstream.Retries,
+		RetryBackoff:        cfg.Upstream.RetryBackoff,
+		Token:               cfg.Upstream.Token,
+		TokenFile:           cfg.Upstream.TokenFile,
+		TokenReload:         cfg.Upstream.TokenReload,
+		Headers:             headers,
+		TLS:                 tlsConfig,
+		Proxy:               proxy,
+	}
+	// The tenants pick the addresses of their object services, so they are sent no credentials.
+	tenantCfg := clientCfg
+	tenantCfg.Token, tenantCfg.TokenFile, tenantCfg.Headers, tenantCfg.TLS = "", "", nil, nil
+
+	upstream := models.Upstream{
+		Client:       objectservice.New(clientCfg),
+		TenantClient: objectservice.New(tenantCfg),
+		URL:          cfg.CallbackService.Address,
+		Limiter:      models.NewConcurrencyLimiter(limits),
+		Limiters:     limiters,
+		BatchSize:    cfg.Upstream.BatchSize,
+		Hedge: models.HedgeConfig{
+			Percentile: cfg.Upstream.HedgePercentile,
+			MaxRatio:   cfg.Upstream.HedgeMaxRatio,
+		},
+	}
+	if cfg.Upstream.ServicesFile != "" {
+		upstream.Services, upstream.Routes, err = loadUpstreams(cfg.Upstream.ServicesFile, clientCfg, limits)
+		if err != nil {
+			return err
+		}
+		log.Printf("main : %d named upstreams, %d routes", len(upstream.Services), len(upstream.Routes))
+	}
+
+	webhookSink, sinks, err := eventSinks(cfg.Outbox.Sinks, cfg.Outbox.File)
+	if err != nil {
+		return err
+	}
+
+	deprecated, err := deprecations(cfg.Web.Deprecations)
+	if err != nil {
+		return err
+	}
+
+	proxies, err := mw.ParseTrustedProxies(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	// The callback service and the event broker are shared by the HTTP and gRPC APIs.
+	callbacks := models.NewCallbackService(db, models.NewTenantService(db), upstream, log)
+	broker := models.NewEventBroker(cfg.Stream.Buffer)
+
+	apiCfg := handlers.Config{
+		Upstream:          upstream,
+		Callbacks:         callbacks,
+		Broker:            broker,
+		MaxBodyBytes:      cfg.Web.MaxBodyBytes,
+		EventDedupeWindow: cfg.Web.EventDedupeWindow,
+		IdempotencyWindow: cfg.Web.IdempotencyWindow,
+		IdempotencyLease:  cfg.Web.IdempotencyLease,
+		Deprecations:      deprecated,
+		CallbackSignature: mw.SignatureConfig{
+			Secrets:   cfg.Signature.CallbackSecrets,
+			Tolerance: cfg.Signature.Tolerance,
+		},
+		EventsSignature: mw.SignatureConfig{
+			Secrets:   cfg.Signature.EventsSecrets,
+			Tolerance: cfg.Signature.Tolerance,
+		},
+		RequireAPIKey: cfg.Auth.RequireAPIKey,
+		CallbackRateLimit: models.RateLimit{
+			Rate:  cfg.RateLimit.CallbackRate,
+			Burst: cfg.RateLimit.CallbackBurst,
+		},
+		EventsRateLimit: models.RateLimit{
+			Rate:  cfg.RateLimit.EventsRate,
+			Burst: cfg.RateLimit.EventsBurst,
+		},
+		ObjectsRateLimit: models.RateLimit{
+			Rate:  cfg.RateLimit.ObjectsRate,
+			Burst: cfg.RateLimit.ObjectsBurst,
+		},
+		SharedRateLimits: cfg.RateLimit.Shared,
+		TrustedProxies:   proxies,
+		Admission: mw.AdmissionConfig{
+			MaxPending: cfg.Admission.MaxPending,
+			MaxLatency: cfg.Admission.MaxLatency,
+			RetryAfter: cfg.Admission.RetryAfter,
+		},
+		Webhooks: models.WebhookConfig{
+			Timeout:              cfg.Webhooks.Timeout,
+			MaxAttempts:          cfg.Webhooks.MaxAttempts,
+			Backoff:              cfg.Webhooks.Backoff,
+			MaxBackoff:           cfg.Webhooks.MaxBackoff,
+			PollInterval:         cfg.Webhooks.PollInterval,
+			Retention:            cfg.Webhooks.Retention,
+			AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+		},
+		Outbox: models.OutboxConfig{
+			PollInterval: cfg.Outbox.PollInterval,
+			BatchSize:    cfg.Outbox.BatchSize,
+			Retention:    cfg.Outbox.Retention,
+			Lease:        cfg.Outbox.Lease,
+		},
+		WebhookSink: webhookSink,
+		EventSinks:  sinks,
+		Stream: handlers.StreamConfig{
+			Heartbeat:    cfg.Stream.Heartbeat,
+			Retry:        cfg.Stream.Retry,
+			BufferSize:   cfg.Stream.Buffer,
+			WriteTimeout: cfg.Web.WriteTimeout,
+		},
+		Listen: models.ListenConfig{
+			PollInterval:        cfg.Listen.PollInterval,
+			Lookback:            cfg.Listen.Lookback,
+			ReconnectBackoff:    cfg.Listen.ReconnectBackoff,
+			MaxReconnectBackoff: cfg.Listen.MaxReconnectBackoff,
+		},
+		Socket: handlers.SocketConfig{
+			MaxFrameBytes:    cfg.Socket.MaxFrameBytes,
+			MaxSubscriptions: cfg.Socket.MaxSubscriptions,
+			FrameRate: models.RateLimit{
+				Rate:  cfg.Socket.FrameRate,
+				Burst: cfg.Socket.FrameBurst,
+			},
+			SendBuffer:   cfg.Socket.SendBuffer,
+			PingInterval: cfg.Socket.PingInterval,
+		},
+	}
+	if cfg.Listen.Enabled {
+		apiCfg.Listen.DSN = dsn
+	}
+
+	// The rate limits buckets are shared by the HTTP and gRPC APIs.
+	apiCfg.RateLimits = handlers.NewRateLimitService(db, cfg.RateLimit.Shared, log)
+
+	handler, stopWorkers := handlers.API(log, db, apiCfg)
 	api := http.Server{
 		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(log, db, cfg.CallbackService.Address),
+		Handler:      handler,
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
 	}
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	rpcServer := rpc.NewServer(log, db, callbacks, broker, rpc.Config{
+		RequireAPIKey:     cfg.Auth.RequireAPIKey,
+		MaxMessageBytes:   cfg.GRPC.MaxMessageBytes,
+		Admission:         apiCfg.Admission,
+		RateLimits:        apiCfg.RateLimits,
+		CallbackRateLimit: apiCfg.CallbackRateLimit,
+		ObjectsRateLimit:  apiCfg.ObjectsRateLimit,
+		SignedCallbacks:   apiCfg.CallbackSignature.Enabled(),
+	})
+	rpcListener, err := net.Listen("tcp", cfg.GRPC.Address)
+	if err != nil {
+		return fmt.Errorf("listening for gRPC calls: %w", err)
+	}
+
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel so the goroutines can exit if we don't collect their errors.
+	serverErrors := make(chan error, 2)
 
 	// Start the service listening for requests.
 	go func() {
 		log.Printf("main : API listening on %s", api.Addr)
 		serverErrors <- api.ListenAndServe()
 	}()
+	go func() {
+		log.Printf("main : gRPC API listening on %s", rpcListener.Addr())
+		serverErrors <- rpcServer.Serve(rpcListener)
+	}()
 
 	// =========================================================================
 	// Shutdown
@@ -170,6 +528,17 @@ func run() error {
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
 		defer cancel()
 
+		// Streams last until their clients leave, so they are ended first: the APIs would wait
+		// for them until the deadline otherwise.
+		broker.Close()
+
+		// The gRPC API stops taking calls and waits for the running ones meanwhile.
+		rpcStopped := make(chan struct{})
+		go func() {
+			rpcServer.GracefulStop()
+			close(rpcStopped)
+		}()
+
 		// Asking listener to shutdown and load shed.
 		err := api.Shutdown(ctx)
 		if err != nil {
@@ -177,6 +546,16 @@ func run() error {
 			err = api.Close()
 		}
 
+		select {
+		case <-rpcStopped:
+		case <-ctx.Done():
+			log.Printf("main : Graceful gRPC shutdown did not complete in %v", cfg.Web.ShutdownTimeout)
+			rpcServer.Stop()
+		}
+
+		// The background work is stopped once no request uses it.
+		stopWorkers()
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGSTOP:
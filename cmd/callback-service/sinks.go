@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+)
+
+// eventSinks builds the sinks named by names the outbox is relayed to: "webhook", "file", writing
+// to the file at path, and "stdout". It reports whether the webhooks are a sink apart, as they are
+// built with the API.
+func eventSinks(names []string, path string) (bool, []models.OutboxSink, error) {
+	var (
+		webhook bool
+		sinks   []models.OutboxSink
+		seen    = make(map[string]bool)
+	)
+	for _, name := range names {
+		if seen[name] {
+			return false, nil, fmt.Errorf("outbox sink %q is listed twice", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "webhook":
+			webhook = true
+		case "stdout":
+			sinks = append(sinks, models.OutboxSink{Name: name, ObjectEventNotifier: models.NewWriterSink(os.Stdout)})
+		case "file":
+			if path == "" {
+				return false, nil, fmt.Errorf("outbox sink \"file\" needs an outbox file")
+			}
+			sink, err := models.NewFileSink(path)
+			if err != nil {
+				return false, nil, err
+			}
+			sinks = append(sinks, models.OutboxSink{Name: name, ObjectEventNotifier: sink})
+		default:
+			return false, nil, fmt.Errorf("unknown outbox sink %q, expected webhook, file or stdout", name)
+		}
+	}
+	return webhook, sinks, nil
+}
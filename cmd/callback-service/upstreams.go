@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/objectservice"
+)
+
+// upstreamsFile is the document listing the named object services and the routes to them.
+//
+//	{
+//	  "services": [{"name": "catalog", "url": "https://catalog", "token_file": "/run/secrets/catalog"}],
+//	  "routes": [{"service": "catalog", "tenant": "acme"}, {"service": "catalog", "tenant": "default", "min_id": 1000}]
+//	}
+type upstreamsFile struct {
+	Services []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+		// Settings left out take the ones of the default upstream.
+		Token               string            `json:"token"`
+		TokenFile           string            `json:"token_file"`
+		Headers             map[string]string `json:"headers"`
+		CAFile              string            `json:"ca_file"`
+		CertFile            string            `json:"cert_file"`
+		KeyFile             string            `json:"key_file"`
+		Proxy               string            `json:"proxy"`
+		Timeout             duration          `json:"timeout"`
+		DialTimeout         duration          `json:"dial_timeout"`
+		TLSHandshakeTimeout duration          `json:"tls_handshake_timeout"`
+		MaxConnsPerHost     int               `json:"max_conns_per_host"`
+		UnhealthyAfter      int               `json:"unhealthy_after"`
+		HealthRetry         duration          `json:"health_retry"`
+		Retries             *int              `json:"retries"`
+	} `json:"services"`
+	Routes []struct {
+		Service string `json:"service"`
+		Tenant  string `json:"tenant"`
+		Source  string `json:"source"`
+		MinID   int64  `json:"min_id"`
+		MaxID   int64  `json:"max_id"`
+	} `json:"routes"`
+}
+
+// duration is a time.Duration read from a JSON string such as "1.5s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadUpstreams reads the named object services and their routes from the file at path. Every
+// service gets its own client, built from base and the settings the file sets for it, and its own
+// concurrency limiter, tuned by limits.
+func loadUpstreams(path string, base objectservice.Config, limits models.ConcurrencyLimitConfig) ([]models.UpstreamService, []models.UpstreamRoute, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening upstreams file: %w", err)
+	}
+	defer f.Close()
+
+	var doc upstreamsFile
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("reading upstreams file: %w", err)
+	}
+
+	var services []models.UpstreamService
+	names := make(map[string]bool)
+	for _, s := range doc.Services {
+		switch {
+		case s.Name == "" || s.URL == "":
+			return nil, nil, fmt.Errorf("upstreams file: services need a name and a url")
+		case s.Name == models.DefaultUpstream, models.IsTenantUpstream(s.Name):
+			return nil, nil, fmt.Errorf("upstreams file: service name %q is reserved", s.Name)
+		case names[s.Name]:
+			return nil, nil, fmt.Errorf("upstreams file: service %q is listed twice", s.Name)
+		}
+		names[s.Name] = true
+
+		cfg := base
+		if s.Token != "" || s.TokenFile != "" {
+			cfg.Token, cfg.TokenFile = s.Token, s.TokenFile
+		}
+		if len(s.Headers) > 0 {
+			cfg.Headers = base.Headers.Clone()
+			if cfg.Headers == nil {
+				cfg.Headers = make(http.Header)
+			}
+			for name, value := range s.Headers {
+				cfg.Headers.Set(name, value)
+			}
+		}
+		if s.CAFile != "" || s.CertFile != "" || s.KeyFile != "" {
+			if cfg.TLS, err = objectservice.LoadTLSConfig(s.CAFile, s.CertFile, s.KeyFile); err != nil {
+				return nil, nil, fmt.Errorf("upstreams file: service %q: %w", s.Name, err)
+			}
+		}
+		if s.Proxy != "" {
+			if cfg.Proxy, err = parseProxy(s.Proxy); err != nil {
+				return nil, nil, fmt.Errorf("upstreams file: service %q: %w", s.Name, err)
+			}
+		}
+		if s.Timeout > 0 {
+			cfg.Timeout = time.Duration(s.Timeout)
+		}
+		if s.DialTimeout > 0 {
+			cfg.DialTimeout = time.Duration(s.DialTimeout)
+		}
+		if s.TLSHandshakeTimeout > 0 {
+			cfg.TLSHandshakeTimeout = time.Duration(s.TLSHandshakeTimeout)
+		}
+		if s.MaxConnsPerHost > 0 {
+			cfg.MaxConnsPerHost = s.MaxConnsPerHost
+		}
+		if s.UnhealthyAfter > 0 {
+			cfg.UnhealthyAfter = s.UnhealthyAfter
+		}
+		if s.HealthRetry > 0 {
+			cfg.HealthRetry = time.Duration(s.HealthRetry)
+		}
+		if s.Retries != nil {
+			cfg.Retries = *s.Retries
+		}
+
+		services = append(services, models.UpstreamService{
+			Name:    s.Name,
+			URL:     s.URL,
+			Client:  objectservice.New(cfg),
+			Limiter: models.NewConcurrencyLimiter(limits),
+		})
+	}
+
+	var routes []models.UpstreamRoute
+	for _, r := range doc.Routes {
+		switch {
+		case !names[r.Service]:
+			return nil, nil, fmt.Errorf("upstreams file: route to unknown service %q", r.Service)
+		case r.Tenant == "":
+			// Sources are named by the clients, so routes can't span the tenants.
+			return nil, nil, fmt.Errorf("upstreams file: route to %q needs a tenant", r.Service)
+		case r.MaxID != 0 && r.MinID > r.MaxID:
+			return nil, nil, fmt.Errorf("upstreams file: route to %q has min_id over max_id", r.Service)
+		}
+		routes = append(routes, models.UpstreamRoute{
+			Service: r.Service,
+			Tenant:  r.Tenant,
+			Source:  r.Source,
+			MinID:   r.MinID,
+			MaxID:   r.MaxID,
+		})
+	}
+
+	return services, routes, nil
+}
+
+// parseHeaders reads the "Name: value" pairs of list. Values are left out of the errors, as they
+// may hold credentials.
+func parseHeaders(list []string) (http.Header, error) {
+	headers := make(http.Header)
+	for i, h := range list {
+		sep := strings.Index(h, ":")
+		if sep < 0 || strings.TrimSpace(h[:sep]) == "" {
+			return nil, fmt.Errorf("parsing upstream header %d: expected \"Name: value\"", i+1)
+		}
+		headers.Add(strings.TrimSpace(h[:sep]), strings.TrimSpace(h[sep+1:]))
+	}
+	return headers, nil
+}
+
+// parseProxy reads the URL of an HTTP proxy. An empty raw returns nil.
+func parseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("parsing upstream proxy: %q is not an absolute URL", raw)
+	}
+	return u, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -9,26 +10,43 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const ()
 
+// object is the status of an object, as served on /objects.
+type object struct {
+	ID     int  `json:"id"`
+	Online bool `json:"online"`
+}
+
 func main() {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
+	// rng is not safe for concurrent use, and the handlers run concurrently.
+	var mu sync.Mutex
+	latency := func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(rng.Int63n(4000)+300) * time.Millisecond
+	}
+
 	go func() {
 		client := &http.Client{Timeout: 1 * time.Second}
 
 		for {
 			time.Sleep(5 * time.Second)
 
+			mu.Lock()
 			ids := make([]string, rng.Int31n(200))
 			for i := range ids {
 				ids[i] = strconv.Itoa(rng.Int() % 100)
 			}
+			mu.Unlock()
 			body := bytes.NewBuffer([]byte(fmt.Sprintf(`{"object_ids":[%s]}`, strings.Join(ids, ","))))
-			resp, err := client.Post("http://localhost:9090/callback", "application/json", body)
+			resp, err := client.Post("http://localhost:9090/v1/callback", "application/json", body)
 			if err != nil {
 				fmt.Println(err)
 				continue
@@ -38,7 +56,7 @@ func main() {
 	}()
 
 	http.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(time.Duration(rng.Int63n(4000)+300) * time.Millisecond)
+		time.Sleep(latency())
 
 		idRaw := strings.TrimPrefix(r.URL.Path, "/objects/")
 		id, err := strconv.Atoi(idRaw)
@@ -47,8 +65,33 @@ func main() {
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(fmt.Sprintf(`{"id":%d,"online":%v}`, id, id%2 == 0)))
 	})
+	// Batch endpoint, GET /objects?ids=1,2,3. A batch takes as long as a single object.
+	http.HandleFunc("/objects", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		time.Sleep(latency())
+
+		var resp struct {
+			Objects []object `json:"objects"`
+		}
+		resp.Objects = []object{}
+		for _, idRaw := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			id, err := strconv.Atoi(idRaw)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			resp.Objects = append(resp.Objects, object{ID: id, Online: id%2 == 0})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
 	go func() { _ = http.ListenAndServe(":9010", nil) }()
 
 	sig := make(chan os.Signal, 1)
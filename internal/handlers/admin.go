@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+)
+
+// Limiters returns the handler reporting the current limit of every limiter of lr and the latencies
+// they observed, by upstream name. It is meant for the debug listener, next to pprof and expvar, so
+// it is a plain http.Handler.
+func Limiters(lr *models.LimiterRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ls := lr.Limiters()
+		stats := make(map[string]models.ConcurrencyLimiterStats, len(ls))
+		for name, l := range ls {
+			stats[name] = l.Stats()
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// objectIDsField is the body field holding the list of object IDs of a callback.
+const objectIDsField = "object_ids"
+
+// sourceField is the body field naming the source of the objects of a callback, used to route
+// them to their object service.
+const sourceField = "source"
+
+// Media types accepted on callback bodies.
+const (
+	mediaTypeJSON      = "application/json"
+	mediaTypeNDJSON    = "application/x-ndjson"
+	mediaTypeNDJSONAlt = "application/ndjson"
+	mediaTypeCSV       = "text/csv"
+
+	// mediaTypeForm bodies are read as JSON, see callbackMediaType.
+	mediaTypeForm = "application/x-www-form-urlencoded"
+)
+
+const (
+	// maxLineBytes is the maximum length of a single line of a line based body.
+	maxLineBytes = 64 * 1024
+
+	// maxLineErrors is the maximum number of line errors listed on a response. Further errors
+	// are only counted.
+	maxLineErrors = 100
+)
+
+// batcher groups IDs into slices of at most size elements and hands them to emit.
+type batcher struct {
+	size  int
+	batch []int64
+	emit  func([]int64) error
+}
+
+func newBatcher(size int, emit func([]int64) error) *batcher {
+	return &batcher{
+		size:  size,
+		batch: make([]int64, 0, size),
+		emit:  emit,
+	}
+}
+
+// add appends ids to the current batch, emitting it every time it is full.
+func (b *batcher) add(ids ...int64) error {
+	for _, id := range ids {
+		b.batch = append(b.batch, id)
+		if len(b.batch) == b.size {
+			if err := b.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush emits the current batch, if any.
+func (b *batcher) flush() error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	err := b.emit(b.batch)
+	b.batch = make([]int64, 0, b.size)
+	return err
+}
+
+// decodeObjectIDs reads a callback body of the form {"source":"...","object_ids":[...]} token by
+// token. IDs are handed to emit in batches of at most size elements as soon as they are parsed, so
+// the whole list is never held in memory. The optional source string is handed to setSource, and
+// must come before the IDs it applies to. Any other field of the body is skipped.
+func decodeObjectIDs(r io.Reader, size int, emit func([]int64) error, setSource func(string)) error {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	b := newBatcher(size, emit)
+	idsRead := false
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch key, _ := t.(string); key {
+		case objectIDsField:
+			if err := decodeIDList(dec, b); err != nil {
+				return err
+			}
+			idsRead = true
+		case sourceField:
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			// Sources that are not strings are skipped like any unknown field.
+			var source string
+			if json.Unmarshal(raw, &source) != nil {
+				continue
+			}
+			if idsRead {
+				return fmt.Errorf("handlers: %q must come before %q", sourceField, objectIDsField)
+			}
+			setSource(source)
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// decodeIDList streams the elements of a JSON array of integers to b. A null value is accepted
+// and treated as an empty list.
+func decodeIDList(dec *json.Decoder, b *batcher) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("handlers: expected array for %q, got %v", objectIDsField, t)
+	}
+
+	for dec.More() {
+		var id int64
+		if err := dec.Decode(&id); err != nil {
+			return err
+		}
+		if err := b.add(id); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token of dec and fails if it is not the delimiter d.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if got, ok := t.(json.Delim); !ok || got != d {
+		return fmt.Errorf("handlers: expected %v, got %v", d, t)
+	}
+	return nil
+}
+
+// lineError describes a line of a line based body that could not be ingested.
+type lineError struct {
+	Line   int    `json:"line"`
+	Error  string `json:"error"`
+	Detail string `json:"message"`
+}
+
+// lineReport is the response sent back for line based bodies.
+type lineReport struct {
+	// Lines is the number of non blank lines read.
+	Lines int `json:"lines"`
+	// Accepted is the number of object IDs read from the valid lines.
+	Accepted int `json:"accepted"`
+	// Rejected is the number of lines that could not be ingested.
+	Rejected int         `json:"rejected"`
+	Errors   []lineError `json:"errors"`
+}
+
+// reject records that line could not be ingested because of err, caused by cause.
+func (lr *lineReport) reject(line int, err PublicError, cause error) {
+	lr.Rejected++
+	if len(lr.Errors) < maxLineErrors {
+		lr.Errors = append(lr.Errors, lineError{
+			Line:   line,
+			Error:  err.Code(),
+			Detail: err.Detail() + ": " + cause.Error(),
+		})
+	}
+}
+
+// lineFailure is the error returned when a line based body can't be read past one of its lines.
+// It is presented to the API user as err, with the line number and cause added to its detail.
+type lineFailure struct {
+	line  int
+	err   PublicError
+	cause error
+}
+
+func (f *lineFailure) Error() string {
+	return fmt.Sprintf("%v on line %d: %v", f.err, f.line, f.cause)
+}
+
+// Code implements PublicError.
+func (f *lineFailure) Code() string { return f.err.Code() }
+
+// Detail implements PublicError.
+func (f *lineFailure) Detail() string {
+	return fmt.Sprintf("%s, on line %d: %v", f.err.Detail(), f.line, f.cause)
+}
+
+func (f *lineFailure) Unwrap() error { return f.err }
+
+// decodeNDJSON reads a newline delimited JSON body. Every line holds either a single object ID,
+// an object with an "object_id" field or an object with an "object_ids" list. Lines that can't be
+// decoded are recorded on the returned report and do not stop the ingestion. A body that can't be
+// read past a line, because the line is too long or the body is cut, fails with a lineFailure.
+func decodeNDJSON(r io.Reader, size int, emit func([]int64) error) (lineReport, error) {
+	report := lineReport{Errors: []lineError{}}
+	b := newBatcher(size, emit)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), maxLineBytes)
+
+	line := 1
+	for ; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		report.Lines++
+
+		ids, err := parseNDJSONLine(text)
+		if err != nil {
+			report.reject(line, ErrInvalidLine, err)
+			continue
+		}
+		report.Accepted += len(ids)
+		if err := b.add(ids...); err != nil {
+			return report, err
+		}
+	}
+	switch err := sc.Err(); {
+	case err == bufio.ErrTooLong:
+		return report, &lineFailure{line: line, err: ErrLineTooLong, cause: fmt.Errorf("longer than %d bytes", maxLineBytes)}
+	case errors.Is(err, ErrBodyTooLarge):
+		return report, err
+	case err != nil:
+		return report, &lineFailure{line: line, err: ErrInvalidLine, cause: err}
+	}
+
+	return report, b.flush()
+}
+
+// parseNDJSONLine extracts the object IDs held on a single NDJSON line.
+func parseNDJSONLine(text []byte) ([]int64, error) {
+	if text[0] != '{' {
+		var id int64
+		if err := json.Unmarshal(text, &id); err != nil {
+			return nil, err
+		}
+		return []int64{id}, nil
+	}
+
+	var v struct {
+		ID  *int64  `json:"object_id"`
+		IDs []int64 `json:"object_ids"`
+	}
+	if err := json.Unmarshal(text, &v); err != nil {
+		return nil, err
+	}
+	if v.ID == nil && v.IDs == nil {
+		return nil, errors.New("line holds no object ID")
+	}
+
+	ids := v.IDs
+	if v.ID != nil {
+		ids = append(ids, *v.ID)
+	}
+	return ids, nil
+}
+
+// decodeCSV reads a CSV body holding an object ID on the first column of every record. A first
+// record whose first column holds no digit at all is taken as a header and skipped. Records that
+// can't be decoded are recorded on the returned report, numbered from the first record, and do not
+// stop the ingestion.
+func decodeCSV(r io.Reader, size int, emit func([]int64) error) (lineReport, error) {
+	report := lineReport{Errors: []lineError{}}
+	b := newBatcher(size, emit)
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			report.Lines++
+			report.reject(line, ErrInvalidLine, perr.Err)
+			continue
+		}
+		if errors.Is(err, ErrBodyTooLarge) {
+			return report, err
+		}
+		if err != nil {
+			return report, &lineFailure{line: line, err: ErrInvalidLine, cause: err}
+		}
+
+		field := strings.TrimSpace(record[0])
+		if line == 1 && isCSVHeader(field) {
+			// The first record is a header, e.g. "object_id".
+			continue
+		}
+		report.Lines++
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			report.reject(line, ErrInvalidLine, err.(*strconv.NumError).Err)
+			continue
+		}
+
+		report.Accepted++
+		if err := b.add(id); err != nil {
+			return report, err
+		}
+	}
+
+	return report, b.flush()
+}
+
+// isCSVHeader reports whether field, the first column of the first record of a CSV body, is a
+// header. Fields holding any digit are taken as IDs, so mistyped ones are rejected instead of
+// being skipped.
+func isCSVHeader(field string) bool {
+	return !strings.ContainsAny(field, "0123456789")
+}
+
+// limitedBody wraps a request body with http.MaxBytesReader and reports ErrBodyTooLarge when the
+// limit is hit, so the caller can tell an oversized body from a malformed one.
+type limitedBody struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+// newLimitedBody returns a reader over r.Body that fails after limit bytes. A limit lower or
+// equal to zero disables the check.
+func newLimitedBody(w http.ResponseWriter, r *http.Request, limit int64) io.Reader {
+	if limit <= 0 {
+		return r.Body
+	}
+	return &limitedBody{
+		r:     http.MaxBytesReader(w, r.Body, limit),
+		limit: limit,
+	}
+}
+
+// Read implements io.Reader.
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.r.Read(p)
+	lb.n += int64(n)
+	if err != nil && err != io.EOF && lb.n >= lb.limit {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -3,7 +3,19 @@ package handlers
 // These errors are returned by the services and can be used to provide error codes to the
 // API results.
 const (
-	ErrInvalidJSONInput HandlerError = "handlers: invalid_json, provided input cannot be parsed"
+	ErrInvalidJSONInput     HandlerError = "handlers: invalid_json, provided input cannot be parsed"
+	ErrBodyTooLarge         HandlerError = "handlers: body_too_large, request body exceeds the configured limit"
+	ErrUnsupportedMedia     HandlerError = "handlers: unsupported_media_type, content type must be application/json, application/x-ndjson or text/csv"
+	ErrInvalidLine          HandlerError = "handlers: invalid_line, line does not hold a valid object ID"
+	ErrLineTooLong          HandlerError = "handlers: line_too_long, a line of the body exceeds the maximum length"
+	ErrInvalidEvent         HandlerError = "handlers: invalid_event, request is not a valid CloudEvent"
+	ErrEventInProgress      HandlerError = "handlers: event_in_progress, an event with the same source and id is being processed"
+	ErrTooManyObjects       HandlerError = "handlers: too_many_objects, callback holds more object IDs than allowed for the tenant"
+	ErrInvalidParameter     HandlerError = "handlers: invalid_parameter, a path or query parameter is not valid"
+	ErrStreamUnsupported    HandlerError = "handlers: stream_unsupported, connection can't stream events"
+	ErrInvalidFrame         HandlerError = "handlers: invalid_frame, frame type must be callback, subscribe or unsubscribe"
+	ErrTooManySubscriptions HandlerError = "handlers: too_many_subscriptions, connection follows more objects than allowed"
+	ErrUnsignedFrames       HandlerError = "handlers: unsigned_frames, callbacks must be signed, which socket frames can't be"
 )
 
 // PublicError is an error that returns a string code that can be presented to the API user.
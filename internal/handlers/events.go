@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+const (
+	// cloudEventsSpecVersion is the only version of the CloudEvents specification supported.
+	cloudEventsSpecVersion = "1.0"
+
+	// mediaTypeCloudEvent is the media type of a CloudEvent sent in structured mode.
+	mediaTypeCloudEvent = "application/cloudevents+json"
+)
+
+// Events defines the handlers receiving callbacks wrapped in CloudEvents.
+type Events struct {
+	csvc models.CallbackService
+	seen models.IdempotencyService
+
+	// maxBodyBytes limits the size of an event body. Zero means no limit.
+	maxBodyBytes int64
+
+	log *log.Logger
+}
+
+// NewEvents creates a new Events controller. Events are deduplicated by their source and id with
+// seen, for as long as it keeps their records. Request bodies bigger than maxBodyBytes are
+// rejected, a value of zero disables the limit.
+func NewEvents(csvc models.CallbackService, seen models.IdempotencyService, log *log.Logger, maxBodyBytes int64) *Events {
+
+	return &Events{
+		csvc:         csvc,
+		seen:         seen,
+		maxBodyBytes: maxBodyBytes,
+		log:          log,
+	}
+}
+
+// cloudEvent holds the attributes of a CloudEvent used by the service.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	DataBase64      string          `json:"data_base64"`
+}
+
+// eventResponse is the response sent back for every accepted event.
+type eventResponse struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// Duplicate is set when the event was already processed and has been ignored.
+	Duplicate bool        `json:"duplicate"`
+	Report    *lineReport `json:"report,omitempty"`
+}
+
+// Handle ingests a CloudEvent sent in either structured or binary HTTP mode. The event data is
+// decoded like the body of a callback, so it can hold JSON, newline delimited JSON or CSV
+// according to the event datacontenttype.
+//
+// Events are identified by their source and id. An event received again during the dedupe window
+// is acknowledged without being processed, once the first one was. Events received while the
+// first one is still being processed are rejected, to be retried.
+func (e *Events) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Events.Handle")
+	defer span.End()
+
+	mediaType, err := requestMediaType(r)
+	if err != nil {
+		web.RespondError(ctx, w, ErrUnsupportedMedia, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := newLimitedBody(w, r, e.maxBodyBytes)
+
+	var (
+		ce   cloudEvent
+		data io.Reader
+	)
+	if mediaType == mediaTypeCloudEvent {
+		ce, data, err = readStructuredEvent(body)
+	} else {
+		ce, data = readBinaryEvent(r, mediaType, body)
+	}
+	if err == nil {
+		err = ce.validate()
+	}
+	if err != nil {
+		respondDecodeError(ctx, w, err)
+		return
+	}
+
+	span.AddAttributes(
+		trace.StringAttribute("cloudevents.id", ce.ID),
+		trace.StringAttribute("cloudevents.source", ce.Source),
+		trace.StringAttribute("cloudevents.type", ce.Type),
+	)
+	resp := eventResponse{ID: ce.ID, Source: ce.Source}
+
+	// Events are deduplicated within the tenant they are sent for.
+	var tenant string
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+		tenant = v.TenantID
+	}
+	key := eventKey(tenant, ce.Source, ce.ID)
+	ik, claimed, err := e.seen.Claim(ctx, key, "")
+	if err != nil {
+		e.log.Printf("event_dedupe_error: %v", err)
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		if !ik.Completed() {
+			web.RespondError(ctx, w, ErrEventInProgress, http.StatusConflict)
+			return
+		}
+		resp.Duplicate = true
+		web.Respond(ctx, w, resp, http.StatusOK)
+		return
+	}
+
+	resp.Report, err = decodeBody(data, ce.DataContentType, newIngester(ctx, e.csvc))
+	if err != nil {
+		// Let the producer retry the event.
+		if err := e.seen.Release(ctx, ik); err != nil {
+			e.log.Printf("event_dedupe_error: %v", err)
+		}
+		respondDecodeError(ctx, w, err)
+		return
+	}
+	if err := e.seen.Complete(ctx, ik, http.StatusOK, "", nil); err != nil {
+		e.log.Printf("event_dedupe_error: %v", err)
+	}
+
+	web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// readStructuredEvent decodes an event sent in structured mode, where the whole event is the
+// JSON body of the request.
+func readStructuredEvent(body io.Reader) (cloudEvent, io.Reader, error) {
+	raw, err := ioutil.ReadAll(body)
+	if errors.Is(err, ErrBodyTooLarge) {
+		return cloudEvent{}, nil, err
+	}
+	if err != nil {
+		return cloudEvent{}, nil, ErrInvalidEvent
+	}
+
+	var ce cloudEvent
+	if err := json.Unmarshal(raw, &ce); err != nil {
+		return cloudEvent{}, nil, ErrInvalidEvent
+	}
+	if ce.DataContentType, err = parseMediaType(ce.DataContentType); err != nil {
+		return cloudEvent{}, nil, ErrInvalidEvent
+	}
+
+	switch {
+	case ce.DataBase64 != "":
+		data, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return cloudEvent{}, nil, ErrInvalidEvent
+		}
+		return ce, bytes.NewReader(data), nil
+
+	case len(ce.Data) > 0 && ce.Data[0] == '"' && ce.DataContentType != mediaTypeJSON:
+		// Non JSON data, like CSV, is carried as a JSON string.
+		var data string
+		if err := json.Unmarshal(ce.Data, &data); err != nil {
+			return cloudEvent{}, nil, ErrInvalidEvent
+		}
+		return ce, strings.NewReader(data), nil
+
+	default:
+		return ce, bytes.NewReader(ce.Data), nil
+	}
+}
+
+// readBinaryEvent reads an event sent in binary mode, where the attributes of the event travel
+// as ce- headers and the body of the request is the event data.
+func readBinaryEvent(r *http.Request, mediaType string, body io.Reader) (cloudEvent, io.Reader) {
+	ce := cloudEvent{
+		SpecVersion:     r.Header.Get("ce-specversion"),
+		ID:              r.Header.Get("ce-id"),
+		Source:          r.Header.Get("ce-source"),
+		Type:            r.Header.Get("ce-type"),
+		DataContentType: mediaType,
+	}
+	return ce, body
+}
+
+// validate checks the event holds the attributes required by the specification and normalizes
+// its data content type.
+func (ce *cloudEvent) validate() error {
+	if ce.SpecVersion != cloudEventsSpecVersion || ce.ID == "" || ce.Source == "" || ce.Type == "" {
+		return ErrInvalidEvent
+	}
+
+	// JSON is the default data content type, and any structured +json type is read as JSON.
+	if ce.DataContentType == "" || strings.HasSuffix(ce.DataContentType, "+json") {
+		ce.DataContentType = mediaTypeJSON
+	}
+	return nil
+}
+
+// eventKey returns the key the event of source and id, sent for tenant, is deduplicated by. The
+// attributes are hashed so the key fits the records of the idempotency keys, whatever their length,
+// and prefixed to never match the key of a request.
+func eventKey(tenant, source, id string) string {
+	h := sha256.Sum256([]byte(tenant + "\n" + source + "\n" + id))
+	return "event:" + hex.EncodeToString(h[:])
+}
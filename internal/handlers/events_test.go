@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+)
+
+// memoryIdempotency is an in-memory models.IdempotencyService.
+type memoryIdempotency struct {
+	keys map[string]models.IdempotencyKey
+}
+
+func (m *memoryIdempotency) Claim(ctx context.Context, key, requestHash string) (models.IdempotencyKey, bool, error) {
+	if ik, ok := m.keys[key]; ok {
+		return ik, false, nil
+	}
+	m.keys[key] = models.IdempotencyKey{Key: key, RequestHash: requestHash}
+	return m.keys[key], true, nil
+}
+
+func (m *memoryIdempotency) Complete(ctx context.Context, claim models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	now := time.Now()
+	ik := m.keys[claim.Key]
+	ik.StatusCode, ik.ContentType, ik.Body, ik.CompletedAt = statusCode, contentType, body, &now
+	m.keys[claim.Key] = ik
+	return nil
+}
+
+func (m *memoryIdempotency) Release(ctx context.Context, claim models.IdempotencyKey) error {
+	delete(m.keys, claim.Key)
+	return nil
+}
+
+func (m *memoryIdempotency) WithWindow(window time.Duration) models.IdempotencyService { return m }
+
+func (m *memoryIdempotency) Close() {}
+
+func TestEvents_Handle(t *testing.T) {
+	csvc := &testCallbackService{}
+	seen := &memoryIdempotency{keys: make(map[string]models.IdempotencyKey)}
+	e := NewEvents(csvc, seen, nil, 16*1024)
+
+	var cases = []struct {
+		name      string
+		headers   map[string]string
+		input     string
+		outStatus int
+		outJSON   string
+		outIDs    []int64
+		upsertErr error
+		setup     func()
+	}{
+		{
+			"structured",
+			map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"},
+			`{"specversion":"1.0","id":"e1","source":"/exporter","type":"objects.seen",
+				"data":{"object_ids":[91,10,91]}}`,
+			http.StatusOK,
+			`{"id":"e1","source":"/exporter","duplicate":false}`,
+			[]int64{91, 10},
+			nil,
+			nil,
+		},
+		{
+			"structuredDuplicate",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e1","source":"/exporter","type":"objects.seen",
+				"data":{"object_ids":[91,10]}}`,
+			http.StatusOK,
+			`{"id":"e1","source":"/exporter","duplicate":true}`,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"structuredSameIDOtherSource",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e1","source":"/other","type":"objects.seen",
+				"datacontenttype":"text/csv","data":"78\n11\n"}`,
+			http.StatusOK,
+			`{"id":"e1","source":"/other","duplicate":false,
+				"report":{"lines":2,"accepted":2,"rejected":0,"errors":[]}}`,
+			[]int64{78, 11},
+			nil,
+			nil,
+		},
+		{
+			"structuredBase64",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e2","source":"/exporter","type":"objects.seen",
+				"datacontenttype":"application/x-ndjson","data_base64":"OTEKMTAK"}`,
+			http.StatusOK,
+			`{"id":"e2","source":"/exporter","duplicate":false,
+				"report":{"lines":2,"accepted":2,"rejected":0,"errors":[]}}`,
+			[]int64{91, 10},
+			nil,
+			nil,
+		},
+		{
+			"binary",
+			map[string]string{
+				"Content-Type":   "application/vnd.objects+json",
+				"ce-specversion": "1.0",
+				"ce-id":          "e3",
+				"ce-source":      "/exporter",
+				"ce-type":        "objects.seen",
+			},
+			`{"object_ids":[22,33]}`,
+			http.StatusOK,
+			`{"id":"e3","source":"/exporter","duplicate":false}`,
+			[]int64{22, 33},
+			nil,
+			nil,
+		},
+		{
+			"binaryMissingAttributes",
+			map[string]string{"ce-specversion": "1.0", "ce-id": "e4"},
+			`{"object_ids":[22,33]}`,
+			http.StatusBadRequest,
+			`{"error":"invalid_event","message":"request is not a valid CloudEvent"}`,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"structuredNotJSON",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`specversion=1.0`,
+			http.StatusBadRequest,
+			`{"error":"invalid_event","message":"request is not a valid CloudEvent"}`,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"upsertErrorAllowsRetry",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e5","source":"/exporter","type":"objects.seen",
+				"data":{"object_ids":[40]}}`,
+			http.StatusNotAcceptable,
+			`{"error":"server_connection_error","message":"provided server can't be reached"}`,
+			[]int64{40},
+			models.ErrServerNotReachable,
+			nil,
+		},
+		{
+			"retried",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e5","source":"/exporter","type":"objects.seen",
+				"data":{"object_ids":[40]}}`,
+			http.StatusOK,
+			`{"id":"e5","source":"/exporter","duplicate":false}`,
+			[]int64{40},
+			nil,
+			nil,
+		},
+		{
+			"inProgress",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion":"1.0","id":"e6","source":"/exporter","type":"objects.seen",
+				"data":{"object_ids":[50]}}`,
+			http.StatusConflict,
+			`{"error":"event_in_progress","message":"an event with the same source and id is being processed"}`,
+			nil,
+			nil,
+			func() { seen.Claim(context.Background(), eventKey("", "/exporter", "e6"), "") },
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if cs.setup != nil {
+				cs.setup()
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(cs.input))
+			for k, v := range cs.headers {
+				r.Header.Set(k, v)
+			}
+			ctx := NewTestContext()
+
+			var ids []int64
+			csvc.upsert = func(ctx context.Context, cbs []models.Callback) error {
+				for _, c := range cbs {
+					ids = append(ids, c.ID)
+				}
+				return cs.upsertErr
+			}
+
+			e.Handle(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodPost, "/events", w)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+			assert.Equal(t, cs.outIDs, ids)
+
+			*csvc = testCallbackService{}
+		})
+	}
+}
@@ -13,53 +13,62 @@ import (
 	"github.com/noelruault/go-callback-service/internal/web"
 )
 
+// streamBatchSize is the maximum number of object IDs sent to the callback service at once while
+// a callback body is still being read.
+const streamBatchSize = 500
+
 // Callback defines all of the handlers related to products. It holds the application state needed by the handler methods.
 type Callback struct {
 	csvc models.CallbackService
 
+	// maxBodyBytes limits the size of a callback body. Zero means no limit.
+	maxBodyBytes int64
+
 	log *log.Logger
 }
 
-// NewCallbacks creates a new Callback controller.
-func NewCallbacks(csvc models.CallbackService, log *log.Logger) *Callback {
+// NewCallbacks creates a new Callback controller. Request bodies bigger than maxBodyBytes are
+// rejected, a value of zero disables the limit.
+func NewCallbacks(csvc models.CallbackService, log *log.Logger, maxBodyBytes int64) *Callback {
 
 	return &Callback{
-		csvc: csvc,
-		log:  log,
+		csvc:         csvc,
+		maxBodyBytes: maxBodyBytes,
+		log:          log,
 	}
 }
 
-type callbackRequest struct {
-	Objects []int64 `json:"object_ids"`
-}
-
-// Retrieve finds a single product identified by an ID in the request URL.
+// Handle streams the object IDs of a callback body into the callback service. IDs are forwarded
+// in batches while the body is being parsed, so very large callbacks are never buffered whole.
+//
+// The body format is negotiated through the Content-Type header: JSON (the default, forms being
+// read as JSON too), newline delimited JSON and CSV are accepted. Every format goes through the
+// same deduplication and processing path. Line based formats answer with a report listing the
+// lines that were rejected.
+//
+// Batches forwarded before a malformed part of the body is found are not rolled back.
 func (c *Callback) Handle(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	ctx, span := trace.StartSpan(ctx, "handlers.Callback.Handle")
 	defer span.End()
 
-	var cr callbackRequest
-	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
-		web.RespondError(ctx, w, ErrInvalidJSONInput, http.StatusBadRequest)
+	mediaType, err := callbackMediaType(r)
+	if err != nil {
+		web.RespondError(ctx, w, err, http.StatusUnsupportedMediaType)
 		return
 	}
+	span.AddAttributes(trace.StringAttribute("content_type", mediaType))
 
-	// Remove any possible duplicate value on the request IDs
-	ids := removeDuplicateValues(cr.Objects)
-
-	// Build a slice of Callbacks that will be upserted
-	var callbackList []models.Callback
-	for _, id := range ids {
-		callbackList = append(callbackList, models.Callback{ID: id})
-	}
-
-	// Send Callback slice to Upsert method
-	err := c.csvc.Upsert(ctx, callbackList)
+	body := newLimitedBody(w, r, c.maxBodyBytes)
+	report, err := decodeBody(body, mediaType, newIngester(ctx, c.csvc))
 	if err != nil {
-		web.RespondError(ctx, w, err, http.StatusNotAcceptable)
+		respondDecodeError(ctx, w, err)
 		return
 	}
 
+	if report != nil {
+		web.Respond(ctx, w, report, http.StatusOK)
+		return
+	}
 	web.Respond(ctx, w, struct{}{}, http.StatusOK)
 }
 
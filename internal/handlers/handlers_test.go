@@ -3,8 +3,10 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -30,9 +32,23 @@ func NewTestContext() context.Context {
 	return context.WithValue(context.Background(), web.KeyValues, &web.Values{})
 }
 
+// objectIDsBody builds a callback body holding the IDs from 0 to n-1.
+func objectIDsBody(n int) string {
+	var b bytes.Buffer
+	b.WriteString(`{"object_ids":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprint(&b, i)
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}
+
 func TestCallback_Handle(t *testing.T) {
 	csvc := &testCallbackService{}
-	c := NewCallbacks(csvc, nil)
+	c := NewCallbacks(csvc, nil, 16*1024)
 
 	var cases = []struct {
 		name      string
@@ -72,6 +88,102 @@ func TestCallback_Handle(t *testing.T) {
 				}
 			},
 		},
+		{
+			"unknownFieldsSkipped",
+			`{"source": {"name": "exporter"}, "object_ids": [91,10], "tags": ["a"]}`,
+			http.StatusOK,
+			`{}`,
+			func(t *testing.T) {
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					assert.Equal(t, []models.Callback{{ID: 91}, {ID: 10}}, cs)
+					return nil
+				}
+			},
+		},
+		{
+			"source",
+			`{"source": "catalog-feed", "object_ids": [91,10]}`,
+			http.StatusOK,
+			`{}`,
+			func(t *testing.T) {
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					assert.Equal(t, []models.Callback{{ID: 91, Source: "catalog-feed"}, {ID: 10, Source: "catalog-feed"}}, cs)
+					return nil
+				}
+			},
+		},
+		{
+			"sourceAfterIDs",
+			`{"object_ids": [91,10], "source": "catalog-feed"}`,
+			http.StatusBadRequest,
+			`{"error":"invalid_json","message":"provided input cannot be parsed"}`,
+			func(t *testing.T) {
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					return nil
+				}
+			},
+		},
+		{
+			"notIntegerID",
+			`{"object_ids": [91, "10"]}`,
+			http.StatusBadRequest,
+			`{"error":"invalid_json","message":"provided input cannot be parsed"}`,
+			nil,
+		},
+		{
+			"streamedInBatches",
+			objectIDsBody(2*streamBatchSize + 10),
+			http.StatusOK,
+			`{}`,
+			func(t *testing.T) {
+				var calls []int
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					calls = append(calls, len(cs))
+					return nil
+				}
+				t.Cleanup(func() {
+					assert.Equal(t, []int{streamBatchSize, streamBatchSize, 10}, calls)
+				})
+			},
+		},
+		{
+			"duplicatesAcrossBatches",
+			strings.TrimSuffix(objectIDsBody(streamBatchSize), "]}") + ",1,2,3]}",
+			http.StatusOK,
+			`{}`,
+			func(t *testing.T) {
+				calls := 0
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					calls++
+					return nil
+				}
+				t.Cleanup(func() {
+					assert.Equal(t, 1, calls, "the second batch only holds duplicated IDs")
+				})
+			},
+		},
+		{
+			"bodyTooLarge",
+			objectIDsBody(5000),
+			http.StatusRequestEntityTooLarge,
+			`{"error":"body_too_large","message":"request body exceeds the configured limit"}`,
+			func(t *testing.T) {
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					return nil
+				}
+			},
+		},
+		{
+			"upsertError",
+			`{"object_ids": [91,10,78]}`,
+			http.StatusNotAcceptable,
+			`{"error":"server_connection_error","message":"provided server can't be reached"}`,
+			func(t *testing.T) {
+				csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+					return models.ErrServerNotReachable
+				}
+			},
+		},
 	}
 
 	for _, cs := range cases {
@@ -87,9 +199,172 @@ func TestCallback_Handle(t *testing.T) {
 			c.Handle(ctx, w, r)
 
 			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodPost, "/callback", w)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+
+			*csvc = testCallbackService{}
+		})
+	}
+}
+
+func TestCallback_HandleFormats(t *testing.T) {
+	csvc := &testCallbackService{}
+	c := NewCallbacks(csvc, nil, 0)
+
+	var cases = []struct {
+		name        string
+		contentType string
+		input       string
+		outStatus   int
+		outJSON     string
+		outIDs      []int64
+	}{
+		{
+			"jsonWithCharset",
+			"application/json; charset=utf-8",
+			`{"object_ids": [91,10]}`,
+			http.StatusOK,
+			`{}`,
+			[]int64{91, 10},
+		},
+		{
+			"form",
+			"application/x-www-form-urlencoded",
+			`{"object_ids": [91,10]}`,
+			http.StatusOK,
+			`{}`,
+			[]int64{91, 10},
+		},
+		{
+			"unsupported",
+			"text/xml",
+			`<object_ids><id>91</id></object_ids>`,
+			http.StatusUnsupportedMediaType,
+			`{"error":"unsupported_media_type","message":"content type must be application/json, application/x-ndjson or text/csv"}`,
+			nil,
+		},
+		{
+			"unparsable",
+			"json;",
+			`{"object_ids": [91]}`,
+			http.StatusUnsupportedMediaType,
+			`{"error":"unsupported_media_type","message":"content type must be application/json, application/x-ndjson or text/csv"}`,
+			nil,
+		},
+		{
+			"ndjson",
+			"application/x-ndjson",
+			"91\n{\"object_id\": 10}\n\n{\"object_ids\": [78, 91]}\n",
+			http.StatusOK,
+			`{"lines":3,"accepted":4,"rejected":0,"errors":[]}`,
+			[]int64{91, 10, 78},
+		},
+		{
+			"ndjsonInvalidLines",
+			"application/x-ndjson",
+			"91\n\"10\"\n{\"id\": 78}\n{\"object_id\": 11\n12\n",
+			http.StatusOK,
+			`{"lines":5,"accepted":2,"rejected":3,"errors":[
+				{"line":2,"error":"invalid_line","message":"line does not hold a valid object ID: json: cannot unmarshal string into Go value of type int64"},
+				{"line":3,"error":"invalid_line","message":"line does not hold a valid object ID: line holds no object ID"},
+				{"line":4,"error":"invalid_line","message":"line does not hold a valid object ID: unexpected end of JSON input"}
+			]}`,
+			[]int64{91, 12},
+		},
+		{
+			"ndjsonLineTooLong",
+			"application/x-ndjson",
+			"91\n" + strings.Repeat(" ", maxLineBytes+1) + "10\n",
+			http.StatusBadRequest,
+			`{"error":"line_too_long","message":"a line of the body exceeds the maximum length, on line 2: longer than 65536 bytes"}`,
+			nil,
+		},
+		{
+			"csvWithHeader",
+			"text/csv",
+			"object_id,name\n91,a\n10,b\n91,c\n",
+			http.StatusOK,
+			`{"lines":3,"accepted":3,"rejected":0,"errors":[]}`,
+			[]int64{91, 10},
+		},
+		{
+			"csvInvalidLines",
+			"text/csv; charset=utf-8",
+			"91\nten\n\"78\n",
+			http.StatusOK,
+			`{"lines":3,"accepted":1,"rejected":2,"errors":[
+				{"line":2,"error":"invalid_line","message":"line does not hold a valid object ID: invalid syntax"},
+				{"line":3,"error":"invalid_line","message":"line does not hold a valid object ID: extraneous or missing \" in quoted-field"}
+			]}`,
+			[]int64{91},
+		},
+		{
+			"csvInvalidFirstLine",
+			"text/csv",
+			"9l\n10\n",
+			http.StatusOK,
+			`{"lines":2,"accepted":1,"rejected":1,"errors":[
+				{"line":1,"error":"invalid_line","message":"line does not hold a valid object ID: invalid syntax"}
+			]}`,
+			[]int64{10},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(cs.input))
+			r.Header.Set("Content-Type", cs.contentType)
+			ctx := NewTestContext()
+
+			var ids []int64
+			csvc.upsert = func(ctx context.Context, cs []models.Callback) error {
+				for _, c := range cs {
+					ids = append(ids, c.ID)
+				}
+				return nil
+			}
+
+			c.Handle(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodPost, "/callback", w)
 			assert.JSONEq(t, cs.outJSON, w.Body.String())
+			assert.Equal(t, cs.outIDs, ids)
 
 			*csvc = testCallbackService{}
 		})
 	}
 }
+
+func TestDecodeBody_maxObjects(t *testing.T) {
+	var cases = []struct {
+		name    string
+		input   string
+		outErr  error
+		outIDs  int
+		batches int
+	}{
+		{"withinLimit", objectIDsBody(600), nil, 600, 2},
+		{"overLimit", objectIDsBody(601), ErrTooManyObjects, 0, 0},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			var ids, batches int
+			csvc := &testCallbackService{upsert: func(ctx context.Context, cbs []models.Callback) error {
+				ids += len(cbs)
+				batches++
+				return nil
+			}}
+			in := newIngester(NewTestContext(), csvc)
+			in.tenant = models.Tenant{ID: "acme", MaxObjectsPerCallback: 600}
+
+			_, err := decodeBody(strings.NewReader(cs.input), mediaTypeJSON, in)
+
+			assert.Equal(t, cs.outErr, err)
+			assert.Equal(t, cs.outIDs, ids, "callbacks over the limit store no object")
+			assert.Equal(t, cs.batches, batches)
+		})
+	}
+}
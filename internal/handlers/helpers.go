@@ -1,16 +1,220 @@
 package handlers
 
-func removeDuplicateValues(objects []int64) []int64 {
-	keys := make(map[int64]bool)
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// ingester is the processing pipeline shared by every callback body format. It removes the IDs
+// already seen on the same request and sends the remaining ones to the callback service, on
+// behalf of the tenant of the request.
+//
+// The IDs of a tenant limiting the objects of a callback are held back until the whole callback
+// is read, so a callback over the limit is rejected before any of its objects is stored. Callers
+// must flush the ingester once the callback is read.
+type ingester struct {
+	ctx    context.Context
+	csvc   models.CallbackService
+	tenant models.Tenant
+	seen   map[int64]bool
+	// source is the source named by the body, routing the objects to their object service.
+	source string
+	// pending holds the IDs held back, at most the limit of the tenant.
+	pending []int64
+}
+
+func newIngester(ctx context.Context, csvc models.CallbackService) *ingester {
+	// Requests not going through the Tenant middleware leave the tenant to the models default.
+	t, _ := mw.TenantFromContext(ctx)
+
+	return &ingester{
+		ctx:    ctx,
+		csvc:   csvc,
+		tenant: t,
+		seen:   make(map[int64]bool),
+	}
+}
+
+// push forwards a batch of IDs to the callback service, or holds it back if the tenant limits the
+// objects of a callback. Errors coming from the service are wrapped in an upsertError so they can
+// be told apart from decoding errors.
+func (in *ingester) push(ids []int64) error {
+	ids = in.removeSeenValues(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	if max := in.tenant.MaxObjectsPerCallback; max > 0 {
+		if len(in.seen) > max {
+			return ErrTooManyObjects
+		}
+		in.pending = append(in.pending, ids...)
+		return nil
+	}
+	return in.upsert(ids)
+}
+
+// flush forwards the IDs held back by push, in batches of streamBatchSize.
+func (in *ingester) flush() error {
+	for len(in.pending) > 0 {
+		n := len(in.pending)
+		if n > streamBatchSize {
+			n = streamBatchSize
+		}
+		if err := in.upsert(in.pending[:n]); err != nil {
+			return err
+		}
+		in.pending = in.pending[n:]
+	}
+	return nil
+}
+
+// upsert sends ids to the callback service.
+func (in *ingester) upsert(ids []int64) error {
+	// Build a slice of Callbacks that will be upserted
+	callbackList := make([]models.Callback, 0, len(ids))
+	for _, id := range ids {
+		callbackList = append(callbackList, models.Callback{Tenant: in.tenant.ID, ID: id, Source: in.source})
+	}
+
+	if err := in.csvc.Upsert(in.ctx, callbackList); err != nil {
+		return &upsertError{err}
+	}
+	return nil
+}
+
+// setSource sets the source of the objects pushed from now on.
+func (in *ingester) setSource(source string) {
+	in.source = source
+}
+
+// removeSeenValues returns the values of objects that were not pushed before, without duplicates.
+func (in *ingester) removeSeenValues(objects []int64) []int64 {
 	list := []int64{}
 
-	// If the key(values of the slice) is not equal to the already present value in new slice (list)
-	// then we append it, else we jump on another element.
+	// If the key(values of the slice) is not equal to an already seen value then we append it,
+	// else we jump on another element.
 	for _, entry := range objects {
-		if _, value := keys[entry]; !value {
-			keys[entry] = true
+		if !in.seen[entry] {
+			in.seen[entry] = true
 			list = append(list, entry)
 		}
 	}
 	return list
 }
+
+// upsertError wraps an error returned by the callback service while ingesting a body.
+type upsertError struct {
+	err error
+}
+
+func (e *upsertError) Error() string { return e.err.Error() }
+
+func (e *upsertError) Unwrap() error { return e.err }
+
+// requestMediaType returns the media type of the body of r, without parameters. Requests with no
+// Content-Type header are taken as JSON.
+func requestMediaType(r *http.Request) (string, error) {
+	mediaType, err := parseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "" && err == nil {
+		return mediaTypeJSON, nil
+	}
+	return mediaType, err
+}
+
+// callbackMediaType returns the media type a callback body sent with r is decoded as. Forms are
+// taken as JSON, like they were before the line based formats were accepted, as clients such as
+// curl send JSON bodies as forms by default. Other content types fail with ErrUnsupportedMedia.
+func callbackMediaType(r *http.Request) (string, error) {
+	mediaType, err := requestMediaType(r)
+	if err != nil {
+		return "", ErrUnsupportedMedia
+	}
+	switch mediaType {
+	case mediaTypeJSON, mediaTypeNDJSON, mediaTypeNDJSONAlt, mediaTypeCSV:
+		return mediaType, nil
+	case mediaTypeForm:
+		return mediaTypeJSON, nil
+	}
+	return "", ErrUnsupportedMedia
+}
+
+// parseMediaType returns the media type of the content type ct, without parameters. An empty
+// ct is returned as is.
+func parseMediaType(ct string) (string, error) {
+	if ct == "" {
+		return "", nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// decodeBody feeds the object IDs of body, encoded as mediaType, into in. Line based formats
+// return a report of the lines read, JSON bodies return a nil report. Media types that are not
+// line based are decoded as JSON.
+//
+// Malformed bodies are reported with the HandlerError matching their format, line based ones
+// with the line the body could not be read past.
+func decodeBody(body io.Reader, mediaType string, in *ingester) (*lineReport, error) {
+	var (
+		report *lineReport
+		err    error
+	)
+	switch mediaType {
+	case mediaTypeNDJSON, mediaTypeNDJSONAlt:
+		report = &lineReport{}
+		*report, err = decodeNDJSON(body, streamBatchSize, in.push)
+	case mediaTypeCSV:
+		report = &lineReport{}
+		*report, err = decodeCSV(body, streamBatchSize, in.push)
+	default:
+		err = decodeObjectIDs(body, streamBatchSize, in.push, in.setSource)
+		var uerr *upsertError
+		if err != nil && !errors.As(err, &uerr) && !errors.Is(err, ErrBodyTooLarge) && !errors.Is(err, ErrTooManyObjects) {
+			err = ErrInvalidJSONInput
+		}
+	}
+	if err == nil {
+		err = in.flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// respondDecodeError answers a request whose body could not be ingested with the status code
+// matching err.
+func respondDecodeError(ctx context.Context, w http.ResponseWriter, err error) {
+	var uerr *upsertError
+	switch {
+	case errors.As(err, &uerr):
+		web.RespondError(ctx, w, uerr.err, http.StatusNotAcceptable)
+	case errors.Is(err, ErrBodyTooLarge):
+		web.RespondError(ctx, w, ErrBodyTooLarge, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrUnsupportedMedia):
+		web.RespondError(ctx, w, ErrUnsupportedMedia, http.StatusUnsupportedMediaType)
+	case errors.Is(err, ErrTooManyObjects):
+		web.RespondError(ctx, w, ErrTooManyObjects, http.StatusUnprocessableEntity)
+	default:
+		web.RespondError(ctx, w, err, http.StatusBadRequest)
+	}
+}
+
+// requestTenant returns the ID of the tenant the request is made for.
+func requestTenant(ctx context.Context) string {
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok && v.TenantID != "" {
+		return v.TenantID
+	}
+	return models.DefaultTenant
+}
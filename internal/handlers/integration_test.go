@@ -4,17 +4,23 @@ package handlers_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/noelruault/go-callback-service/internal/handlers"
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
 	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
 )
 
 const (
@@ -31,8 +37,8 @@ func TestCallback_Integration(t *testing.T) {
 	}()
 
 	testlog := log.New(log.Writer(), "test", 0)
-	csvc := models.NewCallbackService(tdb, serverCallbackURL, testlog)
-	c := handlers.NewCallbacks(csvc, testlog)
+	csvc := models.NewCallbackService(tdb, models.NewTenantService(tdb), models.Upstream{URL: serverCallbackURL}, testlog)
+	c := handlers.NewCallbacks(csvc, testlog, 0)
 
 	_, err := http.Get(fmt.Sprintf("%s%s", serverCallbackURL, serverObjectsEndpointURL))
 	assert.NoError(t, err, "The service/endpoint is not reachable")
@@ -94,3 +100,88 @@ func TestCallback_Integration(t *testing.T) {
 		})
 	}
 }
+
+func TestAPI_OpenAPI(t *testing.T) {
+	tdb := models.NewTestDatabase(t)
+	defer models.CleanupTestDatabase(tdb)
+
+	api, stop := handlers.API(log.New(ioutil.Discard, "", 0), tdb, handlers.Config{})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every route is documented, and every documented operation is routed. The version and tenant
+	// prefixes are described by the servers of the document, so routes are matched without them.
+	documented := make(map[string]bool)
+	for route, item := range doc.Paths {
+		for method := range item {
+			if method != "servers" {
+				documented[strings.ToUpper(method)+" "+route] = true
+			}
+		}
+	}
+	prefix := regexp.MustCompile(`^(/v[0-9]+)?(/t/{[^}]+})?`)
+	routed := make(map[string]bool)
+	err := api.(*web.App).Walk(func(method, route string) error {
+		if path := prefix.ReplaceAllString(route, ""); path != "" {
+			route = path
+		}
+		op := method + " " + route
+		assert.True(t, documented[op], "%s is not documented on openapi.json", op)
+		routed[op] = true
+		return nil
+	})
+	assert.NoError(t, err)
+	for op := range documented {
+		assert.True(t, routed[op], "%s is documented on openapi.json but not routed", op)
+	}
+}
+
+func TestAPI_versions(t *testing.T) {
+	tdb := models.NewTestDatabase(t)
+	defer models.CleanupTestDatabase(tdb)
+
+	since := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	sunset := since.AddDate(0, 6, 0)
+	api, stop := handlers.API(log.New(ioutil.Discard, "", 0), tdb, handlers.Config{
+		Deprecations: map[string]mw.DeprecationConfig{
+			"GET /objects": {Since: since, Sunset: sunset},
+		},
+	})
+	defer stop()
+
+	var cases = []struct {
+		path          string
+		outDeprecated bool
+	}{
+		{"/objects", true},
+		{"/t/default/objects", true},
+		{"/v1/objects", false},
+		{"/v1/t/default/objects", false},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, cs.path, nil))
+
+			assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+			if cs.outDeprecated {
+				assert.Equal(t, fmt.Sprintf("@%d", since.Unix()), w.Header().Get("Deprecation"))
+				assert.Equal(t, "Mon, 19 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+				return
+			}
+			assert.Empty(t, w.Header().Get("Deprecation"))
+			assert.Empty(t, w.Header().Get("Sunset"))
+		})
+	}
+}
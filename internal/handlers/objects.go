@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// Objects defines the handlers reading the stored objects. Every handler is scoped to the tenant
+// of the request, so the objects of other tenants are never returned.
+type Objects struct {
+	csvc models.CallbackService
+
+	log *log.Logger
+}
+
+// NewObjects creates a new Objects controller.
+func NewObjects(csvc models.CallbackService, log *log.Logger) *Objects {
+
+	return &Objects{
+		csvc: csvc,
+		log:  log,
+	}
+}
+
+// objectList is the response sent back when listing objects.
+type objectList struct {
+	Objects []models.Callback `json:"objects"`
+	// NextAfter is the value of the after parameter fetching the next page, if any.
+	NextAfter *int64 `json:"next_after,omitempty"`
+}
+
+// payloadParam prefixes the query parameters filtering the objects by their payload, such as
+// payload.location.region=eu.
+const payloadParam = "payload."
+
+// maxPayloadMatches is the maximum number of payload filters of a single request.
+const maxPayloadMatches = 10
+
+// List returns the objects of the tenant ordered by ID. Results are paged through the after and
+// limit query parameters, and filtered by the payload.<path> ones.
+func (o *Objects) List(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Objects.List")
+	defer span.End()
+
+	var (
+		filter models.CallbackFilter
+		err    error
+	)
+	q := r.URL.Query()
+	if after := q.Get("after"); after != "" {
+		if filter.AfterID, err = strconv.ParseInt(after, 10, 64); err != nil {
+			web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+			return
+		}
+	}
+	for key, values := range q {
+		if !strings.HasPrefix(key, payloadParam) {
+			continue
+		}
+		path, err := models.ParsePayloadPath(strings.TrimPrefix(key, payloadParam))
+		if err != nil {
+			web.RespondError(ctx, w, err, http.StatusBadRequest)
+			return
+		}
+		for _, v := range values {
+			filter.Payload = append(filter.Payload, models.PayloadMatch{Path: path, Value: v})
+		}
+	}
+	if len(filter.Payload) > maxPayloadMatches {
+		web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	// Limits above the maximum are capped, and full pages are followed by another one.
+	filter.Limit = filter.PageSize()
+	cs, err := o.csvc.List(ctx, requestTenant(ctx), filter)
+	if err != nil {
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := objectList{Objects: cs}
+	if len(cs) == filter.Limit {
+		resp.NextAfter = &cs[len(cs)-1].ID
+	}
+	web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Retrieve returns the object identified by the id URL parameter.
+func (o *Objects) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Objects.Retrieve")
+	defer span.End()
+
+	id, err := strconv.ParseInt(web.Param(r, "id"), 10, 64)
+	if err != nil {
+		web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	c, err := o.csvc.Find(ctx, requestTenant(ctx), id)
+	switch {
+	case err == models.ErrNotFound:
+		web.RespondError(ctx, w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	web.Respond(ctx, w, c, http.StatusOK)
+}
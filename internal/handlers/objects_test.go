@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// testObjectService is a models.CallbackService storing the objects of the "acme" tenant.
+type testObjectService struct {
+	models.CallbackService
+	objects []models.Callback
+}
+
+func (t *testObjectService) Find(ctx context.Context, tenant string, id int64) (models.Callback, error) {
+	for _, c := range t.objects {
+		if c.Tenant == tenant && c.ID == id {
+			return c, nil
+		}
+	}
+	return models.Callback{}, models.ErrNotFound
+}
+
+func (t *testObjectService) List(ctx context.Context, tenant string, filter models.CallbackFilter) ([]models.Callback, error) {
+	cs := []models.Callback{}
+	for _, c := range t.objects {
+		if c.Tenant == tenant && c.ID > filter.AfterID && (filter.Limit == 0 || len(cs) < filter.Limit) &&
+			payloadMatches(c.Payload, filter.Payload) {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+// payloadMatches reports whether payload holds every match, comparing the values as text.
+func payloadMatches(payload models.JSONB, matches []models.PayloadMatch) bool {
+	for _, pm := range matches {
+		var v interface{}
+		if json.Unmarshal(payload, &v) != nil {
+			return false
+		}
+		for _, k := range pm.Path {
+			doc, ok := v.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			v = doc[k]
+		}
+		text, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			text = string(b)
+		}
+		if text != pm.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestObjectService() *testObjectService {
+	return &testObjectService{objects: []models.Callback{
+		{Tenant: "acme", ID: 1, Online: true, Timestamp: 10},
+		{Tenant: "acme", ID: 2, Online: true, Timestamp: 20, Payload: models.JSONB(`{"id":2,"location":{"region":"eu"}}`)},
+		{Tenant: "acme", ID: 3, Online: true, Timestamp: 30, Payload: models.JSONB(`{"id":3,"location":{"region":"us"}}`)},
+		{Tenant: models.DefaultTenant, ID: 4, Online: true, Timestamp: 40},
+	}}
+}
+
+func TestObjects_List(t *testing.T) {
+	o := NewObjects(newTestObjectService(), nil)
+
+	var cases = []struct {
+		name      string
+		tenant    string
+		query     string
+		outStatus int
+		outJSON   string
+	}{
+		{
+			"defaultTenant",
+			"", "",
+			http.StatusOK,
+			`{"objects":[{"tenant":"default","id":4,"online":true,"timestamp":40}]}`,
+		},
+		{
+			"tenant",
+			"acme", "",
+			http.StatusOK,
+			`{"objects":[
+				{"tenant":"acme","id":1,"online":true,"timestamp":10},
+				{"tenant":"acme","id":2,"online":true,"timestamp":20,"payload":{"id":2,"location":{"region":"eu"}}},
+				{"tenant":"acme","id":3,"online":true,"timestamp":30,"payload":{"id":3,"location":{"region":"us"}}}
+			]}`,
+		},
+		{
+			"page",
+			"acme", "?after=1&limit=1",
+			http.StatusOK,
+			`{"objects":[{"tenant":"acme","id":2,"online":true,"timestamp":20,"payload":{"id":2,"location":{"region":"eu"}}}],"next_after":2}`,
+		},
+		{
+			"payload",
+			"acme", "?payload.location.region=us",
+			http.StatusOK,
+			`{"objects":[{"tenant":"acme","id":3,"online":true,"timestamp":30,"payload":{"id":3,"location":{"region":"us"}}}]}`,
+		},
+		{
+			"payloadNumber",
+			"acme", "?payload.id=2&payload.location.region=eu",
+			http.StatusOK,
+			`{"objects":[{"tenant":"acme","id":2,"online":true,"timestamp":20,"payload":{"id":2,"location":{"region":"eu"}}}]}`,
+		},
+		{
+			"invalidPayloadPath",
+			"acme", "?payload.location..region=eu",
+			http.StatusBadRequest,
+			`{"error":"invalid_payload_path","message":"payload paths must list non empty keys separated by dots"}`,
+		},
+		{
+			"tooManyPayloadMatches",
+			"acme", "?payload.id=1&payload.id=2&payload.id=3&payload.id=4&payload.id=5&payload.id=6" +
+				"&payload.id=7&payload.id=8&payload.id=9&payload.id=10&payload.id=11",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+		{
+			"invalidLimit",
+			"acme", "?limit=-1",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+		{
+			"invalidAfter",
+			"acme", "?after=one",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/objects"+cs.query, nil)
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: cs.tenant})
+
+			o.List(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodGet, "/objects", w)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+		})
+	}
+}
+
+func TestObjects_Retrieve(t *testing.T) {
+	o := NewObjects(newTestObjectService(), nil)
+
+	var cases = []struct {
+		name      string
+		tenant    string
+		id        string
+		outStatus int
+		outJSON   string
+	}{
+		{
+			"ok",
+			"acme", "2",
+			http.StatusOK,
+			`{"tenant":"acme","id":2,"online":true,"timestamp":20,"payload":{"id":2,"location":{"region":"eu"}}}`,
+		},
+		{
+			"otherTenant",
+			"acme", "4",
+			http.StatusNotFound,
+			`{"error":"not_found","message":"resource not found"}`,
+		},
+		{
+			"invalidID",
+			"acme", "two",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/objects/"+cs.id, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", cs.id)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: cs.tenant})
+
+			o.Retrieve(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodGet, "/objects/{id}", w)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+		})
+	}
+}
+
+func TestObjects_List_capped(t *testing.T) {
+	objs := &testObjectService{}
+	for id := int64(1); id <= models.MaxListLimit+1; id++ {
+		objs.objects = append(objs.objects, models.Callback{Tenant: "acme", ID: id, Online: true})
+	}
+	o := NewObjects(objs, nil)
+
+	for _, query := range []string{"", "?limit=5000"} {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/objects"+query, nil)
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: "acme"})
+
+			o.List(ctx, w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var resp objectList
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Len(t, resp.Objects, models.MaxListLimit)
+			if assert.NotNil(t, resp.NextAfter, "full pages have a next_after") {
+				assert.Equal(t, int64(models.MaxListLimit), *resp.NextAfter)
+			}
+		})
+	}
+}
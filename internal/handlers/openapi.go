@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	_ "embed" // openapi.json
+	"log"
+	"net/http"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// openAPIDocument is the OpenAPI 3 document describing every route of the API. Handler tests
+// check their responses against it, so it must be updated with the routes.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// OpenAPI serves the OpenAPI document of the API.
+type OpenAPI struct {
+	log *log.Logger
+}
+
+// Document returns the OpenAPI document as is.
+func (o *OpenAPI) Document(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	_, span := trace.StartSpan(ctx, "handlers.OpenAPI.Document")
+	defer span.End()
+
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+		v.StatusCode = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPIDocument); err != nil {
+		o.log.Println("error writing result", err)
+	}
+}
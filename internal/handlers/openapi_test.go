@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openAPIDoc is the parsed OpenAPI document of the API.
+type openAPIDoc map[string]interface{}
+
+func parseOpenAPI(t *testing.T) openAPIDoc {
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return doc
+}
+
+// resolve returns the object pointed by the local reference ref, as "#/components/schemas/Object".
+func (doc openAPIDoc) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("reference %q is not local", ref)
+	}
+	var node interface{} = map[string]interface{}(doc)
+	for _, key := range strings.Split(ref[2:], "/") {
+		key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("reference %q does not resolve", ref)
+		}
+		if node, ok = m[key]; !ok {
+			return nil, fmt.Errorf("reference %q does not resolve", ref)
+		}
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("reference %q is not an object", ref)
+	}
+	return m, nil
+}
+
+// deref follows the reference of node, if it is one.
+func (doc openAPIDoc) deref(node map[string]interface{}) (map[string]interface{}, error) {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node, nil
+		}
+		var err error
+		if node, err = doc.resolve(ref); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// validate checks value, decoded from JSON with UseNumber, against the schema. It supports the
+// subset of the schema keywords used by openapi.json.
+func (doc openAPIDoc) validate(schema map[string]interface{}, value interface{}, path string) error {
+	schema, err := doc.deref(schema)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil && schema["oneOf"] == nil {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", path)
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		var matched int
+		for _, s := range oneOf {
+			if doc.validate(s.(map[string]interface{}), value, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d schemas of oneOf, not 1", path, matched)
+		}
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var found bool
+		for _, e := range enum {
+			found = found || fmt.Sprint(e) == fmt.Sprint(value)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch typ, _ := schema["type"].(string); typ {
+	case "":
+		return nil
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", path, value)
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, req := range required {
+			if _, ok := obj[req.(string)]; !ok {
+				return fmt.Errorf("%s: required property %q is missing", path, req)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := props[k].(map[string]interface{}); ok {
+				if err := doc.validate(prop, obj[k], path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: property %q is not described", path, k)
+				}
+			case map[string]interface{}:
+				if err := doc.validate(additional, obj[k], path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", path, value)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range list {
+			if err := doc.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", path, s)
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a number", path, value)
+		}
+		if _, err := n.Int64(); typ == "integer" && err != nil {
+			return fmt.Errorf("%s: %v is not an integer", path, value)
+		}
+		if min, ok := schema["minimum"].(float64); ok {
+			if f, _ := n.Float64(); f < min {
+				return fmt.Errorf("%s: %v is less than %v", path, value, min)
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", path, value)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported type %q", path, typ)
+	}
+	return nil
+}
+
+// pathParam matches the parameters of the paths, and the variables of the server URLs.
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// routePrefix matches the version and tenant prefixes of the routes, which the document describes
+// with its servers instead of its paths.
+var routePrefix = regexp.MustCompile(`^(/v[0-9]+)?(/t/{[^}]+})?`)
+
+// checkResponse checks that a response to method on route, the pattern it is registered with,
+// is described by the document: its status code, content type and body.
+func (doc openAPIDoc) checkResponse(method, route string, status int, header http.Header, body []byte) error {
+	if path := routePrefix.ReplaceAllString(route, ""); path != "" {
+		route = path
+	}
+	item, err := doc.resolve("#/paths/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(route))
+	if err != nil {
+		return fmt.Errorf("route %s is not documented", route)
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("operation %s %s is not documented", method, route)
+	}
+
+	responses := op["responses"].(map[string]interface{})
+	resp, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !ok {
+		if resp, ok = responses["default"].(map[string]interface{}); !ok {
+			return fmt.Errorf("%s %s: status %d is not documented", method, route, status)
+		}
+	}
+	if resp, err = doc.deref(resp); err != nil {
+		return err
+	}
+
+	content, _ := resp["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s: status %d has no body documented", method, route, status)
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%s %s: invalid content type %q", method, route, header.Get("Content-Type"))
+	}
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s %s: content type %q of status %d is not documented", method, route, mediaType, status)
+	}
+	schema, ok := media["schema"].(map[string]interface{})
+	if !ok || mediaType != mediaTypeJSON {
+		return nil
+	}
+
+	var value interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&value); err != nil {
+		return fmt.Errorf("%s %s: body is not JSON: %v", method, route, err)
+	}
+	if err := doc.validate(schema, value, "body"); err != nil {
+		return fmt.Errorf("%s %s: status %d: %v", method, route, status, err)
+	}
+	return nil
+}
+
+// assertOpenAPI asserts that the response recorded by w, answering a request of method on route,
+// is described by the OpenAPI document. Route is the pattern the handler is registered with, as
+// "/objects/{id}".
+func assertOpenAPI(t *testing.T, method, route string, w *httptest.ResponseRecorder) bool {
+	t.Helper()
+	err := parseOpenAPI(t).checkResponse(method, route, w.Code, w.Header(), w.Body.Bytes())
+	return assert.NoError(t, err, "response does not match openapi.json")
+}
+
+func TestOpenAPI_Document(t *testing.T) {
+	doc := parseOpenAPI(t)
+	assert.Equal(t, "3.0.3", doc["openapi"])
+
+	// Every reference resolves.
+	refs := regexp.MustCompile(`"\$ref":\s*"([^"]+)"`).FindAllSubmatch(openAPIDocument, -1)
+	for _, ref := range refs {
+		_, err := doc.resolve(string(ref[1]))
+		assert.NoError(t, err)
+	}
+
+	// Every server declares the variables of its URL.
+	for _, server := range doc["servers"].([]interface{}) {
+		server := server.(map[string]interface{})
+		url := server["url"].(string)
+		variables, _ := server["variables"].(map[string]interface{})
+		for _, m := range pathParam.FindAllStringSubmatch(url, -1) {
+			assert.Contains(t, variables, m[1], "server %s: variable %q is not declared", url, m[1])
+		}
+	}
+
+	// Every operation is identified, answers something, and declares its path parameters.
+	operationIDs := make(map[string]string)
+	for route, item := range doc["paths"].(map[string]interface{}) {
+		for method, op := range item.(map[string]interface{}) {
+			op, ok := op.(map[string]interface{})
+			if !ok || method == "servers" {
+				continue
+			}
+			name := strings.ToUpper(method) + " " + route
+
+			id, _ := op["operationId"].(string)
+			if assert.NotEmpty(t, id, name) {
+				assert.Empty(t, operationIDs[id], "%s: operationId %q already used", name, id)
+				operationIDs[id] = name
+			}
+			assert.NotEmpty(t, op["responses"], name)
+
+			declared := make(map[string]bool)
+			params, _ := op["parameters"].([]interface{})
+			for _, p := range params {
+				p := p.(map[string]interface{})
+				if p["in"] == "path" {
+					declared[p["name"].(string)] = true
+				}
+			}
+			for _, m := range pathParam.FindAllStringSubmatch(route, -1) {
+				assert.True(t, declared[m[1]], "%s: path parameter %q is not declared", name, m[1])
+			}
+		}
+	}
+}
+
+func TestOpenAPI_validate(t *testing.T) {
+	doc := parseOpenAPI(t)
+
+	var cases = []struct {
+		name   string
+		status int
+		body   string
+		outErr string
+	}{
+		{"ok", http.StatusOK, `{"objects":[{"tenant":"default","id":1,"online":true,"timestamp":2}],"next_after":1}`, ""},
+		{"missingProperty", http.StatusOK, `{"objects":[{"tenant":"default","id":1,"online":true}]}`, `body.objects[0]: required property "timestamp" is missing`},
+		{"unknownProperty", http.StatusOK, `{"objects":[],"cursor":1}`, `body: property "cursor" is not described`},
+		{"wrongType", http.StatusOK, `{"objects":[{"tenant":"default","id":"1","online":true,"timestamp":2}]}`, `body.objects[0].id: 1 is not a number`},
+		{"null", http.StatusOK, `{"objects":null}`, `body.objects: null is not allowed`},
+		{"error", http.StatusBadRequest, `{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`, ""},
+		{"undocumentedStatus", http.StatusTeapot, `{}`, `GET /objects: status 418 is not documented`},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+			err := doc.checkResponse(http.MethodGet, "/objects", cs.status, header, []byte(cs.body))
+			if cs.outErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), cs.outErr)
+			}
+		})
+	}
+}
+
+func TestOpenAPI_Document_served(t *testing.T) {
+	o := OpenAPI{}
+	w := httptest.NewRecorder()
+	o.Document(NewTestContext(), w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, string(openAPIDocument), w.Body.String())
+	assertOpenAPI(t, http.MethodGet, "/openapi.json", w)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -11,21 +12,283 @@ import (
 	"github.com/noelruault/go-callback-service/internal/web"
 )
 
-func API(log *log.Logger, db *gorm.DB, callbackServiceURL string) http.Handler {
+// Config holds the settings used to build the routes of the API.
+type Config struct {
+	// Upstream defines how the object services are queried for the status of the objects.
+	Upstream models.Upstream
+
+	// Callbacks and Broker are the callback service and the broker of the object events. They are
+	// built from Upstream and Stream when nil, and given to share them with the gRPC API.
+	Callbacks models.CallbackService
+	Broker    *models.EventBroker
+
+	// MaxBodyBytes limits the size of the callback bodies. Zero means no limit.
+	MaxBodyBytes int64
+
+	// EventDedupeWindow is the time during which a CloudEvent received again is ignored.
+	EventDedupeWindow time.Duration
+
+	// IdempotencyWindow is the time during which the response sent for an idempotency key is kept.
+	IdempotencyWindow time.Duration
+
+	// IdempotencyLease is the time a request, or an event, being processed holds its idempotency
+	// key, after which the key is freed if the request never completed.
+	IdempotencyLease time.Duration
+
+	// CallbackSignature and EventsSignature configure the verification of the request
+	// signatures on /callback and /events. Routes with no secrets accept unsigned requests.
+	CallbackSignature mw.SignatureConfig
+	EventsSignature   mw.SignatureConfig
+
+	// RequireAPIKey makes the ingestion and read routes reject requests without a valid API key.
+	// The health check is never authenticated.
+	RequireAPIKey bool
+
+	// CallbackRateLimit, EventsRateLimit and ObjectsRateLimit limit the rate of the requests of
+	// every client on each route. Zero limits are not applied.
+	CallbackRateLimit models.RateLimit
+	EventsRateLimit   models.RateLimit
+	ObjectsRateLimit  models.RateLimit
+
+	// SharedRateLimits keeps the rate limits on the database, so they are shared by every replica
+	// instead of being applied by each one on its own. RateLimits is built accordingly when nil,
+	// and given to share the buckets with the gRPC API.
+	SharedRateLimits bool
+	RateLimits       models.RateLimitService
+
+	// TrustedProxies are the proxies whose X-Forwarded-For header names the client of the
+	// anonymous requests they forward, for the rate limits.
+	TrustedProxies mw.TrustedProxies
+
+	// Admission sheds the ingestion requests while the backlog of status lookups is too large.
+	Admission mw.AdmissionConfig
+
+	// Webhooks defines how the object events are delivered to the webhooks of the tenants.
+	Webhooks models.WebhookConfig
+
+	// Outbox defines how the object events written to the outbox are relayed to the sinks. The
+	// webhooks are a sink when WebhookSink is set, next to the EventSinks, such as a file.
+	Outbox      models.OutboxConfig
+	WebhookSink bool
+	EventSinks  []models.OutboxSink
+
+	// Stream defines how the changes of the objects are streamed on /objects/stream.
+	Stream StreamConfig
+
+	// Listen defines how the events written by every replica are listened to, so they are
+	// streamed by all of them. With no DSN, a replica only streams the events it relays.
+	Listen models.ListenConfig
+
+	// Socket defines the limits of the WebSocket connections of /callback/ws.
+	Socket SocketConfig
+
+	// Deprecations announces the deprecation of routes to their clients, keyed by the method and
+	// the pattern of the route without its tenant prefix: "POST /callback" for the unversioned
+	// route, "POST /v1/callback" for the one of the first version.
+	Deprecations map[string]mw.DeprecationConfig
+}
+
+// tenantPrefixes are the prefixes every tenant scoped route is mounted on. Unprefixed routes act
+// on the tenant of the API key, or the default tenant. The prefix must name the same tenant, so
+// anonymous requests can only use it for the default tenant.
+var tenantPrefixes = []string{"", "/t/{" + mw.TenantParam + "}"}
+
+// apiVersions are the prefixes the versions of the API are mounted on, every route being served
+// on each of them. The unversioned routes are aliases of the first version, kept for the clients
+// predating the versions. The next version is added with the first route it changes.
+var apiVersions = []string{"", "/v1"}
+
+// API returns the handler of the HTTP API, and the function stopping the work it runs in the
+// background: the outbox relay, the event listener, the webhook deliveries and the purge of the
+// idempotency keys. It must be called once the handler is done with.
+func API(log *log.Logger, db *gorm.DB, cfg Config) (http.Handler, func()) {
 	app := web.NewApp(log, mw.Logger(log), mw.Metrics(), mw.Panics(log))
 
 	// Models
-	cm := models.NewCallbackService(db, callbackServiceURL, log)
+	ts := models.NewTenantService(db)
+	cm := cfg.Callbacks
+	if cm == nil {
+		cm = models.NewCallbackService(db, ts, cfg.Upstream, log)
+	}
+	wh := models.NewWebhookService(db, cfg.Webhooks, log)
+	cfg.Stream = cfg.Stream.withDefaults()
+	broker := cfg.Broker
+	if broker == nil {
+		broker = models.NewEventBroker(cfg.Stream.BufferSize)
+	}
+	sinks := append([]models.OutboxSink{}, cfg.EventSinks...)
+	if cfg.WebhookSink {
+		sinks = append(sinks, models.OutboxSink{Name: "webhook", ObjectEventNotifier: wh})
+	}
+	var listener *models.EventListener
+	if cfg.Listen.DSN != "" {
+		listener = models.NewEventListener(db, cfg.Listen, []models.ObjectEventNotifier{broker}, log)
+	} else {
+		sinks = append(sinks, models.OutboxSink{Name: "broker", ObjectEventNotifier: broker})
+	}
+	relay := models.NewOutboxRelay(db, cfg.Outbox, sinks, log)
+	// The keys of the requests and of the events share their records, the events being
+	// deduplicated for their own window.
+	ik := models.NewIdempotencyService(db, cfg.IdempotencyWindow, cfg.IdempotencyLease, log)
+	stop := func() {
+		// The relay publishes to the listener's sinks and the webhooks, so it is stopped first.
+		relay.Close()
+		if listener != nil {
+			listener.Close()
+		}
+		wh.Close()
+		ik.Close()
+	}
+	ak := models.NewAPIKeyService(db)
+	rls := cfg.RateLimits
+	if rls == nil {
+		rls = NewRateLimitService(db, cfg.SharedRateLimits, log)
+	}
 
 	{
 		c := Check{db: db, log: log}
 		app.Handle(http.MethodGet, "/", c.Health)
+
+		o := OpenAPI{log: log}
+		app.Handle(http.MethodGet, "/openapi.json", o.Document)
 	}
 	// Handlers
-	{
-		csvc := NewCallbacks(cm, log)
-		app.Handle(http.MethodPost, "/callback", csvc.Handle)
+	csvc := NewCallbacks(cm, log, cfg.MaxBodyBytes)
+	evts := NewEvents(cm, ik.WithWindow(cfg.EventDedupeWindow), log, cfg.MaxBodyBytes)
+	objs := NewObjects(cm, log)
+	hooks := NewWebhooks(wh, log)
+	streams := NewStreams(broker, cfg.Stream, log)
+	cfg.Socket.SignedCallbacks = cfg.CallbackSignature.Enabled()
+	socks := NewSockets(cm, broker, cfg.Socket, cfg.Admission, log)
+
+	served := make(map[string]bool)
+	for _, version := range apiVersions {
+		g := app.Group(version)
+		for _, prefix := range tenantPrefixes {
+			// The deprecation headers are set first, so the errors of the route carry them too.
+			handle := func(method, url string, h web.Handler, mws ...web.Middleware) {
+				route := method + " " + g.Prefix() + url
+				served[route] = true
+				dep := deprecation(cfg.Deprecations, route)
+				g.Handle(method, prefix+url, h, append([]web.Middleware{dep}, mws...)...)
+			}
+
+			handle(http.MethodPost, "/callback", csvc.Handle,
+				admission(cm, cfg.Admission),
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "callback", cfg.CallbackRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+				signature(cfg.CallbackSignature, cfg.MaxBodyBytes),
+				mw.Idempotency(ik, cfg.MaxBodyBytes, log),
+			)
+			handle(http.MethodGet, "/callback/ws", socks.Connect,
+				admission(cm, cfg.Admission),
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "callback", cfg.CallbackRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodPost, "/events", evts.Handle,
+				admission(cm, cfg.Admission),
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "events", cfg.EventsRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+				signature(cfg.EventsSignature, cfg.MaxBodyBytes),
+			)
+			handle(http.MethodGet, "/objects", objs.List,
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "objects", cfg.ObjectsRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodGet, "/objects/stream", streams.Objects,
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "objects", cfg.ObjectsRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodGet, "/objects/{id}", objs.Retrieve,
+				authenticate(cfg.RequireAPIKey, ak),
+				rateLimit(rls, "objects", cfg.ObjectsRateLimit, cfg.TrustedProxies, log),
+				mw.Tenant(ts),
+			)
+			// Webhooks always need an API key, as they make the service send requests.
+			handle(http.MethodPost, "/webhooks", hooks.Create,
+				mw.Authenticate(ak),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodGet, "/webhooks", hooks.List,
+				mw.Authenticate(ak),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodDelete, "/webhooks/{id}", hooks.Delete,
+				mw.Authenticate(ak),
+				mw.Tenant(ts),
+			)
+			handle(http.MethodGet, "/webhooks/{id}/deliveries", hooks.Deliveries,
+				mw.Authenticate(ak),
+				mw.Tenant(ts),
+			)
+		}
+	}
+	for route := range cfg.Deprecations {
+		if !served[route] {
+			log.Printf("handlers : deprecated route %q is not served", route)
+		}
 	}
 
-	return app
+	return app, stop
+}
+
+// NewRateLimitService returns the service keeping the buckets of the rate limits, on the database
+// when shared by the replicas, in memory otherwise.
+func NewRateLimitService(db *gorm.DB, shared bool, log *log.Logger) models.RateLimitService {
+	if shared {
+		return models.NewRateLimitService(db, log)
+	}
+	return models.NewMemoryRateLimitService()
+}
+
+// signature returns the middleware verifying the signatures of a route, or nil if the route
+// accepts unsigned requests.
+func signature(sc mw.SignatureConfig, maxBodyBytes int64) web.Middleware {
+	if !sc.Enabled() {
+		return nil
+	}
+	sc.MaxBodyBytes = maxBodyBytes
+	return mw.Signature(sc)
+}
+
+// authenticate returns the middleware authenticating the requests of a route, or nil if API
+// keys are not required.
+func authenticate(required bool, ak models.APIKeyService) web.Middleware {
+	if !required {
+		return nil
+	}
+	return mw.Authenticate(ak)
+}
+
+// rateLimit returns the middleware limiting the rate of the requests of a route, or nil if the
+// route is not limited.
+func rateLimit(rls models.RateLimitService, route string, limit models.RateLimit, proxies mw.TrustedProxies, log *log.Logger) web.Middleware {
+	if !limit.Enabled() {
+		return nil
+	}
+	return mw.RateLimit(rls, route, limit, proxies, log)
+}
+
+// deprecation returns the middleware announcing the deprecation of the route, or nil if it is
+// not deprecated.
+func deprecation(deprecations map[string]mw.DeprecationConfig, route string) web.Middleware {
+	dc, ok := deprecations[route]
+	if !ok || !dc.Enabled() {
+		return nil
+	}
+	return mw.Deprecation(route, dc)
+}
+
+// admission returns the middleware shedding the requests of a route under backlog pressure, or
+// nil if no threshold is set.
+func admission(lr models.LoadReporter, ac mw.AdmissionConfig) web.Middleware {
+	if !ac.Enabled() {
+		return nil
+	}
+	return mw.Admission(lr, ac)
 }
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opencensus.io/trace"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// Types of the frames sent by the clients of a socket.
+const (
+	frameCallback    = "callback"
+	frameSubscribe   = "subscribe"
+	frameUnsubscribe = "unsubscribe"
+)
+
+// Types of the messages sent to the clients of a socket.
+const (
+	messageReceipt = "receipt"
+	messageError   = "error"
+	messageStatus  = "status"
+)
+
+// SocketConfig defines the limits of the WebSocket connections. Zero values take the defaults.
+type SocketConfig struct {
+	// MaxFrameBytes limits the size of a frame. Defaults to 1MiB.
+	MaxFrameBytes int64
+	// MaxSubscriptions is the number of objects a connection can be subscribed to. Defaults to
+	// 10000.
+	MaxSubscriptions int
+	// FrameRate limits the frames of every connection. Zero limits are not applied.
+	FrameRate models.RateLimit
+	// SendBuffer is the number of messages waiting to be sent to a connection. Status updates
+	// overflowing it close the connection, the frames are not read while it is full. Defaults
+	// to 64.
+	SendBuffer int
+	// PingInterval is the time between two pings. Connections not answering for twice that
+	// time are closed. Defaults to 30s.
+	PingInterval time.Duration
+	// WriteWait limits the time spent writing a message. Defaults to 10s.
+	WriteWait time.Duration
+	// SignedCallbacks is set when the callbacks must be signed. Frames are not, so the
+	// connections are refused.
+	SignedCallbacks bool
+}
+
+func (sc SocketConfig) withDefaults() SocketConfig {
+	if sc.MaxFrameBytes <= 0 {
+		sc.MaxFrameBytes = 1 << 20
+	}
+	if sc.MaxSubscriptions <= 0 {
+		sc.MaxSubscriptions = 10000
+	}
+	if sc.SendBuffer <= 0 {
+		sc.SendBuffer = 64
+	}
+	if sc.PingInterval <= 0 {
+		sc.PingInterval = 30 * time.Second
+	}
+	if sc.WriteWait <= 0 {
+		sc.WriteWait = 10 * time.Second
+	}
+	return sc
+}
+
+// Sockets defines the handler of the WebSocket connections of the producers, sending callbacks
+// and following the status of their objects over a single connection.
+type Sockets struct {
+	csvc      models.CallbackService
+	broker    *models.EventBroker
+	cfg       SocketConfig
+	admission mw.AdmissionConfig
+	upgrader  websocket.Upgrader
+
+	log *log.Logger
+}
+
+// NewSockets creates a new Sockets controller. Callback frames are shed as defined by admission,
+// and the status updates are taken from broker.
+func NewSockets(csvc models.CallbackService, broker *models.EventBroker, cfg SocketConfig, admission mw.AdmissionConfig, log *log.Logger) *Sockets {
+	cfg = cfg.withDefaults()
+
+	return &Sockets{
+		csvc:      csvc,
+		broker:    broker,
+		cfg:       cfg,
+		admission: admission,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: cfg.WriteWait,
+		},
+		log: log,
+	}
+}
+
+// socketFrame is a frame sent by the client. ID is chosen by the client and sent back on the
+// receipt or the error answering the frame.
+type socketFrame struct {
+	Type      string  `json:"type"`
+	ID        string  `json:"id"`
+	Source    string  `json:"source"`
+	ObjectIDs []int64 `json:"object_ids"`
+}
+
+// socketMessage is a message sent to the client: the receipt of a frame, the error it raised, or
+// the status update of a subscribed object.
+type socketMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// Accepted is the number of distinct objects of a callback frame.
+	Accepted *int `json:"accepted,omitempty"`
+	// Subscriptions is the number of objects followed after a subscription frame.
+	Subscriptions *int `json:"subscriptions,omitempty"`
+
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+	// RetryAfter is the number of seconds to wait before sending the frame again.
+	RetryAfter int `json:"retry_after,omitempty"`
+
+	Event *models.ObjectEvent `json:"event,omitempty"`
+}
+
+// Connect upgrades the request to a WebSocket connection and serves it until it is closed.
+//
+// Clients send JSON text frames: {"type":"callback","id":"1","object_ids":[1,2]} adds objects like
+// a /callback body, {"type":"subscribe","id":"2","object_ids":[1]} and "unsubscribe" frames
+// choose the objects whose status updates are pushed as {"type":"status","event":{...}}. Every
+// frame is answered with a receipt or an error carrying its id.
+//
+// Connections are refused when the callbacks must be signed.
+func (s *Sockets) Connect(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Sockets.Connect")
+	defer span.End()
+
+	if s.cfg.SignedCallbacks {
+		web.RespondError(ctx, w, ErrUnsignedFrames, http.StatusForbidden)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered the request.
+		return
+	}
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+		v.StatusCode = http.StatusSwitchingProtocols
+	}
+
+	sc := &socketConn{
+		s:      s,
+		ctx:    ctx,
+		conn:   conn,
+		tenant: requestTenant(ctx),
+		send:   make(chan socketMessage, s.cfg.SendBuffer),
+		done:   make(chan struct{}),
+		subs:   make(map[int64]bool),
+		frames: models.NewMemoryRateLimitService(),
+	}
+	sc.serve()
+}
+
+// socketConn is a WebSocket connection being served.
+type socketConn struct {
+	s      *Sockets
+	ctx    context.Context
+	conn   *websocket.Conn
+	tenant string
+
+	// send queues the messages written by a single goroutine, as connections support one writer
+	// at a time.
+	send      chan socketMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	subs map[int64]bool
+
+	frames models.RateLimitService
+}
+
+// serve reads the frames of the connection until it is closed. Messages are written and status
+// updates followed by goroutines of their own.
+func (sc *socketConn) serve() {
+	defer sc.close(websocket.CloseNormalClosure, "")
+
+	cfg := sc.s.cfg
+	sc.conn.SetReadLimit(cfg.MaxFrameBytes)
+	sc.conn.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+	sc.conn.SetPongHandler(func(string) error {
+		return sc.conn.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+	})
+
+	go sc.write()
+	if sc.s.broker != nil {
+		_, events, cancel := sc.s.broker.Subscribe(0)
+		defer cancel()
+		go sc.follow(events)
+	}
+
+	for {
+		kind, data, err := sc.conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				sc.close(websocket.CloseMessageTooBig, "frame too large")
+			}
+			return
+		}
+		if kind != websocket.TextMessage {
+			sc.close(websocket.CloseUnsupportedData, "frames must be text")
+			return
+		}
+
+		if !sc.reply(sc.handle(data)) {
+			return
+		}
+	}
+}
+
+// handle processes a frame and returns the message answering it.
+func (sc *socketConn) handle(data []byte) socketMessage {
+	var f socketFrame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return errorMessage("", ErrInvalidJSONInput, 0)
+	}
+
+	if limit := sc.s.cfg.FrameRate; limit.Enabled() {
+		res, err := sc.frames.Allow(sc.ctx, "frames", limit)
+		if err == nil && !res.Allowed {
+			return errorMessage(f.ID, mw.ErrRateLimited, res.RetryAfter)
+		}
+	}
+
+	switch f.Type {
+	case frameCallback:
+		if ac := sc.s.admission; ac.Enabled() && ac.Shed(sc.s.csvc.Load()) != "" {
+			return errorMessage(f.ID, mw.ErrOverloaded, ac.RetryAfter)
+		}
+
+		in := newIngester(sc.ctx, sc.s.csvc)
+		in.setSource(f.Source)
+		err := in.push(f.ObjectIDs)
+		if err == nil {
+			err = in.flush()
+		}
+		if err != nil {
+			return errorMessage(f.ID, err, 0)
+		}
+		accepted := len(in.seen)
+		return socketMessage{Type: messageReceipt, ID: f.ID, Accepted: &accepted}
+
+	case frameSubscribe, frameUnsubscribe:
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+
+		if f.Type == frameUnsubscribe {
+			for _, id := range f.ObjectIDs {
+				delete(sc.subs, id)
+			}
+		} else {
+			added := 0
+			for _, id := range f.ObjectIDs {
+				if !sc.subs[id] {
+					added++
+				}
+			}
+			if len(sc.subs)+added > sc.s.cfg.MaxSubscriptions {
+				return errorMessage(f.ID, ErrTooManySubscriptions, 0)
+			}
+			for _, id := range f.ObjectIDs {
+				sc.subs[id] = true
+			}
+		}
+		n := len(sc.subs)
+		return socketMessage{Type: messageReceipt, ID: f.ID, Subscriptions: &n}
+
+	default:
+		return errorMessage(f.ID, ErrInvalidFrame, 0)
+	}
+}
+
+// follow queues the status updates of the subscribed objects. Connections too slow to take them
+// are closed, so the updates of an object are never skipped silently.
+func (sc *socketConn) follow(events <-chan models.ObjectEvent) {
+	for {
+		select {
+		case <-sc.done:
+			return
+		case e, ok := <-events:
+			if !ok {
+				sc.close(websocket.CloseTryAgainLater, "status updates fell behind")
+				return
+			}
+			if e.Tenant != sc.tenant || !sc.subscribed(e.Object.ID) {
+				continue
+			}
+			select {
+			case sc.send <- socketMessage{Type: messageStatus, Event: &e}:
+			default:
+				sc.close(websocket.CloseTryAgainLater, "status updates fell behind")
+				return
+			}
+		}
+	}
+}
+
+func (sc *socketConn) subscribed(id int64) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.subs[id]
+}
+
+// reply queues msg, waiting while the send buffer is full so a client sending frames faster than
+// it reads the receipts is slowed down. It returns false once the connection is closed.
+func (sc *socketConn) reply(msg socketMessage) bool {
+	select {
+	case sc.send <- msg:
+		return true
+	case <-sc.done:
+		return false
+	}
+}
+
+// write sends the queued messages and the pings until the connection is closed.
+func (sc *socketConn) write() {
+	ping := time.NewTicker(sc.s.cfg.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-sc.done:
+			return
+		case msg := <-sc.send:
+			sc.conn.SetWriteDeadline(time.Now().Add(sc.s.cfg.WriteWait))
+			if err := sc.conn.WriteJSON(msg); err != nil {
+				sc.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(sc.s.cfg.WriteWait)
+			if err := sc.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				sc.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// close sends a close frame with code and reason, unless the connection is already broken, and
+// closes the connection. Only the first call has effect.
+func (sc *socketConn) close(code int, reason string) {
+	sc.closeOnce.Do(func() {
+		close(sc.done)
+		if code != websocket.CloseAbnormalClosure {
+			deadline := time.Now().Add(sc.s.cfg.WriteWait)
+			sc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		}
+		sc.conn.Close()
+	})
+}
+
+// errorMessage returns the message answering the frame id with err. Errors not meant for the
+// clients are reported as server errors.
+func errorMessage(id string, err error, retryAfter time.Duration) socketMessage {
+	msg := socketMessage{Type: messageError, ID: id, RetryAfter: int(math.Ceil(retryAfter.Seconds()))}
+
+	var pe PublicError
+	if errors.As(err, &pe) {
+		msg.Error, msg.Message = pe.Code(), pe.Detail()
+	} else {
+		msg.Error = "server_error"
+	}
+	return msg
+}
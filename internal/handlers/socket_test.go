@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// dialSockets serves s for the tenant acme and returns a client connected to it.
+func dialSockets(t *testing.T, s *Sockets) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), web.KeyValues, &web.Values{TenantID: "acme"})
+		s.Connect(ctx, w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func TestSockets_Connect(t *testing.T) {
+	var cases = []struct {
+		name   string
+		cfg    SocketConfig
+		frames []string
+		out    []string
+	}{
+		{
+			"callback",
+			SocketConfig{},
+			[]string{`{"type":"callback","id":"1","object_ids":[1,2,2]}`},
+			[]string{`{"type":"receipt","id":"1","accepted":2}`},
+		},
+		{
+			"invalidJSON",
+			SocketConfig{},
+			[]string{`{"type":`},
+			[]string{`{"type":"error","error":"invalid_json","message":"provided input cannot be parsed"}`},
+		},
+		{
+			"invalidFrame",
+			SocketConfig{},
+			[]string{`{"type":"ping","id":"1"}`},
+			[]string{`{"type":"error","id":"1","error":"invalid_frame","message":"frame type must be callback, subscribe or unsubscribe"}`},
+		},
+		{
+			"subscriptions",
+			SocketConfig{MaxSubscriptions: 2},
+			[]string{
+				`{"type":"subscribe","id":"1","object_ids":[1,2]}`,
+				`{"type":"subscribe","id":"2","object_ids":[3]}`,
+				`{"type":"unsubscribe","id":"3","object_ids":[2]}`,
+				`{"type":"subscribe","id":"4","object_ids":[3]}`,
+			},
+			[]string{
+				`{"type":"receipt","id":"1","subscriptions":2}`,
+				`{"type":"error","id":"2","error":"too_many_subscriptions","message":"connection follows more objects than allowed"}`,
+				`{"type":"receipt","id":"3","subscriptions":1}`,
+				`{"type":"receipt","id":"4","subscriptions":2}`,
+			},
+		},
+		{
+			"rateLimited",
+			SocketConfig{FrameRate: models.RateLimit{Rate: 1, Burst: 1}},
+			[]string{
+				`{"type":"subscribe","id":"1","object_ids":[1]}`,
+				`{"type":"subscribe","id":"2","object_ids":[2]}`,
+			},
+			[]string{
+				`{"type":"receipt","id":"1","subscriptions":1}`,
+				`{"type":"error","id":"2","error":"rate_limited","message":"` + mw.ErrRateLimited.Detail() + `","retry_after":1}`,
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			csvc := &testCallbackService{upsert: func(ctx context.Context, cbs []models.Callback) error { return nil }}
+			conn := dialSockets(t, NewSockets(csvc, models.NewEventBroker(10), cs.cfg, mw.AdmissionConfig{}, nil))
+
+			for i, frame := range cs.frames {
+				conn.WriteMessage(websocket.TextMessage, []byte(frame))
+				_, msg, err := conn.ReadMessage()
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.JSONEq(t, cs.out[i], string(msg))
+			}
+		})
+	}
+}
+
+// loadedCallbackService reports a constant load.
+type loadedCallbackService struct {
+	testCallbackService
+	load models.Load
+}
+
+func (l *loadedCallbackService) Load() models.Load {
+	return l.load
+}
+
+func TestSockets_Connect_overloaded(t *testing.T) {
+	csvc := &loadedCallbackService{load: models.Load{Pending: 10}}
+	admission := mw.AdmissionConfig{MaxPending: 10, RetryAfter: 2 * time.Second}
+	conn := dialSockets(t, NewSockets(csvc, nil, SocketConfig{}, admission, nil))
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"callback","id":"1","object_ids":[1]}`)))
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"error","id":"1","error":"overloaded","message":"service is under heavy load, retry later","retry_after":2}`, string(msg))
+}
+
+func TestSockets_Connect_upsert(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []models.Callback
+	)
+	csvc := &testCallbackService{upsert: func(ctx context.Context, cbs []models.Callback) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, cbs...)
+		return nil
+	}}
+	conn := dialSockets(t, NewSockets(csvc, nil, SocketConfig{}, mw.AdmissionConfig{}, nil))
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"callback","id":"1","source":"fleet","object_ids":[4,4,5]}`)))
+	_, _, err := conn.ReadMessage()
+	assert.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []models.Callback{{ID: 4, Source: "fleet"}, {ID: 5, Source: "fleet"}}, got)
+}
+
+func TestSockets_Connect_status(t *testing.T) {
+	broker := models.NewEventBroker(10)
+	conn := dialSockets(t, NewSockets(&testCallbackService{}, broker, SocketConfig{}, mw.AdmissionConfig{}, nil))
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","id":"1","object_ids":[1]}`)))
+	_, _, err := conn.ReadMessage()
+	assert.NoError(t, err)
+
+	t0 := time.Unix(0, 0).UTC()
+	broker.Notify(context.Background(), []models.ObjectEvent{
+		{ID: 1, Type: models.EventObjectOnline, Time: t0, Tenant: "acme", Object: models.Callback{Tenant: "acme", ID: 2, Online: true}},
+		{ID: 2, Type: models.EventObjectOnline, Time: t0, Tenant: "other", Object: models.Callback{Tenant: "other", ID: 1, Online: true}},
+		{ID: 3, Type: models.EventObjectOnline, Time: t0, Tenant: "acme", Object: models.Callback{Tenant: "acme", ID: 1, Online: true}},
+	})
+
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"status","event":{"id":3,"type":"object.online","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":1,"online":true,"timestamp":0}}}`, string(msg))
+}
+
+func TestSockets_Connect_frameTooLarge(t *testing.T) {
+	conn := dialSockets(t, NewSockets(&testCallbackService{}, nil, SocketConfig{MaxFrameBytes: 16}, mw.AdmissionConfig{}, nil))
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"callback","object_ids":[1,2,3]}`)))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), err)
+}
+
+func TestSockets_Connect_signedCallbacks(t *testing.T) {
+	s := NewSockets(&testCallbackService{}, nil, SocketConfig{SignedCallbacks: true}, mw.AdmissionConfig{}, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/callback/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: "acme"})
+
+	s.Connect(ctx, w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode, "frames can't be signed")
+	assertOpenAPI(t, http.MethodGet, "/callback/ws", w)
+	assert.JSONEq(t, `{"error":"unsigned_frames","message":"callbacks must be signed, which socket frames can't be"}`, w.Body.String())
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// maxStreamIDs is the maximum number of object IDs a stream can be filtered by.
+const maxStreamIDs = 1000
+
+// StreamConfig defines how the object event streams are served.
+type StreamConfig struct {
+	// Heartbeat is the time between two comments sent to keep idle streams open. Defaults to 15s.
+	Heartbeat time.Duration
+	// Retry is the time the clients are told to wait before reconnecting. Defaults to 1s.
+	Retry time.Duration
+	// BufferSize is the number of latest events kept to resume streams. Defaults to 1000.
+	BufferSize int
+	// WriteTimeout is the write timeout of the server. Streams push the write deadline back before
+	// every write, so they stay open however long they last, and each write is given WriteTimeout.
+	// Streams on connections whose deadline can't be moved end before it expires, asking the
+	// clients to reconnect. Zero streams with no deadline.
+	WriteTimeout time.Duration
+}
+
+func (sc StreamConfig) withDefaults() StreamConfig {
+	if sc.Heartbeat <= 0 {
+		sc.Heartbeat = 15 * time.Second
+	}
+	if sc.Retry <= 0 {
+		sc.Retry = time.Second
+	}
+	if sc.BufferSize <= 0 {
+		sc.BufferSize = 1000
+	}
+	return sc
+}
+
+// duration returns the time a stream whose write deadline can't be moved is served for, leaving a
+// tenth of the write timeout, and at least 100ms, to end it. Zero means no limit.
+func (sc StreamConfig) duration() time.Duration {
+	if sc.WriteTimeout <= 0 {
+		return 0
+	}
+	margin := sc.WriteTimeout / 10
+	if margin < 100*time.Millisecond {
+		margin = 100 * time.Millisecond
+	}
+	return sc.WriteTimeout - margin
+}
+
+// Streams defines the handlers streaming the changes of the objects of the tenant of the request
+// as Server-Sent Events.
+type Streams struct {
+	broker *models.EventBroker
+	cfg    StreamConfig
+
+	log *log.Logger
+}
+
+// NewStreams creates a new Streams controller.
+func NewStreams(broker *models.EventBroker, cfg StreamConfig, log *log.Logger) *Streams {
+
+	return &Streams{
+		broker: broker,
+		cfg:    cfg.withDefaults(),
+		log:    log,
+	}
+}
+
+// streamFilter selects the events sent on a stream.
+type streamFilter struct {
+	tenant string
+	// ids limits the stream to the given objects, if not empty.
+	ids map[int64]bool
+	// online limits the stream to the objects coming online.
+	online bool
+}
+
+func (f streamFilter) matches(e models.ObjectEvent) bool {
+	return e.Tenant == f.tenant &&
+		(len(f.ids) == 0 || f.ids[e.Object.ID]) &&
+		(!f.online || e.Object.Online)
+}
+
+// Objects streams the changes of the objects as Server-Sent Events, filtered by the ids
+// (comma separated) and online query parameters. Streams sent a Last-Event-ID header resume after
+// that event, if it is still buffered. The write deadline of the server is pushed back before
+// every write, or the stream ends before it if it can't be.
+func (s *Streams) Objects(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Streams.Objects")
+	defer span.End()
+
+	filter := streamFilter{tenant: requestTenant(ctx)}
+	q := r.URL.Query()
+	if ids := q.Get("ids"); ids != "" {
+		list := strings.Split(ids, ",")
+		if len(list) > maxStreamIDs {
+			web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+			return
+		}
+		filter.ids = make(map[int64]bool, len(list))
+		for _, raw := range list {
+			id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+			if err != nil {
+				web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+				return
+			}
+			filter.ids[id] = true
+		}
+	}
+	if online := q.Get("online"); online != "" {
+		var err error
+		if filter.online, err = strconv.ParseBool(online); err != nil {
+			web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+			return
+		}
+	}
+	var lastID int64
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(last, 10, 64); err != nil || lastID < 0 {
+			web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		web.RespondError(ctx, w, ErrStreamUnsupported, http.StatusInternalServerError)
+		return
+	}
+
+	// The write deadline set by the server for the whole response is replaced by one per write.
+	rc := http.NewResponseController(w)
+	deadline := func() error {
+		if s.cfg.WriteTimeout <= 0 {
+			return nil
+		}
+		return rc.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	}
+	movable := deadline() == nil
+
+	replay, events, cancel := s.broker.Subscribe(lastID)
+	defer cancel()
+
+	// Set the status code for the request logger middleware, as the response is not sent with
+	// web.Respond.
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+		v.StatusCode = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", s.cfg.Retry.Milliseconds()); err != nil {
+		return
+	}
+	for _, e := range replay {
+		if filter.matches(e) {
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	var end <-chan time.Time
+	if d := s.cfg.duration(); d > 0 && !movable {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		end = timer.C
+	}
+	heartbeat := time.NewTicker(s.cfg.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case <-heartbeat.C:
+			if movable && deadline() != nil {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			// Streams falling behind are closed, clients resume them from the buffer.
+			if !ok {
+				return
+			}
+			if !filter.matches(e) {
+				continue
+			}
+			if movable && deadline() != nil {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e to w as a Server-Sent Event named after its type.
+func writeEvent(w http.ResponseWriter, e models.ObjectEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+func TestStreams_Objects(t *testing.T) {
+	t0 := time.Unix(0, 0).UTC()
+	event := func(id int64, typ, tenant string, object int64, online bool) models.ObjectEvent {
+		return models.ObjectEvent{ID: id, Type: typ, Time: t0, Tenant: tenant, Object: models.Callback{Tenant: tenant, ID: object, Online: online}}
+	}
+
+	var cases = []struct {
+		name      string
+		query     string
+		lastID    string
+		outStatus int
+		outBody   string
+	}{
+		{
+			"live",
+			"", "",
+			http.StatusOK,
+			"retry: 1000\n\n" +
+				"id: 5\nevent: object.online\ndata: " + `{"id":5,"type":"object.online","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":9,"online":true,"timestamp":0}}` + "\n\n",
+		},
+		{
+			"resume",
+			"", "2",
+			http.StatusOK,
+			"retry: 1000\n\n" +
+				"id: 3\nevent: object.offline\ndata: " + `{"id":3,"type":"object.offline","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":1,"online":false,"timestamp":0}}` + "\n\n" +
+				"id: 4\nevent: object.online\ndata: " + `{"id":4,"type":"object.online","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":2,"online":true,"timestamp":0}}` + "\n\n" +
+				"id: 5\nevent: object.online\ndata: " + `{"id":5,"type":"object.online","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":9,"online":true,"timestamp":0}}` + "\n\n",
+		},
+		{
+			"filtered",
+			"?ids=1,2&online=true", "1",
+			http.StatusOK,
+			"retry: 1000\n\n" +
+				"id: 4\nevent: object.online\ndata: " + `{"id":4,"type":"object.online","time":"1970-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":2,"online":true,"timestamp":0}}` + "\n\n",
+		},
+		{
+			"invalidIDs",
+			"?ids=1,two", "",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+		{
+			"invalidLastEventID",
+			"", "last",
+			http.StatusBadRequest,
+			`{"error":"invalid_parameter","message":"a path or query parameter is not valid"}`,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			broker := models.NewEventBroker(10)
+			broker.Notify(context.Background(), []models.ObjectEvent{
+				event(1, models.EventObjectOnline, "acme", 1, true),
+				event(2, models.EventObjectOnline, "other", 1, true),
+				event(3, models.EventObjectOffline, "acme", 1, false),
+				event(4, models.EventObjectOnline, "acme", 2, true),
+			})
+			s := NewStreams(broker, StreamConfig{Heartbeat: time.Hour, WriteTimeout: 300 * time.Millisecond}, nil)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/objects/stream"+cs.query, nil)
+			if cs.lastID != "" {
+				r.Header.Set("Last-Event-ID", cs.lastID)
+			}
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: "acme"})
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				broker.Notify(context.Background(), []models.ObjectEvent{event(5, models.EventObjectOnline, "acme", 9, true)})
+			}()
+			start := time.Now()
+			s.Objects(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodGet, "/objects/stream", w)
+			if cs.outStatus != http.StatusOK {
+				assert.JSONEq(t, cs.outBody, w.Body.String())
+				return
+			}
+			assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+			assert.Equal(t, cs.outBody, w.Body.String())
+			assert.Less(t, int64(time.Since(start)), int64(300*time.Millisecond), "streams end before the write timeout")
+		})
+	}
+}
+
+func TestStreams_Objects_heartbeat(t *testing.T) {
+	s := NewStreams(models.NewEventBroker(10), StreamConfig{Heartbeat: 20 * time.Millisecond, WriteTimeout: 200 * time.Millisecond}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/objects/stream", nil)
+	ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: "acme"})
+	s.Objects(ctx, w, r)
+
+	assert.True(t, strings.HasPrefix(w.Body.String(), "retry: 1000\n\n: heartbeat\n\n"), w.Body.String())
+}
+
+func TestStreams_Objects_writeTimeout(t *testing.T) {
+	// The default configuration, scaled down: the heartbeat comes three times as late as the
+	// write timeout of the server.
+	const writeTimeout = 100 * time.Millisecond
+	broker := models.NewEventBroker(10)
+	s := NewStreams(broker, StreamConfig{Heartbeat: 3 * writeTimeout, WriteTimeout: writeTimeout}, nil)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), web.KeyValues, &web.Values{TenantID: "acme"})
+		s.Objects(ctx, w, r)
+	}))
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	next := func(want string) {
+		t.Helper()
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("stream closed before %q", want)
+				}
+				if strings.HasPrefix(line, want) {
+					return
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %q on the stream", want)
+			}
+		}
+	}
+
+	// The stream outlives the write timeout, and is sent heartbeats and events past it.
+	next(": heartbeat")
+	next(": heartbeat")
+	broker.Notify(context.Background(), []models.ObjectEvent{{ID: 1, Type: models.EventObjectOnline, Tenant: "acme", Object: models.Callback{Tenant: "acme", ID: 1, Online: true}}})
+	next("id: 1")
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// maxSubscriptionBytes limits the size of the bodies registering a webhook.
+const maxSubscriptionBytes = 64 << 10
+
+// Webhooks defines the handlers managing the webhooks told about the object events of the tenant
+// of the request.
+type Webhooks struct {
+	wsvc models.WebhookService
+
+	log *log.Logger
+}
+
+// NewWebhooks creates a new Webhooks controller.
+func NewWebhooks(wsvc models.WebhookService, log *log.Logger) *Webhooks {
+
+	return &Webhooks{
+		wsvc: wsvc,
+		log:  log,
+	}
+}
+
+// subscriptionRequest is the body registering a webhook. Empty events subscribe to every type,
+// and an empty secret is generated.
+type subscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// subscriptionList is the response sent back when listing webhooks.
+type subscriptionList struct {
+	Webhooks []models.WebhookSubscription `json:"webhooks"`
+}
+
+// deliveryList is the response sent back when listing the deliveries of a webhook.
+type deliveryList struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// Create registers a webhook. The response holds its secret, which is not returned again.
+func (wh *Webhooks) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Create")
+	defer span.End()
+
+	var req subscriptionRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxSubscriptionBytes)).Decode(&req)
+	if err != nil {
+		web.RespondError(ctx, w, ErrInvalidJSONInput, http.StatusBadRequest)
+		return
+	}
+
+	ws, err := wh.wsvc.Subscribe(ctx, models.WebhookSubscription{
+		Tenant: requestTenant(ctx),
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: req.Secret,
+	})
+	var merr models.ModelError
+	switch {
+	case errors.As(err, &merr):
+		web.RespondError(ctx, w, err, http.StatusBadRequest)
+		return
+	case err != nil:
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	web.Respond(ctx, w, ws, http.StatusCreated)
+}
+
+// List returns the webhooks of the tenant.
+func (wh *Webhooks) List(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.List")
+	defer span.End()
+
+	list, err := wh.wsvc.Subscriptions(ctx, requestTenant(ctx))
+	if err != nil {
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.WebhookSubscription{}
+	}
+
+	web.Respond(ctx, w, subscriptionList{Webhooks: list}, http.StatusOK)
+}
+
+// Delete removes the webhook identified by the id URL parameter.
+func (wh *Webhooks) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Delete")
+	defer span.End()
+
+	id, err := strconv.ParseInt(web.Param(r, "id"), 10, 64)
+	if err != nil {
+		web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	err = wh.wsvc.Unsubscribe(ctx, requestTenant(ctx), id)
+	switch {
+	case err == models.ErrNotFound:
+		web.RespondError(ctx, w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Deliveries returns the latest deliveries of the webhook identified by the id URL parameter,
+// with every attempt made to send them.
+func (wh *Webhooks) Deliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Deliveries")
+	defer span.End()
+
+	id, err := strconv.ParseInt(web.Param(r, "id"), 10, 64)
+	if err != nil {
+		web.RespondError(ctx, w, ErrInvalidParameter, http.StatusBadRequest)
+		return
+	}
+
+	list, err := wh.wsvc.Deliveries(ctx, requestTenant(ctx), id)
+	switch {
+	case err == models.ErrNotFound:
+		web.RespondError(ctx, w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		web.RespondError(ctx, w, err, http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.WebhookDelivery{}
+	}
+
+	web.Respond(ctx, w, deliveryList{Deliveries: list}, http.StatusOK)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// testWebhookService is a models.WebhookService holding a subscription of the "acme" tenant.
+type testWebhookService struct {
+	models.WebhookService
+	subscriptions []models.WebhookSubscription
+}
+
+func (t *testWebhookService) Subscribe(ctx context.Context, ws models.WebhookSubscription) (models.WebhookSubscription, error) {
+	if !strings.HasPrefix(ws.URL, "https://") {
+		return models.WebhookSubscription{}, models.ErrInvalidWebhookURL
+	}
+	if ws.Secret == "" {
+		ws.Secret = "whsec_generated"
+	}
+	ws.ID, ws.CreatedAt = 2, time.Unix(0, 0).UTC()
+	t.subscriptions = append(t.subscriptions, ws)
+	return ws, nil
+}
+
+func (t *testWebhookService) Subscriptions(ctx context.Context, tenant string) ([]models.WebhookSubscription, error) {
+	var list []models.WebhookSubscription
+	for _, ws := range t.subscriptions {
+		if ws.Tenant == tenant {
+			ws.Secret = ""
+			list = append(list, ws)
+		}
+	}
+	return list, nil
+}
+
+func (t *testWebhookService) Unsubscribe(ctx context.Context, tenant string, id int64) error {
+	for i, ws := range t.subscriptions {
+		if ws.Tenant == tenant && ws.ID == id {
+			t.subscriptions = append(t.subscriptions[:i], t.subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (t *testWebhookService) Deliveries(ctx context.Context, tenant string, id int64) ([]models.WebhookDelivery, error) {
+	for _, ws := range t.subscriptions {
+		if ws.Tenant == tenant && ws.ID == id {
+			return []models.WebhookDelivery{{
+				ID: 5, SubscriptionID: id, Tenant: tenant, Event: models.EventObjectOnline, ObjectID: 7,
+				Status: models.DeliveryDelivered, Attempts: 1,
+				NextAttemptAt: time.Unix(0, 0).UTC(), CreatedAt: time.Unix(0, 0).UTC(),
+				AttemptLog: []models.WebhookAttempt{{Attempt: 1, StatusCode: 204, DurationMS: 12, CreatedAt: time.Unix(0, 0).UTC()}},
+			}}, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func newTestWebhookService() *testWebhookService {
+	return &testWebhookService{subscriptions: []models.WebhookSubscription{
+		{ID: 1, Tenant: "acme", URL: "https://hooks.acme.com", Secret: "whsec_acme", CreatedAt: time.Unix(0, 0).UTC()},
+	}}
+}
+
+// withParams returns r carrying the id URL parameter and the tenant of the request.
+func withParams(r *http.Request, tenant, id string) (context.Context, *http.Request) {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	return context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: tenant}), r
+}
+
+func TestWebhooks_Create(t *testing.T) {
+	var cases = []struct {
+		name      string
+		body      string
+		outStatus int
+		outJSON   string
+	}{
+		{
+			"ok",
+			`{"url":"https://hooks.acme.com/offline","events":["object.offline"]}`,
+			http.StatusCreated,
+			`{"id":2,"tenant":"acme","url":"https://hooks.acme.com/offline","events":["object.offline"],
+				"secret":"whsec_generated","created_at":"1970-01-01T00:00:00Z"}`,
+		},
+		{
+			"invalidURL",
+			`{"url":"hooks.acme.com"}`,
+			http.StatusBadRequest,
+			`{"error":"invalid_webhook_url","message":"webhook url must be an absolute http or https url"}`,
+		},
+		{
+			"invalidJSON",
+			`{"url":`,
+			http.StatusBadRequest,
+			`{"error":"invalid_json","message":"provided input cannot be parsed"}`,
+		},
+	}
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			wh := NewWebhooks(newTestWebhookService(), nil)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(cs.body))
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: "acme"})
+
+			wh.Create(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodPost, "/webhooks", w)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+		})
+	}
+}
+
+func TestWebhooks_List(t *testing.T) {
+	wh := NewWebhooks(newTestWebhookService(), nil)
+
+	for tenant, want := range map[string]string{
+		"acme":  `{"webhooks":[{"id":1,"tenant":"acme","url":"https://hooks.acme.com","events":null,"created_at":"1970-01-01T00:00:00Z"}]}`,
+		"other": `{"webhooks":[]}`,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+		ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: tenant})
+
+		wh.List(ctx, w, r)
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assertOpenAPI(t, http.MethodGet, "/webhooks", w)
+		assert.JSONEq(t, want, w.Body.String(), tenant)
+	}
+}
+
+func TestWebhooks_Delete(t *testing.T) {
+	var cases = []struct {
+		name      string
+		tenant    string
+		id        string
+		outStatus int
+	}{
+		{"ok", "acme", "1", http.StatusNoContent},
+		{"otherTenant", "other", "1", http.StatusNotFound},
+		{"invalidID", "acme", "one", http.StatusBadRequest},
+	}
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			wh := NewWebhooks(newTestWebhookService(), nil)
+			w := httptest.NewRecorder()
+			ctx, r := withParams(httptest.NewRequest(http.MethodDelete, "/webhooks/"+cs.id, nil), cs.tenant, cs.id)
+
+			wh.Delete(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assertOpenAPI(t, http.MethodDelete, "/webhooks/{id}", w)
+		})
+	}
+}
+
+func TestWebhooks_Deliveries(t *testing.T) {
+	wh := NewWebhooks(newTestWebhookService(), nil)
+
+	w := httptest.NewRecorder()
+	ctx, r := withParams(httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries", nil), "acme", "1")
+	wh.Deliveries(ctx, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assertOpenAPI(t, http.MethodGet, "/webhooks/{id}/deliveries", w)
+	assert.JSONEq(t, `{"deliveries":[{
+		"id":5,"subscription_id":1,"tenant":"acme","event":"object.online","object_id":7,
+		"status":"delivered","attempts":1,"next_attempt_at":"1970-01-01T00:00:00Z",
+		"created_at":"1970-01-01T00:00:00Z","delivered_at":null,
+		"attempt_log":[{"attempt":1,"status_code":204,"duration_ms":12,"created_at":"1970-01-01T00:00:00Z"}]
+	}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ctx, r = withParams(httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries", nil), "other", "1")
+	wh.Deliveries(ctx, w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	assertOpenAPI(t, http.MethodGet, "/webhooks/{id}/deliveries", w)
+}
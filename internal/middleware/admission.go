@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// AdmissionConfig defines when the requests adding work to a backlog are shed.
+type AdmissionConfig struct {
+	// MaxPending is the backlog size from which requests are shed. Zero disables the check.
+	MaxPending int64
+	// MaxLatency is the average processing latency from which requests are shed while there is a
+	// backlog. Zero disables the check.
+	MaxLatency time.Duration
+	// RetryAfter is the time shed clients are asked to wait before retrying.
+	RetryAfter time.Duration
+}
+
+// Enabled reports whether any threshold is set.
+func (ac AdmissionConfig) Enabled() bool {
+	return ac.MaxPending > 0 || ac.MaxLatency > 0
+}
+
+// Shed returns the reason to shed the work received under load, or an empty string if it can be
+// admitted.
+//
+// The latency threshold only applies while there is a backlog, as the average is not updated
+// when no work is processed.
+func (ac AdmissionConfig) Shed(load models.Load) string {
+	switch {
+	case ac.MaxPending > 0 && load.Pending >= ac.MaxPending:
+		return "shed_pending"
+	case ac.MaxLatency > 0 && load.Pending > 0 && load.Latency >= ac.MaxLatency:
+		return "shed_latency"
+	}
+	return ""
+}
+
+// Admission answers 503 to the requests received while the backlog of lr crosses the thresholds
+// of cfg, so work doesn't pile up in memory faster than it is processed. It must only wrap the
+// routes adding to the backlog, health checks are never shed.
+func Admission(lr models.LoadReporter, cfg AdmissionConfig) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Admission")
+			defer span.End()
+
+			load := lr.Load()
+			m.backlog.Set(load.Pending)
+			m.latency.Set(load.Latency.Milliseconds())
+
+			if reason := cfg.Shed(load); reason != "" {
+				m.admission.Add(reason, 1)
+				span.AddAttributes(trace.StringAttribute("shed", reason))
+				w.Header().Set("Retry-After", ceilSeconds(cfg.RetryAfter))
+				web.RespondError(ctx, w, ErrOverloaded, http.StatusServiceUnavailable)
+				return
+			}
+
+			m.admission.Add("admitted", 1)
+			after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
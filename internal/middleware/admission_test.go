@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// testLoadReporter is a models.LoadReporter returning a fixed load.
+type testLoadReporter models.Load
+
+func (t testLoadReporter) Load() models.Load { return models.Load(t) }
+
+func TestAdmission(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+	cfg := AdmissionConfig{MaxPending: 100, MaxLatency: time.Second, RetryAfter: 1500 * time.Millisecond}
+
+	var cases = []struct {
+		name      string
+		load      models.Load
+		outStatus int
+	}{
+		{"idle", models.Load{}, http.StatusOK},
+		{"belowThresholds", models.Load{Pending: 99, Latency: 999 * time.Millisecond}, http.StatusOK},
+		{"pending", models.Load{Pending: 100}, http.StatusServiceUnavailable},
+		{"latency", models.Load{Pending: 1, Latency: time.Second}, http.StatusServiceUnavailable},
+		{"latencyWithoutBacklog", models.Load{Latency: time.Minute}, http.StatusOK},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+			Admission(testLoadReporter(cs.load), cfg)(handler)(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Code)
+			if cs.outStatus == http.StatusServiceUnavailable {
+				assert.Equal(t, "2", w.Header().Get("Retry-After"))
+				assert.JSONEq(t, `{"error":"overloaded","message":"service is under heavy load, retry later"}`, w.Body.String())
+			}
+		})
+	}
+}
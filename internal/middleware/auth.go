@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// APIKeyHeader is the header that can carry the API key of a request, as an alternative to a
+// bearer Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+// Authenticate rejects requests without a valid API key. The client owning the key, and the
+// tenant it acts for, are recorded on the web.Values of the request, so they show up on logs,
+// traces and metrics.
+func Authenticate(aks models.APIKeyService) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Authenticate")
+			defer span.End()
+
+			// If the context is missing this value, request the service
+			// to be shutdown gracefully.
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				log.Fatal("web value missing from context")
+			}
+
+			key := requestAPIKey(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				web.RespondError(ctx, w, ErrUnauthenticated, http.StatusUnauthorized)
+				return
+			}
+
+			ak, err := aks.Authenticate(ctx, key)
+			switch {
+			case err == models.ErrInvalidAPIKey:
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				web.RespondError(ctx, w, err, http.StatusUnauthorized)
+				return
+			case err != nil:
+				web.RespondError(ctx, w, err, http.StatusInternalServerError)
+				return
+			}
+
+			v.ClientID = ak.Client
+			v.TenantID = ak.Tenant
+			span.AddAttributes(trace.StringAttribute("client_id", ak.Client))
+
+			after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// requestAPIKey returns the API key sent on r, if any.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// testAPIKeyService is a models.APIKeyService knowing a single key.
+type testAPIKeyService struct {
+	models.APIKeyService
+}
+
+func (testAPIKeyService) Authenticate(ctx context.Context, key string) (models.APIKey, error) {
+	if key != "cbk_valid" {
+		return models.APIKey{}, models.ErrInvalidAPIKey
+	}
+	return models.APIKey{ID: 1, Client: "exporter"}, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	var client string
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		client = ctx.Value(web.KeyValues).(*web.Values).ClientID
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+	h := Authenticate(testAPIKeyService{})(handler)
+
+	var cases = []struct {
+		name      string
+		headers   map[string]string
+		outStatus int
+		outJSON   string
+		outClient string
+	}{
+		{
+			"bearer",
+			map[string]string{"Authorization": "Bearer cbk_valid"},
+			http.StatusOK, `{}`, "exporter",
+		},
+		{
+			"apiKeyHeader",
+			map[string]string{APIKeyHeader: "cbk_valid"},
+			http.StatusOK, `{}`, "exporter",
+		},
+		{
+			"missing",
+			nil,
+			http.StatusUnauthorized,
+			`{"error":"unauthenticated","message":"request must carry an api key"}`,
+			"",
+		},
+		{
+			"basicAuth",
+			map[string]string{"Authorization": "Basic Y2JrX3ZhbGlkOg=="},
+			http.StatusUnauthorized,
+			`{"error":"unauthenticated","message":"request must carry an api key"}`,
+			"",
+		},
+		{
+			"invalid",
+			map[string]string{"Authorization": "Bearer cbk_revoked"},
+			http.StatusUnauthorized,
+			`{"error":"invalid_api_key","message":"provided api key is not valid"}`,
+			"",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			client = ""
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			for k, v := range cs.headers {
+				r.Header.Set(k, v)
+			}
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+			h(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+			assert.Equal(t, cs.outClient, client)
+		})
+	}
+}
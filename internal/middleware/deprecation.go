@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// DeprecationConfig defines how the deprecation of a route is announced to its clients.
+type DeprecationConfig struct {
+	// Since is when the route was deprecated, sent on the Deprecation header (RFC 9745).
+	Since time.Time
+	// Sunset is when the route stops being served, sent on the Sunset header (RFC 8594).
+	Sunset time.Time
+	// Link points to a document describing the deprecation, such as a migration guide.
+	Link string
+	// LinkType is the media type of the document of Link, left out of the header when empty.
+	LinkType string
+}
+
+// Enabled reports whether the route is deprecated.
+func (dc DeprecationConfig) Enabled() bool {
+	return !dc.Since.IsZero() || !dc.Sunset.IsZero()
+}
+
+// Deprecation adds the Deprecation, Sunset and Link headers of cfg to every response of route,
+// the errors of the following middlewares included, and counts its requests on
+// deprecated_requests_by_route so the clients can be followed up before the sunset.
+func Deprecation(route string, cfg DeprecationConfig) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Deprecation")
+			defer span.End()
+
+			if !cfg.Since.IsZero() {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", cfg.Since.Unix()))
+			}
+			if !cfg.Sunset.IsZero() {
+				w.Header().Set("Sunset", cfg.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if cfg.Link != "" {
+				link := fmt.Sprintf(`<%s>; rel="deprecation"`, cfg.Link)
+				if cfg.LinkType != "" {
+					link += fmt.Sprintf(`; type=%q`, cfg.LinkType)
+				}
+				w.Header().Add("Link", link)
+			}
+
+			m.deprecated.Add(route, 1)
+			span.AddAttributes(trace.StringAttribute("deprecated_route", route))
+			after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
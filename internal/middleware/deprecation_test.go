@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+func TestDeprecation(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		web.RespondError(ctx, w, ErrUnauthenticated, http.StatusUnauthorized)
+	}
+	since := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 4, 19, 0, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	var cases = []struct {
+		name          string
+		cfg           DeprecationConfig
+		outDeprecated string
+		outSunset     string
+		outLink       string
+	}{
+		{"deprecated", DeprecationConfig{Since: since}, "@1792368000", "", ""},
+		{"sunset", DeprecationConfig{Sunset: sunset}, "", "Sun, 18 Apr 2027 22:00:00 GMT", ""},
+		{"all", DeprecationConfig{Since: since, Sunset: sunset, Link: "https://docs.example.com/v2"},
+			"@1792368000", "Sun, 18 Apr 2027 22:00:00 GMT", `<https://docs.example.com/v2>; rel="deprecation"`},
+		{"linkType", DeprecationConfig{Since: since, Link: "https://docs.example.com/v2.pdf", LinkType: "application/pdf"},
+			"@1792368000", "", `<https://docs.example.com/v2.pdf>; rel="deprecation"; type="application/pdf"`},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			assert.True(t, cs.cfg.Enabled())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+			Deprecation("POST /callback", cs.cfg)(handler)(ctx, w, r)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code, "responses are left as they are")
+			assert.Equal(t, cs.outDeprecated, w.Header().Get("Deprecation"))
+			assert.Equal(t, cs.outSunset, w.Header().Get("Sunset"))
+			assert.Equal(t, cs.outLink, w.Header().Get("Link"))
+		})
+	}
+
+	assert.False(t, DeprecationConfig{Link: "https://docs.example.com/v2"}.Enabled())
+}
@@ -0,0 +1,67 @@
+package middleware
+
+// These errors are returned by the middlewares and can be used to provide error codes to the
+// API results.
+const (
+	ErrBodyTooLarge          MiddlewareError = "middleware: body_too_large, request body exceeds the configured limit"
+	ErrUnreadableBody        MiddlewareError = "middleware: unreadable_body, request body could not be read"
+	ErrInvalidIdempotencyKey MiddlewareError = "middleware: invalid_idempotency_key, idempotency key must hold between 1 and 255 characters"
+	ErrIdempotencyKeyReused  MiddlewareError = "middleware: idempotency_key_reused, idempotency key was already used with a different request"
+	ErrRequestInProgress     MiddlewareError = "middleware: request_in_progress, a request with the same idempotency key is being processed"
+	ErrSignatureMissing      MiddlewareError = "middleware: signature_missing, request must be signed"
+	ErrSignatureInvalid      MiddlewareError = "middleware: signature_invalid, request signature does not match its body"
+	ErrSignatureExpired      MiddlewareError = "middleware: signature_expired, request signature timestamp is outside of the tolerance window"
+	ErrUnauthenticated       MiddlewareError = "middleware: unauthenticated, request must carry an api key"
+	ErrTenantNotFound        MiddlewareError = "middleware: tenant_not_found, tenant does not exist"
+	ErrTenantForbidden       MiddlewareError = "middleware: tenant_forbidden, api key can't act on behalf of this tenant"
+	ErrTenantAnonymous       MiddlewareError = "middleware: tenant_anonymous, request must carry an api key of the tenant it names"
+	ErrRateLimited           MiddlewareError = "middleware: rate_limited, too many requests, retry later"
+	ErrOverloaded            MiddlewareError = "middleware: overloaded, service is under heavy load, retry later"
+)
+
+// MiddlewareError defines errors exported by this package. This type implement a Code() method that
+// extracts a unique error code defined for each error value exported.
+type MiddlewareError string
+
+// Error returns the exact original message of the e value.
+func (e MiddlewareError) Error() string {
+	return string(e)
+}
+
+// Code extracts the error code string present on the value of e.
+//
+// An error code is defined as the string after the package prefix and colon, and before the comma that follows this string. Example:
+//		"middleware: error_code, this is a validation error"
+func (e MiddlewareError) Code() string {
+	// remove the prefix
+	s := string(e)[len("middleware: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[:i]
+			break
+		}
+	}
+
+	return s
+}
+
+// Detail extracts the error detail string present on the value of e.
+//
+// An error detail is defined as the string after the package prefix and colon, and after the comma that follows this string. Example:
+//		"middleware: error_code, this is the error detail string"
+func (e MiddlewareError) Detail() string {
+	// remove the prefix
+	s := string(e)[len("middleware: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[i+2:] // +2 removes the comma and the space
+			break
+		}
+	}
+
+	return s
+}
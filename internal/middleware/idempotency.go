@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on responses replayed from a stored idempotency key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotency makes the requests carrying an Idempotency-Key header safe to retry. The first
+// successful response sent for a key is stored with iks and sent back, without calling the
+// handler again, to the requests retried with the same key and body. A key sent again with a
+// different body is rejected. The key is freed when the request fails, and expires if it never
+// completes.
+//
+// Keys are scoped to the tenant and authenticated client, if any, so two clients can't see each
+// other's responses. Bodies of requests with a key are read in memory, up to maxBodyBytes, to be checked
+// against the stored request. Requests without a key are not affected.
+func Idempotency(iks models.IdempotencyService, maxBodyBytes int64, log *log.Logger) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				after(ctx, w, r)
+				return
+			}
+
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Idempotency")
+			defer span.End()
+			span.AddAttributes(trace.StringAttribute("idempotency_key", key))
+
+			if len(key) > maxIdempotencyKeyLength {
+				web.RespondError(ctx, w, ErrInvalidIdempotencyKey, http.StatusBadRequest)
+				return
+			}
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+				key = v.TenantID + ":" + v.ClientID + ":" + key
+			}
+
+			body, err := readBody(r, maxBodyBytes)
+			switch {
+			case err == ErrBodyTooLarge:
+				web.RespondError(ctx, w, err, http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				web.RespondError(ctx, w, ErrUnreadableBody, http.StatusBadRequest)
+				return
+			}
+
+			hash := requestHash(r, body)
+			ik, claimed, err := iks.Claim(ctx, key, hash)
+			if err != nil {
+				log.Printf("idempotency_error: %v", err)
+				web.RespondError(ctx, w, err, http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				replay(ctx, w, ik, hash)
+				return
+			}
+
+			// A panicking handler frees the key before the panic is recovered, so the request can
+			// be retried right away.
+			defer func() {
+				if p := recover(); p != nil {
+					if err := iks.Release(ctx, ik); err != nil {
+						log.Printf("idempotency_error: %v", err)
+					}
+					panic(p)
+				}
+			}()
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			rec := newResponseRecorder(w)
+			after(ctx, rec, r)
+
+			// Only successful responses are kept. Failed requests had no effect and can be retried.
+			if rec.status < 200 || rec.status > 299 {
+				if err := iks.Release(ctx, ik); err != nil {
+					log.Printf("idempotency_error: %v", err)
+				}
+				return
+			}
+			if err := iks.Complete(ctx, ik, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+				log.Printf("idempotency_error: %v", err)
+			}
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// replay answers with the response stored on ik, as long as ik was claimed by a request with the
+// same hash.
+func replay(ctx context.Context, w http.ResponseWriter, ik models.IdempotencyKey, hash string) {
+	switch {
+	case ik.RequestHash != hash:
+		web.RespondError(ctx, w, ErrIdempotencyKeyReused, http.StatusUnprocessableEntity)
+		return
+	case !ik.Completed():
+		web.RespondError(ctx, w, ErrRequestInProgress, http.StatusConflict)
+		return
+	}
+
+	// Set the status code for the request logger middleware.
+	if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+		v.StatusCode = ik.StatusCode
+	}
+
+	if ik.ContentType != "" {
+		w.Header().Set("Content-Type", ik.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(ik.StatusCode)
+	w.Write(ik.Body)
+}
+
+// requestHash summarizes the method, path, content type and body of r.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join([]string{r.Method, r.URL.Path, r.Header.Get("Content-Type")}, "\n")))
+	h.Write([]byte("\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readBody reads the whole body of r, failing with ErrBodyTooLarge if it is bigger than
+// maxBodyBytes. A limit lower or equal to zero disables the check.
+func readBody(r *http.Request, maxBodyBytes int64) ([]byte, error) {
+	if maxBodyBytes <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// responseRecorder is an http.ResponseWriter keeping a copy of the status code and body written
+// through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if rr.status == 0 {
+		rr.status = statusCode
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter.
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(p)
+	return rr.ResponseWriter.Write(p)
+}
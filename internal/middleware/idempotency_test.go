@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// memoryIdempotency is an in-memory models.IdempotencyService.
+type memoryIdempotency struct {
+	keys map[string]models.IdempotencyKey
+}
+
+func (m *memoryIdempotency) Claim(ctx context.Context, key, requestHash string) (models.IdempotencyKey, bool, error) {
+	if ik, ok := m.keys[key]; ok {
+		return ik, false, nil
+	}
+	m.keys[key] = models.IdempotencyKey{Key: key, RequestHash: requestHash}
+	return m.keys[key], true, nil
+}
+
+func (m *memoryIdempotency) Complete(ctx context.Context, claim models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	now := time.Now()
+	ik := m.keys[claim.Key]
+	ik.StatusCode, ik.ContentType, ik.Body, ik.CompletedAt = statusCode, contentType, body, &now
+	m.keys[claim.Key] = ik
+	return nil
+}
+
+func (m *memoryIdempotency) Release(ctx context.Context, claim models.IdempotencyKey) error {
+	delete(m.keys, claim.Key)
+	return nil
+}
+
+func (m *memoryIdempotency) WithWindow(window time.Duration) models.IdempotencyService { return m }
+
+func (m *memoryIdempotency) Close() {}
+
+func TestIdempotency(t *testing.T) {
+	iks := &memoryIdempotency{keys: make(map[string]models.IdempotencyKey)}
+
+	calls := 0
+	status := http.StatusOK
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		web.Respond(ctx, w, map[string]int{"call": calls, "bytes": len(body)}, status)
+	}
+	h := Idempotency(iks, 64, log.New(ioutil.Discard, "", 0))(handler)
+
+	var cases = []struct {
+		name      string
+		key       string
+		input     string
+		setup     func()
+		outStatus int
+		outJSON   string
+		outCalls  int
+		replayed  bool
+	}{
+		{"noKey", "", `{"object_ids":[1]}`, nil, http.StatusOK, `{"call":1,"bytes":18}`, 1, false},
+		{"first", "k1", `{"object_ids":[1]}`, nil, http.StatusOK, `{"call":2,"bytes":18}`, 2, false},
+		{"replayed", "k1", `{"object_ids":[1]}`, nil, http.StatusOK, `{"call":2,"bytes":18}`, 2, true},
+		{
+			"reusedWithOtherBody", "k1", `{"object_ids":[2]}`, nil,
+			http.StatusUnprocessableEntity,
+			`{"error":"idempotency_key_reused","message":"idempotency key was already used with a different request"}`,
+			2, false,
+		},
+		{
+			"failedIsNotStored", "k3", `{"object_ids":[1]}`,
+			func() { status = http.StatusNotAcceptable },
+			http.StatusNotAcceptable, `{"call":3,"bytes":18}`, 3, false,
+		},
+		{
+			"retriedAfterFailure", "k3", `{"object_ids":[1]}`,
+			func() { status = http.StatusOK },
+			http.StatusOK, `{"call":4,"bytes":18}`, 4, false,
+		},
+		{
+			"bodyTooLarge", "k4", strings.Repeat("1", 65), nil,
+			http.StatusRequestEntityTooLarge,
+			`{"error":"body_too_large","message":"request body exceeds the configured limit"}`,
+			4, false,
+		},
+		{
+			"keyTooLong", strings.Repeat("k", 256), `{}`, nil,
+			http.StatusBadRequest,
+			`{"error":"invalid_idempotency_key","message":"idempotency key must hold between 1 and 255 characters"}`,
+			4, false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if cs.setup != nil {
+				cs.setup()
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(cs.input))
+			if cs.key != "" {
+				r.Header.Set(IdempotencyKeyHeader, cs.key)
+			}
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+			h(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+			assert.Equal(t, cs.outCalls, calls)
+			assert.Equal(t, cs.replayed, w.Header().Get(IdempotentReplayedHeader) == "true")
+		})
+	}
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	iks := &memoryIdempotency{keys: make(map[string]models.IdempotencyKey)}
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		// A request sent again while the first one is still being processed.
+		w2 := httptest.NewRecorder()
+		r2 := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(`{}`))
+		r2.Header.Set(IdempotencyKeyHeader, "k1")
+		Idempotency(iks, 0, log.New(ioutil.Discard, "", 0))(nil)(ctx, w2, r2)
+
+		assert.Equal(t, http.StatusConflict, w2.Result().StatusCode)
+		assert.JSONEq(t,
+			`{"error":"request_in_progress","message":"a request with the same idempotency key is being processed"}`,
+			w2.Body.String(),
+		)
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(`{}`))
+	r.Header.Set(IdempotencyKeyHeader, "k1")
+	ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+	Idempotency(iks, 0, log.New(ioutil.Discard, "", 0))(handler)(ctx, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.True(t, iks.keys["::k1"].Completed(), "keys are scoped to the tenant and client")
+}
+
+func TestIdempotency_Panic(t *testing.T) {
+	iks := &memoryIdempotency{keys: make(map[string]models.IdempotencyKey)}
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(`{}`))
+	r.Header.Set(IdempotencyKeyHeader, "k1")
+	ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+	assert.Panics(t, func() { Idempotency(iks, 0, log.New(ioutil.Discard, "", 0))(handler)(ctx, w, r) })
+	assert.Empty(t, iks.keys, "keys of panicking requests are freed")
+}
@@ -13,6 +13,8 @@ import (
 
 // Logger writes some information about the request to the logs in the
 // format: TraceID : (200) GET /foo -> IP ADDR (latency)
+//
+// Requests of an authenticated client are suffixed with: : client ID
 func Logger(log *log.Logger) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -32,11 +34,17 @@ func Logger(log *log.Logger) web.Middleware {
 
 			before(ctx, w, r)
 
-			log.Printf("%s : (%d) : %s %s -> %s (%s)",
+			format := "%s : (%d) : %s %s -> %s (%s)"
+			args := []interface{}{
 				v.TraceID, v.StatusCode,
 				r.Method, r.URL.Path,
 				r.RemoteAddr, time.Since(v.Start),
-			)
+			}
+			if v.ClientID != "" {
+				format += " : client %s"
+				args = append(args, v.ClientID)
+			}
+			log.Printf(format, args...)
 		}
 
 		return h
@@ -13,13 +13,29 @@ import (
 
 // m contains the global program counters for the application.
 var m = struct {
-	gr  *expvar.Int
-	req *expvar.Int
-	err *expvar.Int
+	gr      *expvar.Int
+	req     *expvar.Int
+	err     *expvar.Int
+	clients *expvar.Map
+	limited *expvar.Map
+
+	deprecated *expvar.Map
+
+	admission *expvar.Map
+	backlog   *expvar.Int
+	latency   *expvar.Int
 }{
-	gr:  expvar.NewInt("goroutines"),
-	req: expvar.NewInt("requests"),
-	err: expvar.NewInt("errors"),
+	gr:      expvar.NewInt("goroutines"),
+	req:     expvar.NewInt("requests"),
+	err:     expvar.NewInt("errors"),
+	clients: expvar.NewMap("requests_by_client"),
+	limited: expvar.NewMap("rate_limited_by_route"),
+
+	deprecated: expvar.NewMap("deprecated_requests_by_route"),
+
+	admission: expvar.NewMap("admission"),
+	backlog:   expvar.NewInt("backlog_pending"),
+	latency:   expvar.NewInt("backlog_latency_ms"),
 }
 
 // Metrics updates program counters.
@@ -38,6 +54,11 @@ func Metrics() web.Middleware {
 			// Increment the request counter.
 			m.req.Add(1)
 
+			// Count the requests of every authenticated client.
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok && v.ClientID != "" {
+				m.clients.Add(v.ClientID, 1)
+			}
+
 			// Update the count for the number of active goroutines every 100 requests.
 			if m.req.Value()%100 == 0 {
 				m.gr.Set(int64(runtime.NumGoroutine()))
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// RateLimit rejects with 429 the requests of a client exceeding limit on a route. Clients are
+// identified by their API key client and tenant or, for anonymous requests, by their IP, taken
+// from the X-Forwarded-For header of the requests sent through proxies. Every route keeps its own
+// buckets, named after route.
+//
+// Responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and a
+// Retry-After header when rejected. Requests are let through if the buckets can't be reached.
+func RateLimit(rls models.RateLimitService, route string, limit models.RateLimit, proxies TrustedProxies, log *log.Logger) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.RateLimit")
+			defer span.End()
+
+			// If the context is missing this value, request the service
+			// to be shutdown gracefully.
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				log.Fatal("web value missing from context")
+			}
+
+			res, err := rls.Allow(ctx, route+":"+RateLimitIdentity(v, proxies.ClientIP(r)), limit)
+			if err != nil {
+				log.Printf("%s : rate_limit_error: %v", v.TraceID, err)
+				after(ctx, w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				m.limited.Add(route, 1)
+				w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+				web.RespondError(ctx, w, ErrRateLimited, http.StatusTooManyRequests)
+				return
+			}
+
+			after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// RateLimitIdentity returns the identity the requests of v, sent from remoteAddr, with or without
+// a port, are limited by.
+func RateLimitIdentity(v *web.Values, remoteAddr string) string {
+	if v.ClientID != "" {
+		return "client:" + v.TenantID + ":" + v.ClientID
+	}
+
+	return "ip:" + remoteHost(remoteAddr)
+}
+
+// TrustedProxies are the networks of the proxies and load balancers trusted to name the client
+// of the requests they forward on the X-Forwarded-For header.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses the networks of the trusted proxies, in CIDR notation or as single
+// IPs.
+func ParseTrustedProxies(specs []string) (TrustedProxies, error) {
+	var tp TrustedProxies
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		cidr := spec
+		if !strings.Contains(spec, "/") {
+			cidr += "/32"
+			if strings.Contains(spec, ":") {
+				cidr = spec + "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("middleware: invalid trusted proxy %q: %w", spec, err)
+		}
+		tp = append(tp, n)
+	}
+	return tp, nil
+}
+
+// ClientIP returns the IP of the client that sent r. Requests sent by a trusted proxy are
+// attributed to the last address of their X-Forwarded-For header that is not a trusted proxy,
+// walking the header from the right as every proxy appends the address it got the request from.
+// Other requests are attributed to their remote address, whatever the header says.
+func (tp TrustedProxies) ClientIP(r *http.Request) string {
+	host := remoteHost(r.RemoteAddr)
+	if !tp.contains(host) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Addresses left of a malformed one can't be trusted.
+			return host
+		}
+		host = ip.String()
+		if !tp.contains(host) {
+			return host
+		}
+	}
+	return host
+}
+
+// contains reports whether host is the IP of a trusted proxy.
+func (tp TrustedProxies) contains(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range tp {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the host of the remote address addr, without its port.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
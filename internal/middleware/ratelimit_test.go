@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// failingRateLimitService is a models.RateLimitService whose buckets can't be reached.
+type failingRateLimitService struct{}
+
+func (failingRateLimitService) Allow(context.Context, string, models.RateLimit) (models.RateLimitResult, error) {
+	return models.RateLimitResult{}, errors.New("connection refused")
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+	limit := models.RateLimit{Rate: 0.5, Burst: 2}
+	h := RateLimit(models.NewMemoryRateLimitService(), "callback", limit, nil, log.New(ioutil.Discard, "", 0))(handler)
+
+	do := func(client, remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+		r.RemoteAddr = remoteAddr
+		ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{ClientID: client})
+		h(ctx, w, r)
+		return w
+	}
+
+	w := do("", "10.0.0.1:4000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+
+	// Anonymous requests are limited by IP, whatever their port.
+	assert.Equal(t, http.StatusOK, do("", "10.0.0.1:4001").Code)
+	w = do("", "10.0.0.1:4002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.JSONEq(t, `{"error":"rate_limited","message":"too many requests, retry later"}`, w.Body.String())
+
+	// Other IPs and clients have their own buckets.
+	assert.Equal(t, http.StatusOK, do("", "10.0.0.2:4000").Code)
+	assert.Equal(t, http.StatusOK, do("exporter", "10.0.0.1:4000").Code)
+}
+
+func TestRateLimit_Unavailable(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+	h := RateLimit(failingRateLimitService{}, "callback", models.RateLimit{Rate: 1, Burst: 1}, nil, log.New(ioutil.Discard, "", 0))(handler)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+	ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+	h(ctx, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code, "requests are let through when the limits can't be checked")
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.4", "fd00::1"})
+	assert.NoError(t, err)
+
+	var cases = []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		outIP        string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrustedProxy", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trustedProxy", "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofedByClient", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxyChain", "192.168.1.4:4000", []string{"198.51.100.1, 10.2.0.1", "10.3.0.1"}, "198.51.100.1"},
+		{"ipv6Proxy", "[fd00::1]:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"malformed", "10.0.0.1:4000", []string{"198.51.100.1, unknown, 10.2.0.1"}, "10.2.0.1"},
+		{"noHeader", "10.0.0.1:4000", nil, "10.0.0.1"},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			r.RemoteAddr = cs.remoteAddr
+			for _, h := range cs.forwardedFor {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			assert.Equal(t, cs.outIP, tp.ClientIP(r))
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy.local"})
+	assert.Error(t, err)
+}
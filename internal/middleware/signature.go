@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// SignatureConfig holds the settings of the Signature middleware of a route.
+type SignatureConfig struct {
+	// Secrets are the keys accepted to sign a request. Several secrets can be active at once so
+	// they can be rotated without downtime.
+	Secrets []string
+
+	// Tolerance is the maximum difference between the signature timestamp and the current time.
+	// Requests outside of this window are rejected as replays.
+	Tolerance time.Duration
+
+	// MaxBodyBytes limits the size of the bodies read to verify them. Zero means no limit.
+	MaxBodyBytes int64
+}
+
+// Enabled reports whether requests must be signed.
+func (sc SignatureConfig) Enabled() bool {
+	return len(sc.Secrets) > 0
+}
+
+// Signature rejects requests without a valid HMAC-SHA256 signature of their raw body on the
+// web.SignatureHeader header.
+//
+// Bodies are read in memory to be verified, so signed routes lose the benefits of streaming.
+func Signature(cfg SignatureConfig) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Signature")
+			defer span.End()
+
+			body, err := readBody(r, cfg.MaxBodyBytes)
+			switch {
+			case err == ErrBodyTooLarge:
+				web.RespondError(ctx, w, err, http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				web.RespondError(ctx, w, ErrUnreadableBody, http.StatusBadRequest)
+				return
+			}
+
+			err = web.VerifySignature(r.Header.Get(web.SignatureHeader), body, cfg.Secrets, cfg.Tolerance, time.Now())
+			switch err {
+			case nil:
+			case web.ErrSignatureMissing:
+				web.RespondError(ctx, w, ErrSignatureMissing, http.StatusUnauthorized)
+				return
+			case web.ErrSignatureExpired:
+				web.RespondError(ctx, w, ErrSignatureExpired, http.StatusUnauthorized)
+				return
+			default:
+				web.RespondError(ctx, w, ErrSignatureInvalid, http.StatusUnauthorized)
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
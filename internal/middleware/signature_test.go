@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+func TestSignature(t *testing.T) {
+	cfg := SignatureConfig{
+		Secrets:   []string{"current", "previous"},
+		Tolerance: 5 * time.Minute,
+	}
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		web.Respond(ctx, w, map[string]string{"body": string(body)}, http.StatusOK)
+	}
+	h := Signature(cfg)(handler)
+
+	body := `{"object_ids":[1,2]}`
+	now := time.Now()
+
+	var cases = []struct {
+		name      string
+		signature string
+		outStatus int
+		outJSON   string
+	}{
+		{
+			"ok",
+			web.Sign([]byte(body), now, "current"),
+			http.StatusOK,
+			`{"body":"{\"object_ids\":[1,2]}"}`,
+		},
+		{
+			"rotatedSecret",
+			web.Sign([]byte(body), now, "previous"),
+			http.StatusOK,
+			`{"body":"{\"object_ids\":[1,2]}"}`,
+		},
+		{
+			"severalSignatures",
+			web.Sign([]byte(body), now, "unknown", "current"),
+			http.StatusOK,
+			`{"body":"{\"object_ids\":[1,2]}"}`,
+		},
+		{
+			"missing",
+			"",
+			http.StatusUnauthorized,
+			`{"error":"signature_missing","message":"request must be signed"}`,
+		},
+		{
+			"unknownSecret",
+			web.Sign([]byte(body), now, "unknown"),
+			http.StatusUnauthorized,
+			`{"error":"signature_invalid","message":"request signature does not match its body"}`,
+		},
+		{
+			"otherBody",
+			web.Sign([]byte(`{"object_ids":[1]}`), now, "current"),
+			http.StatusUnauthorized,
+			`{"error":"signature_invalid","message":"request signature does not match its body"}`,
+		},
+		{
+			"malformed",
+			"v1=zz",
+			http.StatusUnauthorized,
+			`{"error":"signature_invalid","message":"request signature does not match its body"}`,
+		},
+		{
+			"replayed",
+			web.Sign([]byte(body), now.Add(-6*time.Minute), "current"),
+			http.StatusUnauthorized,
+			`{"error":"signature_expired","message":"request signature timestamp is outside of the tolerance window"}`,
+		},
+		{
+			"fromTheFuture",
+			web.Sign([]byte(body), now.Add(6*time.Minute), "current"),
+			http.StatusUnauthorized,
+			`{"error":"signature_expired","message":"request signature timestamp is outside of the tolerance window"}`,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+			if cs.signature != "" {
+				r.Header.Set(web.SignatureHeader, cs.signature)
+			}
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+
+			h(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+		})
+	}
+}
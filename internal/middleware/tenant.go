@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"go.opencensus.io/trace"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// tenantKey is how the tenant of a request is stored/retrieved on its context.
+type tenantKey struct{}
+
+// TenantParam is the URL parameter holding the tenant on the tenant prefixed routes.
+const TenantParam = "tenant"
+
+// Tenant resolves the tenant a request is made for and records it on the request web.Values and
+// context. The tenant is taken from the authenticated client, or from the TenantParam URL
+// parameter. Requests with neither belong to models.DefaultTenant.
+//
+// Authenticated clients can't act on behalf of a tenant other than their own, and anonymous
+// requests can't act on behalf of a tenant other than models.DefaultTenant, whether API keys are
+// required or not.
+func Tenant(ts models.TenantService) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			ctx, span := trace.StartSpan(ctx, "internal.middleware.Tenant")
+			defer span.End()
+
+			// If the context is missing this value, request the service
+			// to be shutdown gracefully.
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				log.Fatal("web value missing from context")
+			}
+
+			id := web.Param(r, TenantParam)
+			switch {
+			case v.TenantID != "" && id != "" && id != v.TenantID:
+				web.RespondError(ctx, w, ErrTenantForbidden, http.StatusForbidden)
+				return
+			case v.TenantID == "" && id != "" && id != models.DefaultTenant:
+				web.RespondError(ctx, w, ErrTenantAnonymous, http.StatusForbidden)
+				return
+			case v.TenantID != "":
+				id = v.TenantID
+			case id == "":
+				id = models.DefaultTenant
+			}
+
+			t, err := ts.Get(ctx, id)
+			switch {
+			case err == models.ErrNotFound:
+				web.RespondError(ctx, w, ErrTenantNotFound, http.StatusNotFound)
+				return
+			case err != nil:
+				web.RespondError(ctx, w, err, http.StatusInternalServerError)
+				return
+			}
+
+			v.TenantID = t.ID
+			span.AddAttributes(trace.StringAttribute("tenant_id", t.ID))
+
+			after(context.WithValue(ctx, tenantKey{}, t), w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// TenantFromContext returns the tenant resolved for the request by the Tenant middleware.
+func TenantFromContext(ctx context.Context) (models.Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(models.Tenant)
+	return t, ok
+}
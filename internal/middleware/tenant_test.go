@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// testTenantService is a models.TenantService knowing the default and the "acme" tenants.
+type testTenantService struct {
+	models.TenantService
+}
+
+func (testTenantService) Get(ctx context.Context, id string) (models.Tenant, error) {
+	switch id {
+	case models.DefaultTenant:
+		return models.Tenant{ID: id}, nil
+	case "acme":
+		return models.Tenant{ID: id, MaxObjectsPerCallback: 10}, nil
+	}
+	return models.Tenant{}, models.ErrNotFound
+}
+
+func TestTenant(t *testing.T) {
+	var tenant models.Tenant
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		tenant, _ = TenantFromContext(ctx)
+		assert.Equal(t, tenant.ID, ctx.Value(web.KeyValues).(*web.Values).TenantID)
+		web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	}
+	h := Tenant(testTenantService{})(handler)
+
+	var cases = []struct {
+		name      string
+		param     string
+		authed    string
+		outStatus int
+		outJSON   string
+		outTenant string
+	}{
+		{"default", "", "", http.StatusOK, `{}`, models.DefaultTenant},
+		{"defaultPath", models.DefaultTenant, "", http.StatusOK, `{}`, models.DefaultTenant},
+		{
+			"anonymousPath",
+			"acme", "",
+			http.StatusForbidden,
+			`{"error":"tenant_anonymous","message":"request must carry an api key of the tenant it names"}`,
+			"",
+		},
+		{"apiKey", "", "acme", http.StatusOK, `{}`, "acme"},
+		{"apiKeyAndPath", "acme", "acme", http.StatusOK, `{}`, "acme"},
+		{
+			"forbidden",
+			"acme", models.DefaultTenant,
+			http.StatusForbidden,
+			`{"error":"tenant_forbidden","message":"api key can't act on behalf of this tenant"}`,
+			"",
+		},
+		{
+			"notFound",
+			"", "unknown",
+			http.StatusNotFound,
+			`{"error":"tenant_not_found","message":"tenant does not exist"}`,
+			"",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			tenant = models.Tenant{}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			rctx := chi.NewRouteContext()
+			if cs.param != "" {
+				rctx.URLParams.Add(TenantParam, cs.param)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{TenantID: cs.authed})
+
+			h(ctx, w, r)
+
+			assert.Equal(t, cs.outStatus, w.Result().StatusCode)
+			assert.JSONEq(t, cs.outJSON, w.Body.String())
+			assert.Equal(t, cs.outTenant, tenant.ID)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to spot.
+	apiKeyPrefix = "cbk_"
+
+	// apiKeyBytes is the number of random bytes of an API key.
+	apiKeyBytes = 32
+)
+
+// APIKeyService defines how the API keys used to authenticate the clients of the service are
+// managed.
+type APIKeyService interface {
+	// Create generates a new API key for client, acting on behalf of tenant. The key itself is
+	// only returned here, the database only keeps its hash.
+	Create(ctx context.Context, client, tenant string) (APIKey, string, error)
+
+	// List returns every API key, revoked ones included.
+	List(ctx context.Context) ([]APIKey, error)
+
+	// Revoke disables the API key identified by id.
+	Revoke(ctx context.Context, id int64) error
+
+	// Authenticate returns the active API key matching key.
+	Authenticate(ctx context.Context, key string) (APIKey, error)
+}
+
+// APIKey identifies a client of the service.
+type APIKey struct {
+	ID     int64  `gorm:"primary_key;type:bigserial" json:"id"`
+	Client string `gorm:"type:varchar(255);not null;index" json:"client"`
+	// Tenant owns the objects sent by the client.
+	Tenant string `gorm:"type:varchar(64);not null;default:'default'" json:"tenant"`
+	// Prefix holds the first characters of the key, to help recognizing it.
+	Prefix    string     `gorm:"type:varchar(16);not null" json:"prefix"`
+	Hash      string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// Revoked reports whether the key has been revoked.
+func (ak APIKey) Revoked() bool {
+	return ak.RevokedAt != nil
+}
+
+// NewAPIKeyService returns an APIKeyService storing the keys on the database.
+func NewAPIKeyService(db *gorm.DB) APIKeyService {
+	return &apiKeyGorm{db}
+}
+
+type apiKeyGorm struct {
+	db *gorm.DB
+}
+
+// hashAPIKey returns the hex encoded SHA-256 of key. API keys are random and long enough for a
+// fast hash to be safe.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a random key and stores its hash.
+func (ag *apiKeyGorm) Create(ctx context.Context, client, tenant string) (APIKey, string, error) {
+	ctx, span := trace.StartSpan(ctx, "models.apiKeyGorm.Create")
+	defer span.End()
+
+	client = strings.TrimSpace(client)
+	if client == "" {
+		return APIKey{}, "", ErrInvalidClient
+	}
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	if !ValidTenantID(tenant) {
+		return APIKey{}, "", ErrInvalidTenant
+	}
+
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return APIKey{}, "", fmt.Errorf("models: generating api key %w", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	ak := APIKey{
+		Client:    client,
+		Tenant:    tenant,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		Hash:      hashAPIKey(key),
+		CreatedAt: time.Now(),
+	}
+	if err := ag.db.WithContext(ctx).Create(&ak).Error; err != nil {
+		return APIKey{}, "", fmt.Errorf("models: couldn't create api key %w", err)
+	}
+
+	return ak, key, nil
+}
+
+// List returns every API key, ordered by creation.
+func (ag *apiKeyGorm) List(ctx context.Context) ([]APIKey, error) {
+	ctx, span := trace.StartSpan(ctx, "models.apiKeyGorm.List")
+	defer span.End()
+
+	var aks []APIKey
+	if err := ag.db.WithContext(ctx).Order("id").Find(&aks).Error; err != nil {
+		return nil, fmt.Errorf("models: couldn't list api keys %w", err)
+	}
+	return aks, nil
+}
+
+// Revoke sets the revocation time of the key identified by id.
+func (ag *apiKeyGorm) Revoke(ctx context.Context, id int64) error {
+	ctx, span := trace.StartSpan(ctx, "models.apiKeyGorm.Revoke")
+	defer span.End()
+
+	res := ag.db.WithContext(ctx).Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return fmt.Errorf("models: couldn't revoke api key %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate looks up key by its hash.
+func (ag *apiKeyGorm) Authenticate(ctx context.Context, key string) (APIKey, error) {
+	ctx, span := trace.StartSpan(ctx, "models.apiKeyGorm.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	var ak APIKey
+	err := ag.db.WithContext(ctx).Where("hash = ?", hashAPIKey(key)).First(&ak).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return APIKey{}, ErrInvalidAPIKey
+	case err != nil:
+		return APIKey{}, fmt.Errorf("models: couldn't find api key %w", err)
+	case ak.Revoked():
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	return ak, nil
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"sync"
+)
+
+// brokerSubscriptionBuffer is the number of events a subscription holds before it is dropped for
+// being too slow.
+const brokerSubscriptionBuffer = 256
+
+// EventBroker fans the object events it is notified of out to its subscriptions, keeping the
+// latest ones so a subscription can resume where a previous one stopped. It is an outbox sink.
+type EventBroker struct {
+	size int
+
+	mu     sync.Mutex
+	buffer []ObjectEvent
+	// seen holds the IDs of the buffered events, and floor the highest ID dropped from the
+	// buffer. Events may be notified out of order, as their transactions commit.
+	seen  map[int64]bool
+	floor int64
+	subs  map[chan ObjectEvent]struct{}
+	// done is closed with the broker.
+	done chan struct{}
+}
+
+// NewEventBroker returns an EventBroker keeping the latest size events.
+func NewEventBroker(size int) *EventBroker {
+	return &EventBroker{
+		size: size,
+		seen: make(map[int64]bool),
+		subs: make(map[chan ObjectEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Close closes every subscription, and the ones taken from now on, so the streams end before the
+// service stops. Calling Close again does nothing.
+func (eb *EventBroker) Close() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	select {
+	case <-eb.done:
+		return
+	default:
+	}
+	close(eb.done)
+	for ch := range eb.subs {
+		delete(eb.subs, ch)
+		close(ch)
+	}
+}
+
+// Done returns a channel closed once the broker is closed. Subscriptions closed after it are
+// closed because of it, not for falling behind.
+func (eb *EventBroker) Done() <-chan struct{} {
+	return eb.done
+}
+
+// Notify buffers events and sends them to every subscription. Events already notified, as told
+// by their ID, are ignored, as are the events older than the buffer. Subscriptions too slow to
+// take the events are closed.
+func (eb *EventBroker) Notify(ctx context.Context, events []ObjectEvent) error {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for _, e := range events {
+		if e.ID <= eb.floor || eb.seen[e.ID] {
+			continue
+		}
+		eb.seen[e.ID] = true
+
+		eb.buffer = append(eb.buffer, e)
+		if n := len(eb.buffer) - eb.size; n > 0 {
+			for _, old := range eb.buffer[:n] {
+				delete(eb.seen, old.ID)
+				if old.ID > eb.floor {
+					eb.floor = old.ID
+				}
+			}
+			eb.buffer = eb.buffer[n:]
+		}
+
+		for ch := range eb.subs {
+			select {
+			case ch <- e:
+			default:
+				delete(eb.subs, ch)
+				close(ch)
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the buffered events notified after the one identified by lastID, or with a
+// greater ID once it left the buffer, and the channel receiving the events notified from now on.
+// A zero lastID replays nothing. The channel is closed when the subscription falls behind, or the
+// broker is closed, cancel must be called once done with it.
+func (eb *EventBroker) Subscribe(lastID int64) (replay []ObjectEvent, events <-chan ObjectEvent, cancel func()) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	if lastID > 0 {
+		from := -1
+		for i, e := range eb.buffer {
+			if e.ID == lastID {
+				from = i
+			}
+		}
+		for i, e := range eb.buffer {
+			if (from >= 0 && i > from) || (from < 0 && e.ID > lastID) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan ObjectEvent, brokerSubscriptionBuffer)
+	select {
+	case <-eb.done:
+		close(ch)
+		return replay, ch, func() {}
+	default:
+	}
+	eb.subs[ch] = struct{}{}
+	cancel = func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		if _, ok := eb.subs[ch]; ok {
+			delete(eb.subs, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBroker(t *testing.T) {
+	eb := NewEventBroker(2)
+	ctx := context.Background()
+
+	_, live, cancel := eb.Subscribe(0)
+	defer cancel()
+
+	assert.NoError(t, eb.Notify(ctx, []ObjectEvent{{ID: 1}, {ID: 2}, {ID: 3}}))
+	assert.NoError(t, eb.Notify(ctx, []ObjectEvent{{ID: 2}, {ID: 4}}), "events notified again are ignored")
+
+	var received []int64
+	for i := 0; i < 4; i++ {
+		received = append(received, (<-live).ID)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4}, received)
+
+	replay, _, cancelReplay := eb.Subscribe(2)
+	defer cancelReplay()
+	assert.Equal(t, []ObjectEvent{{ID: 3}, {ID: 4}}, replay)
+
+	replay, _, cancelOld := eb.Subscribe(1)
+	defer cancelOld()
+	assert.Equal(t, []ObjectEvent{{ID: 3}, {ID: 4}}, replay, "only the latest events are buffered")
+}
+
+func TestEventBroker_outOfOrder(t *testing.T) {
+	eb := NewEventBroker(3)
+	ctx := context.Background()
+
+	assert.NoError(t, eb.Notify(ctx, []ObjectEvent{{ID: 1}, {ID: 3}}))
+	assert.NoError(t, eb.Notify(ctx, []ObjectEvent{{ID: 2}, {ID: 3}}), "events committed late are kept")
+
+	replay, _, cancel := eb.Subscribe(3)
+	defer cancel()
+	assert.Equal(t, []ObjectEvent{{ID: 2}}, replay, "events are replayed in the order they were notified")
+
+	assert.NoError(t, eb.Notify(ctx, []ObjectEvent{{ID: 4}, {ID: 1}}))
+	replay, _, cancelAll := eb.Subscribe(1)
+	defer cancelAll()
+	assert.Equal(t, []ObjectEvent{{ID: 3}, {ID: 2}, {ID: 4}}, replay, "events older than the buffer are ignored")
+}
+
+func TestEventBroker_slowSubscription(t *testing.T) {
+	eb := NewEventBroker(10)
+	_, events, cancel := eb.Subscribe(0)
+	defer cancel()
+
+	for id := int64(1); id <= brokerSubscriptionBuffer+1; id++ {
+		assert.NoError(t, eb.Notify(context.Background(), []ObjectEvent{{ID: id}}))
+	}
+
+	n := 0
+	for range events {
+		n++
+	}
+	assert.Equal(t, brokerSubscriptionBuffer, n, "subscriptions falling behind are closed")
+}
+
+func TestEventBroker_Close(t *testing.T) {
+	eb := NewEventBroker(10)
+	_, events, cancel := eb.Subscribe(0)
+	defer cancel()
+
+	eb.Close()
+	eb.Close()
+	_, ok := <-events
+	assert.False(t, ok, "subscriptions are closed with the broker")
+	_, ok = <-eb.Done()
+	assert.False(t, ok)
+
+	_, events, cancelLate := eb.Subscribe(0)
+	defer cancelLate()
+	_, ok = <-events
+	assert.False(t, ok, "subscriptions taken once closed are closed")
+	assert.NoError(t, eb.Notify(context.Background(), []ObjectEvent{{ID: 1}}))
+}
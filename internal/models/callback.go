@@ -2,23 +2,24 @@ package models
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"go.opencensus.io/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
-)
 
-const (
-	serverObjectsEndpointURL = "/objects/"
+	"github.com/noelruault/go-callback-service/internal/objectservice"
 )
 
+// MaxListLimit is the maximum number of callbacks returned by a single List call, and the number
+// returned when the filter sets no limit.
+const MaxListLimit = 1000
+
 var (
 	CallbackSelfDeleteTime = 30 * time.Second
 )
@@ -30,36 +31,107 @@ type CallbackService interface {
 	// Any error that happens here, will be logged, won't be returned.
 	Upsert(context.Context, []Callback) error
 
-	// Status fetches the callback status from the callback-client service and fills the fetched Online status
-	Status(context.Context, int64) (Callback, error)
+	// Status fetches the status of the given callback from the object service it is routed to and
+	// fills the fetched Online status
+	Status(context.Context, Callback) (Callback, error)
 
 	CallbackDB
+
+	// LoadReporter reports the callbacks accepted by Upsert whose status is still being fetched.
+	LoadReporter
 }
 
 // CallbackDB defines how the service interacts with the database.
 type CallbackDB interface {
-	// Upsert a slice of Callbacks on the database. Will ignore any ID conflict.
-	// This function is configured to delete any callback object that is upserted 30 seconds after
-	// its upsertion if its timestamp is from 30 seconds ago.
+	// Upsert a slice of Callbacks on the database. Will ignore any ID conflict. Callbacks offline
+	// are not inserted, they only mark the stored ones offline.
+	// This function is configured to delete any callback object that is upserted after the
+	// retention window of its tenant, if its timestamp has not been updated in the meantime.
+	// Objects coming online, going offline and expiring are written to the outbox as ObjectEvents,
+	// in the transaction changing them.
 	Upsert(context.Context, []Callback) error
+
+	// Find returns the callback identified by id owned by tenant.
+	Find(ctx context.Context, tenant string, id int64) (Callback, error)
+
+	// List returns the callbacks owned by tenant matching filter, ordered by ID.
+	List(ctx context.Context, tenant string, filter CallbackFilter) ([]Callback, error)
 }
 
+// Callback is an object seen online. Objects are identified by their ID within a tenant.
 type Callback struct {
-	ID        int64 `gorm:"primary_key;type:bigserial" json:"id"`
-	Online    bool  `gorm:"not null" json:"online"`
-	Timestamp int64 `gorm:"type:bigint;not null" json:"timestamp"`
+	Tenant    string `gorm:"primary_key;type:varchar(64)" json:"tenant"`
+	ID        int64  `gorm:"primary_key;autoIncrement:false;type:bigint" json:"id"`
+	Online    bool   `gorm:"not null" json:"online"`
+	Timestamp int64  `gorm:"type:bigint;not null" json:"timestamp"`
+	// Payload is the object as last served by the object service, with every field it holds.
+	Payload JSONB `gorm:"type:jsonb" json:"payload,omitempty"`
+	// Upstream names the object service the object was looked up on. It is wide enough for the
+	// names of the services of the tenants, "tenant:" and a tenant ID.
+	Upstream string `gorm:"type:varchar(80);not null;default:''" json:"upstream,omitempty"`
+	// Source is the source named by the callback, used to route the object to its upstream. It is
+	// not stored.
+	Source string `gorm:"-" json:"-"`
+}
+
+// CallbackFilter narrows down the callbacks returned by a List call.
+type CallbackFilter struct {
+	// AfterID returns only the callbacks with a greater ID, to page through the results.
+	AfterID int64
+	// Limit is the maximum number of callbacks returned. It defaults to, and is capped at, 1000.
+	Limit int
+	// Payload returns only the callbacks whose payload holds every match.
+	Payload []PayloadMatch
+}
+
+// PageSize returns the number of callbacks a full page of the filter holds: its limit, capped at
+// MaxListLimit. Pages holding fewer callbacks are the last ones.
+func (f CallbackFilter) PageSize() int {
+	if f.Limit <= 0 || f.Limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return f.Limit
+}
+
+// tenantOf returns the tenant owning c.
+func tenantOf(c Callback) string {
+	if c.Tenant == "" {
+		return DefaultTenant
+	}
+	return c.Tenant
 }
 
 type callbackService struct {
 	CallbackService
 }
 
-func NewCallbackService(db *gorm.DB, callbackServiceURL string, log *log.Logger) CallbackService {
+// NewCallbackService returns the CallbackService of the objects of every tenant, looked up as
+// defined by upstream. The changes of the stored objects are written to the outbox, published by
+// an OutboxRelay.
+func NewCallbackService(db *gorm.DB, tenants TenantService, upstream Upstream, log *log.Logger) CallbackService {
+	if upstream.Client == nil {
+		upstream.Client = objectservice.New(objectservice.Config{})
+	}
+	if upstream.TenantClient == nil {
+		upstream.TenantClient = objectservice.New(objectservice.Config{})
+	}
+	upstream.Limiters.register(DefaultUpstream, upstream.Limiter)
+
 	return &callbackService{
 		CallbackService: &callbackValidator{
-			CallbackDB: &callbackGorm{db},
-			serviceURL: callbackServiceURL,
-			log:        log,
+			CallbackDB:   &callbackGorm{db: db, tenants: tenants, log: log},
+			tenants:      tenants,
+			client:       upstream.Client,
+			tenantClient: upstream.TenantClient,
+			limiter:      upstream.Limiter,
+			hedger:       newHedger(upstream.Hedge),
+			hedge:        upstream.Hedge,
+			batchSize:    upstream.BatchSize,
+			serviceURL:   upstream.URL,
+			services:     newUpstreams(upstream),
+			routes:       upstream.Routes,
+			limiters:     upstream.Limiters,
+			log:          log,
 		},
 	}
 }
@@ -67,9 +139,30 @@ func NewCallbackService(db *gorm.DB, callbackServiceURL string, log *log.Logger)
 type callbackValidator struct {
 	CallbackDB
 
-	serviceURL string
-	log        *log.Logger
-	ctx        context.Context
+	tenants      TenantService
+	client       objectservice.Client
+	tenantClient objectservice.Client
+	limiter      *ConcurrencyLimiter
+	hedger       *hedger
+	hedge        HedgeConfig
+	batchSize    int
+	serviceURL   string
+	services     map[string]upstream
+	routes       []UpstreamRoute
+	limiters     *LimiterRegistry
+	log          *log.Logger
+	ctx          context.Context
+
+	// tenantUpstreams are the object services of the tenants, by tenant.
+	mu              sync.Mutex
+	tenantUpstreams map[string]upstream
+
+	load loadTracker
+}
+
+// Load returns the callbacks waiting for their status to be fetched and stored.
+func (cv *callbackValidator) Load() Load {
+	return cv.load.Load()
 }
 
 // setTimestamp sets timestamp to now. It does not return any errors.
@@ -77,45 +170,92 @@ func (cv *callbackValidator) setTimestamp(c *Callback) {
 	c.Timestamp = time.Now().Unix()
 }
 
-// Upsert checks if the server is reachable, if so, invokes multiple goroutines to check every
-// callback of the given cs slice by calling the Status method.
+// Upsert checks if the object services the callbacks are routed to are healthy, if so, invokes
+// multiple goroutines to fetch the status of every callback of the given cs slice, in batches when
+// the object services serve them, and stores the statuses fetched.
 func (cv *callbackValidator) Upsert(ctx context.Context, cs []Callback) error {
 	ctx, span := trace.StartSpan(ctx, "models.callbackValidator.Upsert")
 	defer span.End()
 
-	errChan := make(chan error)
+	// The channel is buffered so the goroutines never block on errors that are not read.
+	errChan := make(chan error, len(cs))
 	done := make(chan bool)
 	var wg sync.WaitGroup
 
-	// Check if the client is reachable
-	_, err := http.Get(cv.serviceURL)
-	if err != nil {
-		return ErrServerNotReachable
+	// Group the callbacks by tenant and upstream, checking the upstreams are healthy.
+	type group struct {
+		tenant   string
+		upstream string
 	}
-
+	batches := make(map[group][]Callback)
+	upstreams := make(map[group]upstream)
 	for _, c := range cs {
-		wg.Add(1)
-		go func(c Callback) { // The c argument is used to capture the loop variable at the moment is used.
+		up, err := cv.route(ctx, c)
+		if err != nil {
+			return err
+		}
+		g := group{tenantOf(c), up.name}
+		if _, checked := upstreams[g]; !checked {
+			if !up.client.Healthy(up.url) {
+				return ErrServerNotReachable
+			}
+			upstreams[g] = up
+		}
+		batches[g] = append(batches[g], c)
+	}
 
-			// Use client to fetch callback status
-			callback, err := cv.Status(ctx, c.ID)
-			if err != nil {
-				errChan <- err
+	// The callbacks are processed after the request is answered, so the goroutines must not be
+	// cancelled along with it.
+	bctx := detach(ctx)
+
+	size := cv.batchSize
+	if size < 2 {
+		size = 1
+	}
+	for g, list := range batches {
+		up := upstreams[g]
+		for len(list) > 0 {
+			n := size
+			if n > len(list) {
+				n = len(list)
 			}
+			batch := list[:n:n]
+			list = list[n:]
 
-			if callback.Online {
-				// Run callback validators
-				cv.setTimestamp(&callback)
+			processed := make([]func(), len(batch))
+			for i := range batch {
+				processed[i] = cv.load.start()
+			}
 
-				// Upsert callback on the database
-				err = cv.CallbackDB.Upsert(ctx, []Callback{callback})
-				if err != nil {
+			wg.Add(1)
+			go func(up upstream, batch []Callback) { // The arguments capture the loop variables at the moment they are used.
+				defer wg.Done()
+				defer func() {
+					for _, p := range processed {
+						p()
+					}
+				}()
+
+				// Use client to fetch callback status
+				fetched, errs := cv.statuses(bctx, up, batch)
+				for _, err := range errs {
 					errChan <- err
 				}
-			}
+				if len(fetched) == 0 {
+					return
+				}
 
-			wg.Done()
-		}(c)
+				// Run callback validators
+				for i := range fetched {
+					cv.setTimestamp(&fetched[i])
+				}
+
+				// Upsert callbacks on the database
+				if err := cv.CallbackDB.Upsert(bctx, fetched); err != nil {
+					errChan <- err
+				}
+			}(up, batch)
+		}
 	}
 
 	// goroutine to wait until WaitGroup is done
@@ -137,63 +277,440 @@ func (cv *callbackValidator) Upsert(ctx context.Context, cs []Callback) error {
 	return nil
 }
 
-// Status queries the client server returns if a specific Callback is online or not.
-func (cv *callbackValidator) Status(ctx context.Context, id int64) (Callback, error) {
-	_, span := trace.StartSpan(ctx, "models.callbackValidator.Status")
+// statuses fetches the status of the callbacks of cs, all owned by the same tenant, from the
+// object service up and returns the callbacks whose status was fetched. Several callbacks are
+// fetched with a single batch request, falling back to a lookup per callback if the service
+// doesn't serve batches.
+//
+// Batch requests are bounded by the concurrency limiter, but not hedged.
+func (cv *callbackValidator) statuses(ctx context.Context, up upstream, cs []Callback) ([]Callback, []error) {
+	if len(cs) > 1 {
+		fetched, err := cv.batchStatus(ctx, up, cs)
+		if !errors.Is(err, objectservice.ErrBatchUnsupported) {
+			if err != nil {
+				return nil, []error{err}
+			}
+			return fetched, nil
+		}
+	}
+
+	type result struct {
+		c   Callback
+		err error
+	}
+	results := make(chan result, len(cs))
+	for _, c := range cs {
+		go func(c Callback) {
+			nc, err := cv.status(ctx, up, c)
+			results <- result{nc, err}
+		}(c)
+	}
+
+	var (
+		fetched []Callback
+		errs    []error
+	)
+	for range cs {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		fetched = append(fetched, r.c)
+	}
+	return fetched, errs
+}
+
+// batchStatus fetches the status of cs with a single request and returns them. Objects left out
+// of the answer are unknown to the object service, so offline.
+func (cv *callbackValidator) batchStatus(ctx context.Context, up upstream, cs []Callback) ([]Callback, error) {
+	ctx, span := trace.StartSpan(ctx, "models.callbackValidator.batchStatus")
 	defer span.End()
 
-	buildURL := fmt.Sprintf("%s%s%s", cv.serviceURL, serverObjectsEndpointURL, strconv.FormatInt(id, 10))
-	req, err := http.NewRequest(http.MethodGet, buildURL, nil)
+	ids := make([]int64, len(cs))
+	asked := make(map[int64]bool, len(cs))
+	for i, c := range cs {
+		ids[i] = c.ID
+		asked[c.ID] = true
+	}
+
+	done, err := up.limiter.Acquire(ctx)
 	if err != nil {
-		return Callback{}, fmt.Errorf("models: building request %w", err)
+		return nil, fmt.Errorf("models: waiting for the object service %w", err)
+	}
+	objects, err := up.client.Objects(ctx, up.url, ids)
+	done(requestOutcome(ctx, err))
+
+	switch {
+	case errors.Is(err, objectservice.ErrBatchUnsupported):
+		return nil, err
+	case errors.Is(err, objectservice.ErrInvalidResponse):
+		return nil, ErrInvalidJSONInput
+	case err != nil:
+		return nil, fmt.Errorf("models: fetching object statuses %w", err)
 	}
 
-	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	fetched := make([]Callback, 0, len(cs))
+	for _, o := range objects {
+		// Objects that were not asked for, or answered twice, are ignored.
+		if asked[o.ID] {
+			asked[o.ID] = false
+			fetched = append(fetched, Callback{Tenant: tenantOf(cs[0]), ID: o.ID, Online: o.Online, Payload: JSONB(o.Raw), Upstream: up.name})
+		}
+	}
+	for _, c := range cs {
+		if asked[c.ID] {
+			fetched = append(fetched, Callback{Tenant: tenantOf(c), ID: c.ID, Upstream: up.name})
+		}
 	}
-	resp, err := client.Do(req)
+	return fetched, nil
+}
+
+// Status queries the object service the callback is routed to and returns if a specific Callback
+// is online or not.
+func (cv *callbackValidator) Status(ctx context.Context, c Callback) (Callback, error) {
+	ctx, span := trace.StartSpan(ctx, "models.callbackValidator.Status")
+	defer span.End()
+
+	up, err := cv.route(ctx, c)
 	if err != nil {
-		return Callback{}, fmt.Errorf("models: sending http request %w", err)
+		return Callback{}, err
 	}
-	defer resp.Body.Close()
+	return cv.status(ctx, up, c)
+}
+
+// status looks up the status of c on the object service up.
+//
+// When hedging is enabled, a lookup still running after the configured percentile of the recent
+// lookup latencies is sent again, and the first successful answer is kept.
+func (cv *callbackValidator) status(ctx context.Context, up upstream, c Callback) (Callback, error) {
+	ctx, span := trace.StartSpan(ctx, "models.callbackValidator.status")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("upstream", up.name))
 
-	var nc Callback
-	if err := json.NewDecoder(resp.Body).Decode(&nc); err != nil {
+	// The request still running once an answer is kept is cancelled.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type lookup struct {
+		c      Callback
+		err    error
+		hedged bool
+	}
+	results := make(chan lookup, 2)
+	send := func(hedged bool) {
+		nc, err := cv.lookup(ctx, up, c)
+		results <- lookup{nc, err, hedged}
+	}
+	go send(false)
+
+	delay, ok := up.hedger.delay()
+	if !ok {
+		r := <-results
+		return r.c, r.err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.c, r.err
+	case <-timer.C:
+	}
+
+	if !up.hedger.take() {
+		r := <-results
+		return r.c, r.err
+	}
+	span.AddAttributes(trace.BoolAttribute("hedged", true))
+	go send(true)
+
+	// Keep the first successful answer, or the last error if both failed.
+	r := <-results
+	if r.err != nil {
+		r = <-results
+	}
+	switch {
+	case r.err != nil:
+	case r.hedged:
+		hedges.Add("won", 1)
+	default:
+		hedges.Add("lost", 1)
+	}
+	return r.c, r.err
+}
+
+// lookup requests the status of c from the object service up.
+func (cv *callbackValidator) lookup(ctx context.Context, up upstream, c Callback) (Callback, error) {
+	done, err := up.limiter.Acquire(ctx)
+	if err != nil {
+		return Callback{}, fmt.Errorf("models: waiting for the object service %w", err)
+	}
+
+	began := time.Now()
+	o, err := up.client.Object(ctx, up.url, c.ID)
+	done(requestOutcome(ctx, err))
+
+	switch {
+	case errors.Is(err, objectservice.ErrObjectGone):
+		// Objects the upstream no longer knows of are offline, so they are expired like any other.
+		up.hedger.record(time.Since(began))
+		return Callback{Tenant: tenantOf(c), ID: c.ID, Upstream: up.name}, nil
+	case errors.Is(err, objectservice.ErrInvalidResponse):
 		return Callback{}, ErrInvalidJSONInput
+	case err != nil:
+		return Callback{}, fmt.Errorf("models: fetching object status %w", err)
 	}
+	up.hedger.record(time.Since(began))
 
-	return nc, nil
+	return Callback{Tenant: tenantOf(c), ID: o.ID, Online: o.Online, Payload: JSONB(o.Raw), Upstream: up.name}, nil
+}
+
+// requestOutcome returns the outcome of a request to the object service, for the concurrency
+// limiter. Requests cancelled because another one answered first say nothing of the upstream.
+func requestOutcome(ctx context.Context, err error) RequestOutcome {
+	switch {
+	case ctx.Err() != nil:
+		return RequestCancelled
+	case objectservice.Retryable(err):
+		return RequestFailed
+	}
+	return RequestSucceeded
 }
 
 type callbackGorm struct {
-	db *gorm.DB
+	db      *gorm.DB
+	tenants TenantService
+	log     *log.Logger
 }
 
-// Inserts one or many Callback(s) into the database. Avoiding any ID conflict.
+// retention returns the time the callbacks of tenant are kept after they were last seen.
+func (cg *callbackGorm) retention(ctx context.Context, tenant string) time.Duration {
+	if cg.tenants == nil {
+		return CallbackSelfDeleteTime
+	}
+
+	t, err := cg.tenants.Get(ctx, tenant)
+	if err != nil || t.Retention <= 0 {
+		return CallbackSelfDeleteTime
+	}
+	return t.Retention
+}
+
+// Inserts one or many Callback(s) into the database. Avoiding any ID conflict. The stored rows
+// are locked first, so concurrent upserts see every transition once, and write the events of an
+// object to the outbox in order.
 func (cg *callbackGorm) Upsert(ctx context.Context, cs []Callback) error {
 	ctx, span := trace.StartSpan(ctx, "callback.Database.Upsert")
 	defer span.End()
-	cg.db.WithContext(ctx)
 
-	err := cg.db.Clauses(clause.OnConflict{
-		UpdateAll: true, // Update everything on ID conflict.
-	}).Create(&cs).Error
+	// Slice containing the IDs of the Callback objects just created, by tenant.
+	bulkDeleteIDs := make(map[string][]int64)
+	var online []Callback
+	for i := range cs {
+		cs[i].Tenant = tenantOf(cs[i])
+		if cs[i].Online {
+			online = append(online, cs[i])
+			bulkDeleteIDs[cs[i].Tenant] = append(bulkDeleteIDs[cs[i].Tenant], cs[i].ID)
+		}
+	}
+
+	err := cg.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockCallbacks(tx, cs)
+		if err != nil {
+			return err
+		}
+
+		// New objects are inserted first. The ones inserted meanwhile by another transaction,
+		// which the insert waits for, are not new anymore: they are locked as they are now, so
+		// their transition is derived from the stored row and raised once.
+		var missing []Callback
+		for _, c := range online {
+			if _, found := before[callbackKey{c.Tenant, c.ID}]; !found {
+				missing = append(missing, c)
+			}
+		}
+		inserted, err := insertCallbacks(tx, missing)
+		if err != nil {
+			return err
+		}
+		var raced, updated []Callback
+		for _, c := range missing {
+			if !inserted[callbackKey{c.Tenant, c.ID}] {
+				raced = append(raced, c)
+			}
+		}
+		stored, err := lockCallbacks(tx, raced)
+		if err != nil {
+			return err
+		}
+		for key, c := range stored {
+			before[key] = c
+		}
+		for _, c := range online {
+			if !inserted[callbackKey{c.Tenant, c.ID}] {
+				updated = append(updated, c)
+			}
+		}
 
+		if len(updated) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				UpdateAll: true, // Update everything on ID conflict.
+			}).Create(&updated).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		events := transitions(before, cs, time.Now())
+		for _, e := range events {
+			if e.Type != EventObjectOffline {
+				continue
+			}
+			// Objects going offline keep their timestamp, so they expire as planned when they
+			// were last seen online.
+			err := tx.Model(&Callback{}).
+				Where("tenant = ? AND id = ?", e.Tenant, e.Object.ID).
+				Updates(map[string]interface{}{"online": false, "payload": e.Object.Payload, "upstream": e.Object.Upstream}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return writeOutbox(tx, events)
+	})
 	if err != nil {
 		return fmt.Errorf("models: couldn't update callback %w", err)
 	}
 
-	// Slice containing the IDs of the Callback objects just created.
-	var bulkDeleteIDs []int64
+	for tenant, ids := range bulkDeleteIDs {
+		tenant, ids := tenant, ids
+		retention := cg.retention(ctx, tenant)
+
+		// Function that will run exactly the retention window of the tenant after the Callback
+		// objects were created and will target them. Deleting them if their timestamp has not
+		// been updated.
+		time.AfterFunc(retention, func() {
+			cg.expire(tenant, ids, retention)
+		})
+	}
+	return nil
+}
+
+// insertCallbacks inserts the callbacks of cs that are not stored, and returns the ones it
+// inserted. Callbacks inserted by a concurrent transaction are left as they are.
+func insertCallbacks(tx *gorm.DB, cs []Callback) (map[callbackKey]bool, error) {
+	inserted := make(map[callbackKey]bool, len(cs))
+	if len(cs) == 0 {
+		return inserted, nil
+	}
+
+	rows := make([]string, len(cs))
+	args := make([]interface{}, 0, 6*len(cs))
+	for i, c := range cs {
+		rows[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, c.Tenant, c.ID, c.Online, c.Timestamp, c.Payload, c.Upstream)
+	}
+	var keys []Callback
+	err := tx.Raw("INSERT INTO callbacks (tenant, id, online, timestamp, payload, upstream) VALUES "+
+		strings.Join(rows, ", ")+" ON CONFLICT DO NOTHING RETURNING tenant, id", args...).
+		Scan(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range keys {
+		inserted[callbackKey{c.Tenant, c.ID}] = true
+	}
+	return inserted, nil
+}
+
+// lockCallbacks locks the stored callbacks of cs until the end of the transaction tx, and returns
+// them.
+func lockCallbacks(tx *gorm.DB, cs []Callback) (map[callbackKey]Callback, error) {
+	ids := make(map[string][]int64)
 	for _, c := range cs {
-		bulkDeleteIDs = append(bulkDeleteIDs, c.ID)
+		ids[c.Tenant] = append(ids[c.Tenant], c.ID)
 	}
 
-	// Function that will run exactly X seconds after the Callback objects were created and will target them.
-	// Deleting them if their timestamp has not been updated.
-	time.AfterFunc(CallbackSelfDeleteTime, func() {
-		cg.db.Where("timestamp <= ?", time.Now().Add(-CallbackSelfDeleteTime).Unix()).Delete(Callback{}, bulkDeleteIDs)
+	stored := make(map[callbackKey]Callback, len(cs))
+	for tenant, list := range ids {
+		var rows []Callback
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("tenant = ? AND id IN ?", tenant, list).
+			Order("id").
+			Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range rows {
+			stored[callbackKey{c.Tenant, c.ID}] = c
+		}
+	}
+	return stored, nil
+}
+
+// expire deletes the callbacks of tenant identified by ids that were not seen online for
+// retention, and writes their expiry to the outbox.
+func (cg *callbackGorm) expire(tenant string, ids []int64, retention time.Duration) {
+	err := cg.db.Transaction(func(tx *gorm.DB) error {
+		var expired []Callback
+		err := tx.Raw("DELETE FROM callbacks WHERE tenant = ? AND id IN ? AND timestamp <= ? RETURNING *",
+			tenant, ids, time.Now().Add(-retention).Unix()).
+			Scan(&expired).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		events := make([]ObjectEvent, len(expired))
+		for i, c := range expired {
+			events[i] = ObjectEvent{Type: EventObjectExpired, Time: now, Tenant: c.Tenant, Object: c}
+		}
+		return writeOutbox(tx, events)
 	})
+	if err != nil {
+		cg.log.Printf("expire_error: %v", err)
+	}
+}
 
-	return nil
+// Find returns the callback identified by tenant and id.
+func (cg *callbackGorm) Find(ctx context.Context, tenant string, id int64) (Callback, error) {
+	ctx, span := trace.StartSpan(ctx, "callback.Database.Find")
+	defer span.End()
+
+	var c Callback
+	err := cg.db.WithContext(ctx).Where("tenant = ? AND id = ?", tenant, id).First(&c).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return Callback{}, ErrNotFound
+	case err != nil:
+		return Callback{}, fmt.Errorf("models: couldn't find callback %w", err)
+	}
+	return c, nil
+}
+
+// List returns a page of the callbacks of tenant.
+func (cg *callbackGorm) List(ctx context.Context, tenant string, filter CallbackFilter) ([]Callback, error) {
+	ctx, span := trace.StartSpan(ctx, "callback.Database.List")
+	defer span.End()
+
+	filter.Limit = filter.PageSize()
+
+	q := cg.db.WithContext(ctx).Where("tenant = ? AND id > ?", tenant, filter.AfterID)
+	for _, pm := range filter.Payload {
+		if len(pm.Path) == 0 {
+			return nil, ErrInvalidPayloadPath
+		}
+		cond, args := pm.where()
+		q = q.Where(cond, args...)
+	}
+
+	cs := []Callback{}
+	err := q.
+		Order("id").
+		Limit(filter.Limit).
+		Find(&cs).Error
+	if err != nil {
+		return nil, fmt.Errorf("models: couldn't list callbacks %w", err)
+	}
+	return cs, nil
 }
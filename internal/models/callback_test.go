@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,7 +10,7 @@ import (
 )
 
 func TestCallbackGorm_Upsert(t *testing.T) {
-	cdb := callbackGorm{NewTestDatabase(t)}
+	cdb := callbackGorm{db: NewTestDatabase(t)}
 	CallbackSelfDeleteTime = 800 * time.Millisecond
 	defer func() {
 		CallbackSelfDeleteTime = 30 * time.Second
@@ -26,26 +27,26 @@ func TestCallbackGorm_Upsert(t *testing.T) {
 		{
 			"ok",
 			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
-			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
+			[]Callback{{Tenant: DefaultTenant, ID: 123, Online: true, Timestamp: 1111111}},
 			nil,
 			nil,
 		},
 		{
 			"IDisDuplicatedValue",
 			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
-			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
+			[]Callback{{Tenant: DefaultTenant, ID: 123, Online: true, Timestamp: 1111111}},
 			nil,
 			func(t *testing.T) {
-				cdb.db.Create(&Callback{ID: 123, Online: true, Timestamp: 1111111})
+				cdb.db.Create(&Callback{Tenant: DefaultTenant, ID: 123, Online: true, Timestamp: 1111111})
 			},
 		},
 		{
 			"updatedWhenConflict",
 			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
-			[]Callback{{ID: 123, Online: true, Timestamp: 1111111}},
+			[]Callback{{Tenant: DefaultTenant, ID: 123, Online: true, Timestamp: 1111111}},
 			nil,
 			func(t *testing.T) {
-				cdb.db.Create(&Callback{ID: 123, Online: false, Timestamp: 0})
+				cdb.db.Create(&Callback{Tenant: DefaultTenant, ID: 123, Online: false, Timestamp: 0})
 			},
 		},
 	}
@@ -74,3 +75,72 @@ func TestCallbackGorm_Upsert(t *testing.T) {
 		})
 	}
 }
+
+func TestCallbackGorm_List_payload(t *testing.T) {
+	cdb := callbackGorm{db: NewTestDatabase(t)}
+	defer CleanupTestDatabase(cdb.db)
+
+	cdb.db.Create(&[]Callback{
+		{Tenant: DefaultTenant, ID: 1, Online: true, Payload: JSONB(`{"id":1,"location":{"region":"eu"},"rack":7}`)},
+		{Tenant: DefaultTenant, ID: 2, Online: true, Payload: JSONB(`{"id":2,"location":{"region":"us"},"rack":7}`)},
+		{Tenant: DefaultTenant, ID: 3, Online: true},
+	})
+
+	cs, err := cdb.List(context.Background(), DefaultTenant, CallbackFilter{Payload: []PayloadMatch{
+		{Path: []string{"location", "region"}, Value: "eu"},
+		{Path: []string{"rack"}, Value: "7"},
+	}})
+	assert.NoError(t, err)
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, int64(1), cs[0].ID)
+		assert.JSONEq(t, `{"id":1,"location":{"region":"eu"},"rack":7}`, string(cs[0].Payload))
+	}
+
+	_, err = cdb.List(context.Background(), DefaultTenant, CallbackFilter{Payload: []PayloadMatch{{Value: "eu"}}})
+	assert.Equal(t, ErrInvalidPayloadPath, err)
+}
+
+func TestCallbackGorm_Upsert_outbox(t *testing.T) {
+	cdb := callbackGorm{db: NewTestDatabase(t)}
+	CallbackSelfDeleteTime = 800 * time.Millisecond
+	defer func() {
+		CallbackSelfDeleteTime = 30 * time.Second
+		CleanupTestDatabase(cdb.db)
+	}()
+	ctx := context.Background()
+
+	assert.NoError(t, cdb.Upsert(ctx, []Callback{{ID: 1, Online: true, Timestamp: 10}}))
+	assert.NoError(t, cdb.Upsert(ctx, []Callback{{ID: 1, Online: true, Timestamp: 20}}))
+	assert.NoError(t, cdb.Upsert(ctx, []Callback{{ID: 1, Online: false, Timestamp: 30}, {ID: 2, Online: false}}))
+
+	var stored []Callback
+	cdb.db.Find(&stored)
+	assert.Equal(t, []Callback{{Tenant: DefaultTenant, ID: 1, Online: false, Timestamp: 20}}, stored,
+		"offline objects keep their timestamp, unknown ones are not stored")
+
+	time.Sleep(1500 * time.Millisecond)
+	var types []string
+	cdb.db.Model(&OutboxEvent{}).Order("id").Pluck("type", &types)
+	assert.Equal(t, []string{EventObjectOnline, EventObjectOffline, EventObjectExpired}, types)
+}
+
+func TestCallbackGorm_Upsert_concurrent(t *testing.T) {
+	cdb := callbackGorm{db: NewTestDatabase(t)}
+	defer CleanupTestDatabase(cdb.db)
+	ctx := context.Background()
+
+	// Replicas storing the same new object at once raise a single event.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cdb.Upsert(ctx, []Callback{{ID: 1, Online: true, Timestamp: 10}}))
+		}()
+	}
+	wg.Wait()
+
+	var types []string
+	cdb.db.Model(&OutboxEvent{}).Order("id").Pluck("type", &types)
+	assert.Equal(t, []string{EventObjectOnline}, types)
+}
@@ -3,9 +3,17 @@ package models
 // These errors are returned by the services and can be used to provide error codes to the
 // API results.
 const (
-	ErrNotFound           ModelError = "models: not_found, resource not found"
-	ErrInvalidJSONInput   ModelError = "models: invalid_json, provided input cannot be parsed"
-	ErrServerNotReachable ModelError = "models: server_connection_error, provided server can't be reached"
+	ErrNotFound            ModelError = "models: not_found, resource not found"
+	ErrInvalidJSONInput    ModelError = "models: invalid_json, provided input cannot be parsed"
+	ErrServerNotReachable  ModelError = "models: server_connection_error, provided server can't be reached"
+	ErrInvalidAPIKey       ModelError = "models: invalid_api_key, provided api key is not valid"
+	ErrInvalidClient       ModelError = "models: invalid_client, client name can't be empty"
+	ErrInvalidTenant       ModelError = "models: invalid_tenant, tenant id must hold up to 64 lowercase letters, digits, dashes or underscores"
+	ErrInvalidPayloadPath  ModelError = "models: invalid_payload_path, payload paths must list non empty keys separated by dots"
+	ErrInvalidWebhookURL   ModelError = "models: invalid_webhook_url, webhook url must be an absolute http or https url"
+	ErrForbiddenWebhookURL ModelError = "models: forbidden_webhook_url, webhook url must only resolve to public addresses"
+	ErrInvalidEventType    ModelError = "models: invalid_event_type, event types must be object.online, object.offline or object.expired"
+	ErrIdempotencyKeyLost  ModelError = "models: idempotency_key_lost, the lease of the request expired and another request took its idempotency key over"
 )
 
 // CodeError is an error that returns a string code that can be presented to the API user.
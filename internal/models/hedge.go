@@ -0,0 +1,106 @@
+package models
+
+import (
+	"expvar"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeMinSamples is the number of lookups observed before any lookup is hedged, so the delay
+	// is not computed from a handful of samples.
+	hedgeMinSamples = 20
+
+	// hedgeSamples is the number of recent lookup latencies the delay is computed from.
+	hedgeSamples = 500
+
+	// hedgeMaxBudget is the number of hedges that can be sent in a burst.
+	hedgeMaxBudget = 10
+)
+
+// hedges counts the outcome of the hedged lookups.
+var hedges = expvar.NewMap("hedged_lookups")
+
+// HedgeConfig defines when a lookup to the object service is hedged with a second request.
+type HedgeConfig struct {
+	// Percentile of the recent lookup latencies after which a lookup is hedged, between 0 and 1.
+	// Zero disables hedging.
+	Percentile float64
+	// MaxRatio caps the hedges sent to a ratio of the lookups.
+	MaxRatio float64
+}
+
+// Enabled reports whether lookups can be hedged.
+func (hc HedgeConfig) Enabled() bool {
+	return hc.Percentile > 0 && hc.Percentile < 1 && hc.MaxRatio > 0
+}
+
+// hedger decides when the lookups are hedged. Every lookup earns MaxRatio hedges, so hedging
+// can't multiply the load of an upstream that is slow for every request.
+type hedger struct {
+	cfg HedgeConfig
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	budget  float64
+}
+
+func newHedger(cfg HedgeConfig) *hedger {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &hedger{cfg: cfg}
+}
+
+// delay returns the time after which a lookup starting now is hedged, and whether it can be.
+func (h *hedger) delay() (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.budget = math.Min(hedgeMaxBudget, h.budget+h.cfg.MaxRatio)
+	if len(h.samples) < hedgeMinSamples {
+		return 0, false
+	}
+
+	sorted := append([]time.Duration(nil), h.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, h.cfg.Percentile), true
+}
+
+// take spends a hedge from the budget, reporting whether there was one.
+func (h *hedger) take() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.budget < 1 {
+		hedges.Add("skipped_budget", 1)
+		return false
+	}
+	h.budget--
+	hedges.Add("sent", 1)
+	return true
+}
+
+// record adds the latency of a lookup that completed.
+func (h *hedger) record(latency time.Duration) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, latency)
+		return
+	}
+	h.samples[h.next] = latency
+	h.next = (h.next + 1) % hedgeSamples
+}
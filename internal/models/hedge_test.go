@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/objectservice"
+)
+
+// warmHedger returns a hedger that already observed hedgeMinSamples lookups taking latency.
+func warmHedger(cfg HedgeConfig, latency time.Duration) *hedger {
+	h := newHedger(cfg)
+	for i := 0; i < hedgeMinSamples; i++ {
+		h.record(latency)
+	}
+	return h
+}
+
+func TestHedger(t *testing.T) {
+	assert.Nil(t, newHedger(HedgeConfig{}), "hedging is disabled by default")
+
+	h := newHedger(HedgeConfig{Percentile: 0.9, MaxRatio: 0.5})
+	_, ok := h.delay()
+	assert.False(t, ok, "lookups are not hedged before enough samples are seen")
+
+	for i := 1; i <= hedgeMinSamples; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	delay, ok := h.delay()
+	assert.True(t, ok)
+	assert.Equal(t, 18*time.Millisecond, delay)
+
+	// Every lookup earns half a hedge.
+	assert.True(t, h.take())
+	assert.False(t, h.take())
+	h.delay()
+	assert.False(t, h.take())
+	h.delay()
+	assert.True(t, h.take())
+}
+
+func TestCallbackValidator_Status_hedged(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 {
+			// The first lookup is stuck until it is cancelled.
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"online":true}`)
+	}))
+	defer srv.Close()
+
+	limiter := NewConcurrencyLimiter(ConcurrencyLimitConfig{Initial: 4, Min: 1, Max: 8})
+	cv := &callbackValidator{
+		client:     objectservice.New(objectservice.Config{}),
+		limiter:    limiter,
+		serviceURL: srv.URL,
+		hedger:     warmHedger(HedgeConfig{Percentile: 0.5, MaxRatio: 1}, time.Millisecond),
+	}
+
+	c, err := cv.Status(context.Background(), Callback{ID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, Callback{
+		Tenant:   DefaultTenant,
+		ID:       1,
+		Online:   true,
+		Payload:  JSONB(`{"id":1,"online":true}`),
+		Upstream: DefaultUpstream,
+	}, c)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// The cancelled lookup is not reported to the limiter.
+	assert.Eventually(t, func() bool { return limiter.Stats().InFlight == 0 }, time.Second, time.Millisecond)
+	stats := limiter.Stats()
+	assert.Equal(t, int64(1), stats.Requests)
+	assert.Zero(t, stats.Failures)
+}
+
+func TestNewUpstreams_hedgers(t *testing.T) {
+	services := newUpstreams(Upstream{
+		Hedge: HedgeConfig{Percentile: 0.5, MaxRatio: 1},
+		Services: []UpstreamService{
+			{Name: "catalog", Client: &testObjectClient{}},
+			{Name: "legacy", Client: &testObjectClient{}},
+		},
+	})
+
+	// Latencies of one upstream don't move the delay of the others.
+	catalog, legacy := services["catalog"].hedger, services["legacy"].hedger
+	for i := 0; i < hedgeMinSamples; i++ {
+		catalog.record(time.Second)
+	}
+	_, ok := legacy.delay()
+	assert.False(t, ok)
+}
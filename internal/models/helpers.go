@@ -1,14 +1,67 @@
 package models
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// detach returns a context carrying the trace span of ctx, but none of its deadline or
+// cancellation.
+func detach(ctx context.Context) context.Context {
+	return trace.NewContext(context.Background(), trace.FromContext(ctx))
+}
+
+// worker runs the background loops of a service until the service is closed.
+type worker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorker() *worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &worker{ctx: ctx, cancel: cancel}
+}
+
+// start runs loop in the background, with a context cancelled once the service is closed.
+func (w *worker) start(loop func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		loop(w.ctx)
+	}()
+}
+
+// Close stops the background work of the service, and waits for it to return. The work in
+// progress is cancelled.
+func (w *worker) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// every calls fn every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
 func NewTestDatabase(t *testing.T) *gorm.DB {
 	var cfg struct {
 		Database struct {
@@ -42,7 +95,7 @@ func NewTestDatabase(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	assert.NoError(t, err, "opening database connection through dsl")
 
-	db.AutoMigrate(Callback{})
+	assert.NoError(t, Migrate(db), "migrating the database")
 
 	return db
 }
@@ -50,5 +103,5 @@ func NewTestDatabase(t *testing.T) *gorm.DB {
 func CleanupTestDatabase(gdb *gorm.DB) {
 	gdb.Exec("DROP SCHEMA public CASCADE")
 	gdb.Exec("CREATE SCHEMA public")
-	gdb.Migrator().CreateTable(&Callback{})
+	Migrate(gdb)
 }
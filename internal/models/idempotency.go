@@ -0,0 +1,220 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyService defines how the responses sent to requests carrying an idempotency key are
+// kept, so a request retried by a producer is answered without being processed again.
+type IdempotencyService interface {
+	// Claim reserves key for a request whose content is summarized by requestHash. It returns
+	// the claim made and true when the key was free, or its record expired, and the request must
+	// be processed. Otherwise the record stored for the key is returned.
+	Claim(ctx context.Context, key, requestHash string) (IdempotencyKey, bool, error)
+
+	// Complete stores the response sent to the request that made claim. It returns
+	// ErrIdempotencyKeyLost if the claim expired and another request took the key over.
+	Complete(ctx context.Context, claim IdempotencyKey, statusCode int, contentType string, body []byte) error
+
+	// Release frees the key of claim, so the request can be processed again. It returns
+	// ErrIdempotencyKeyLost if the claim expired and another request took the key over.
+	Release(ctx context.Context, claim IdempotencyKey) error
+
+	// WithWindow returns a service sharing the records of this one, and their purge, which keeps
+	// the responses it completes for window.
+	WithWindow(window time.Duration) IdempotencyService
+
+	// Close stops purging the expired records.
+	Close()
+}
+
+// IdempotencyKey is the processing receipt of a request sent with an idempotency key. Once the
+// request is completed it holds the response sent back to the producer.
+//
+// ExpiresAt is the end of the lease of the request processing it, while it is not completed, and
+// the end of the window its response is kept for once it is. Expired records can be claimed again.
+type IdempotencyKey struct {
+	Key         string     `gorm:"primary_key;type:varchar(640)" json:"key"`
+	RequestHash string     `gorm:"type:varchar(64);not null" json:"request_hash"`
+	StatusCode  int        `gorm:"not null;default:0" json:"status_code"`
+	ContentType string     `gorm:"type:varchar(255);not null;default:''" json:"content_type"`
+	Body        []byte     `gorm:"type:bytea" json:"body"`
+	CreatedAt   time.Time  `gorm:"not null" json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"expires_at"`
+}
+
+// Completed reports whether the response to the request has been stored.
+func (ik IdempotencyKey) Completed() bool {
+	return ik.CompletedAt != nil
+}
+
+const (
+	// idempotencyPurgeInterval is the time between two deletions of the expired records.
+	idempotencyPurgeInterval = time.Minute
+
+	// maxClaimAttempts is the number of times Claim tries to take over an expired record that
+	// other requests keep taking over or deleting first.
+	maxClaimAttempts = 3
+)
+
+// NewIdempotencyService returns an IdempotencyService keeping the records on the database. The
+// responses are kept for window, and the requests in progress hold their key for lease, so the key
+// of a request that never completed, e.g. because the service stopped, is freed once lease is
+// over. Expired records are deleted in the background until the service is closed.
+func NewIdempotencyService(db *gorm.DB, window, lease time.Duration, log *log.Logger) IdempotencyService {
+	ig := &idempotencyGorm{
+		db:     db,
+		window: window,
+		lease:  lease,
+		log:    log,
+		worker: newWorker(),
+	}
+	ig.start(ig.run)
+	return ig
+}
+
+type idempotencyGorm struct {
+	db     *gorm.DB
+	window time.Duration
+	lease  time.Duration
+	log    *log.Logger
+
+	// worker purges the records, for every service WithWindow returns too.
+	*worker
+}
+
+// run deletes the expired records every idempotencyPurgeInterval.
+func (ig *idempotencyGorm) run(ctx context.Context) {
+	every(ctx, idempotencyPurgeInterval, func(ctx context.Context) {
+		if err := ig.Purge(ctx); err != nil && ctx.Err() == nil {
+			ig.log.Printf("idempotency_cleanup_error: %v", err)
+		}
+	})
+}
+
+// WithWindow returns a copy of ig keeping the responses for window.
+func (ig *idempotencyGorm) WithWindow(window time.Duration) IdempotencyService {
+	view := *ig
+	view.window = window
+	return &view
+}
+
+// Claim inserts a record for key, leased to the request. If the key is already taken by an
+// expired record, the record is replaced. The claim is identified by its creation time, which is
+// kept to the precision of the database.
+func (ig *idempotencyGorm) Claim(ctx context.Context, key, requestHash string) (IdempotencyKey, bool, error) {
+	ctx, span := trace.StartSpan(ctx, "models.idempotencyGorm.Claim")
+	defer span.End()
+	db := ig.db.WithContext(ctx)
+
+	for attempt := 0; attempt < maxClaimAttempts; attempt++ {
+		now := time.Now().Truncate(time.Microsecond)
+		ik := IdempotencyKey{Key: key, RequestHash: requestHash, CreatedAt: now, ExpiresAt: now.Add(ig.lease)}
+
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ik)
+		if res.Error != nil {
+			return IdempotencyKey{}, false, fmt.Errorf("models: couldn't claim idempotency key %w", res.Error)
+		}
+		if res.RowsAffected == 1 {
+			return ik, true, nil
+		}
+
+		var stored IdempotencyKey
+		err := db.First(&stored, "key = ?", key).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The record was released or purged meanwhile.
+			continue
+		}
+		if err != nil {
+			return IdempotencyKey{}, false, fmt.Errorf("models: couldn't find idempotency key %w", err)
+		}
+		if stored.ExpiresAt.After(now) {
+			return stored, false, nil
+		}
+
+		// The stored record expired, take it over unless another request did it first.
+		res = db.Model(&IdempotencyKey{}).
+			Where("key = ? AND expires_at = ?", key, stored.ExpiresAt).
+			Updates(map[string]interface{}{
+				"request_hash": requestHash,
+				"status_code":  0,
+				"content_type": "",
+				"body":         nil,
+				"created_at":   ik.CreatedAt,
+				"completed_at": nil,
+				"expires_at":   ik.ExpiresAt,
+			})
+		if res.Error != nil {
+			return IdempotencyKey{}, false, fmt.Errorf("models: couldn't claim idempotency key %w", res.Error)
+		}
+		if res.RowsAffected == 1 {
+			return ik, true, nil
+		}
+	}
+
+	return IdempotencyKey{}, false, fmt.Errorf("models: couldn't claim idempotency key %q, taken over %d times", key, maxClaimAttempts)
+}
+
+// Complete stores the response of the claim, kept until the window is over. Records whose lease
+// expired and were taken over by another request are left alone.
+func (ig *idempotencyGorm) Complete(ctx context.Context, claim IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	ctx, span := trace.StartSpan(ctx, "models.idempotencyGorm.Complete")
+	defer span.End()
+
+	now := time.Now()
+	res := ig.db.WithContext(ctx).Model(&IdempotencyKey{}).
+		Where("key = ? AND created_at = ? AND completed_at IS NULL", claim.Key, claim.CreatedAt).
+		Updates(map[string]interface{}{
+			"status_code":  statusCode,
+			"content_type": contentType,
+			"body":         body,
+			"completed_at": now,
+			"expires_at":   now.Add(ig.window),
+		})
+	if res.Error != nil {
+		return fmt.Errorf("models: couldn't complete idempotency key %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("models: couldn't complete idempotency key %q %w", claim.Key, ErrIdempotencyKeyLost)
+	}
+	return nil
+}
+
+// Release deletes the record of the claim. Records whose lease expired and were taken over by
+// another request are left alone.
+func (ig *idempotencyGorm) Release(ctx context.Context, claim IdempotencyKey) error {
+	ctx, span := trace.StartSpan(ctx, "models.idempotencyGorm.Release")
+	defer span.End()
+
+	res := ig.db.WithContext(ctx).
+		Where("key = ? AND created_at = ? AND completed_at IS NULL", claim.Key, claim.CreatedAt).
+		Delete(&IdempotencyKey{})
+	if res.Error != nil {
+		return fmt.Errorf("models: couldn't release idempotency key %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("models: couldn't release idempotency key %q %w", claim.Key, ErrIdempotencyKeyLost)
+	}
+	return nil
+}
+
+// Purge deletes the expired records, whichever service claimed them.
+func (ig *idempotencyGorm) Purge(ctx context.Context) error {
+	ctx, span := trace.StartSpan(ctx, "models.idempotencyGorm.Purge")
+	defer span.End()
+
+	err := ig.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return fmt.Errorf("models: couldn't purge idempotency keys %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyGorm(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	iks := NewIdempotencyService(db, 500*time.Millisecond, 200*time.Millisecond, log.New(log.Writer(), "test", 0))
+	defer iks.Close()
+	ctx := context.Background()
+
+	claim, claimed, err := iks.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.True(t, claimed, "a new key is claimed")
+
+	ik, claimed, err := iks.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.False(t, claimed, "a key can only be claimed once")
+	assert.False(t, ik.Completed())
+
+	assert.NoError(t, iks.Release(ctx, claim))
+	_, claimed, err = iks.Claim(ctx, "k1", "other")
+	assert.NoError(t, err)
+	assert.True(t, claimed, "a released key can be claimed again")
+
+	time.Sleep(300 * time.Millisecond)
+	ik, claimed, err = iks.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.True(t, claimed, "the key of a request that never completed is freed after the lease")
+	assert.Equal(t, "hash", ik.RequestHash)
+
+	assert.NoError(t, iks.Complete(ctx, ik, 200, "application/json", []byte(`{}`)))
+	ik, claimed, err = iks.Claim(ctx, "k1", "other")
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	assert.True(t, ik.Completed())
+	assert.Equal(t, "hash", ik.RequestHash)
+	assert.Equal(t, 200, ik.StatusCode)
+	assert.Equal(t, []byte(`{}`), ik.Body)
+
+	time.Sleep(300 * time.Millisecond)
+	_, claimed, err = iks.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.False(t, claimed, "completed keys are kept for the window")
+
+	time.Sleep(300 * time.Millisecond)
+	ik, claimed, err = iks.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.True(t, claimed, "an expired key can be claimed again")
+	assert.Equal(t, "hash", ik.RequestHash)
+}
+
+func TestIdempotencyGorm_Purge(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	ig := &idempotencyGorm{db: db, window: time.Hour, lease: time.Millisecond}
+	ctx := context.Background()
+
+	_, _, err := ig.Claim(ctx, "abandoned", "hash")
+	assert.NoError(t, err)
+	claim, _, err := ig.Claim(ctx, "completed", "hash")
+	assert.NoError(t, err)
+	assert.NoError(t, ig.Complete(ctx, claim, 200, "application/json", []byte(`{}`)))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, ig.Purge(ctx))
+
+	var keys []string
+	db.Model(&IdempotencyKey{}).Pluck("key", &keys)
+	assert.Equal(t, []string{"completed"}, keys)
+}
+
+func TestIdempotencyGorm_takenOver(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	ig := &idempotencyGorm{db: db, window: time.Hour, lease: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	first, claimed, err := ig.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// The lease of the first request expires, and a second request takes the key over.
+	time.Sleep(100 * time.Millisecond)
+	second, claimed, err := ig.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// The first request can neither release nor complete the claim of the second one.
+	assert.True(t, errors.Is(ig.Release(ctx, first), ErrIdempotencyKeyLost))
+	assert.True(t, errors.Is(ig.Complete(ctx, first, 200, "application/json", []byte(`{"call":1}`)), ErrIdempotencyKeyLost))
+
+	ik, claimed, err := ig.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.False(t, claimed, "the key stays with the second request")
+	assert.False(t, ik.Completed())
+
+	assert.NoError(t, ig.Complete(ctx, second, 200, "application/json", []byte(`{"call":2}`)))
+	ik, _, err = ig.Claim(ctx, "k1", "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"call":2}`), ik.Body)
+}
+
+func TestIdempotencyGorm_WithWindow(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	iks := NewIdempotencyService(db, time.Hour, time.Hour, log.New(log.Writer(), "test", 0))
+	defer iks.Close()
+	events := iks.WithWindow(50 * time.Millisecond)
+	ctx := context.Background()
+
+	claim, claimed, err := events.Claim(ctx, "e1", "")
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.NoError(t, events.Complete(ctx, claim, 200, "", nil))
+
+	_, claimed, err = iks.Claim(ctx, "e1", "")
+	assert.NoError(t, err)
+	assert.False(t, claimed, "the services share their records")
+
+	time.Sleep(100 * time.Millisecond)
+	_, claimed, err = events.Claim(ctx, "e1", "")
+	assert.NoError(t, err)
+	assert.True(t, claimed, "the responses are kept for the window of the service completing them")
+}
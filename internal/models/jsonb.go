@@ -0,0 +1,92 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONB is a JSON document stored in a jsonb column. Documents are kept as they were received, so
+// they are returned with the fields no other column holds. The empty document is stored as NULL.
+type JSONB json.RawMessage
+
+// Value implements driver.Valuer.
+func (j JSONB) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+// Scan implements sql.Scanner.
+func (j *JSONB) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append(JSONB(nil), v...)
+	case string:
+		*j = JSONB(v)
+	default:
+		return fmt.Errorf("models: can't scan %T into JSONB", src)
+	}
+	return nil
+}
+
+// MarshalJSON returns the document, or null if there is none.
+func (j JSONB) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// UnmarshalJSON keeps a copy of data.
+func (j *JSONB) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*j = nil
+		return nil
+	}
+	*j = append(JSONB(nil), data...)
+	return nil
+}
+
+// PayloadMatch selects the objects whose payload holds Value at Path. Path lists the keys leading
+// from the root of the document to the matched field, {"location", "region"} for
+// {"location":{"region":"eu"}}. Values are compared as text, so numbers and booleans match their
+// JSON representation.
+type PayloadMatch struct {
+	Path  []string
+	Value string
+}
+
+// ParsePayloadPath splits a dot separated path such as location.region into its keys.
+func ParsePayloadPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys {
+		if k == "" {
+			return nil, ErrInvalidPayloadPath
+		}
+	}
+	return keys, nil
+}
+
+// where returns the condition matching pm on the payload column, and its arguments.
+func (pm PayloadMatch) where() (string, []interface{}) {
+	var (
+		sb   strings.Builder
+		args = make([]interface{}, 0, len(pm.Path)+1)
+	)
+	sb.WriteString("payload")
+	for i, k := range pm.Path {
+		if i == len(pm.Path)-1 {
+			sb.WriteString(" ->> ?::text")
+		} else {
+			sb.WriteString(" -> ?::text")
+		}
+		args = append(args, k)
+	}
+	sb.WriteString(" = ?")
+	return sb.String(), append(args, pm.Value)
+}
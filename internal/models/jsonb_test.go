@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONB(t *testing.T) {
+	var c Callback
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":1,"payload":{"id":1,"region":"eu"}}`), &c))
+	assert.Equal(t, JSONB(`{"id":1,"region":"eu"}`), c.Payload)
+
+	v, err := c.Payload.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"region":"eu"}`, v)
+
+	var scanned JSONB
+	assert.NoError(t, scanned.Scan([]byte(`{"id":1}`)))
+	assert.Equal(t, JSONB(`{"id":1}`), scanned)
+	assert.NoError(t, scanned.Scan(nil))
+	assert.Nil(t, scanned)
+
+	v, err = scanned.Value()
+	assert.NoError(t, err)
+	assert.Nil(t, v, "empty documents are stored as NULL")
+
+	b, err := json.Marshal(Callback{ID: 2})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"tenant":"","id":2,"online":false,"timestamp":0}`, string(b))
+}
+
+func TestParsePayloadPath(t *testing.T) {
+	path, err := ParsePayloadPath("location.region")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"location", "region"}, path)
+
+	for _, invalid := range []string{"", ".region", "location.", "location..region"} {
+		_, err := ParsePayloadPath(invalid)
+		assert.Equal(t, ErrInvalidPayloadPath, err, invalid)
+	}
+}
+
+func TestPayloadMatch_where(t *testing.T) {
+	cond, args := PayloadMatch{Path: []string{"location", "region"}, Value: "eu"}.where()
+	assert.Equal(t, "payload -> ?::text ->> ?::text = ?", cond)
+	assert.Equal(t, []interface{}{"location", "region", "eu"}, args)
+}
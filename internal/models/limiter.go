@@ -0,0 +1,310 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// limiterRecentWeight and limiterBaselineWeight are the weights of a new latency sample on the
+	// recent and baseline latency averages. The baseline follows lasting latency shifts slowly.
+	limiterRecentWeight   = 0.2
+	limiterBaselineWeight = 0.01
+
+	// limiterSamples is the number of latency samples kept to report percentiles.
+	limiterSamples = 200
+)
+
+// ConcurrencyLimitConfig defines how a ConcurrencyLimiter tunes its limit.
+type ConcurrencyLimitConfig struct {
+	// Initial, Min and Max bound the number of requests in flight.
+	Initial int
+	Min     int
+	Max     int
+	// Tolerance is how many times slower than the baseline the recent requests can be before the
+	// limit is decreased.
+	Tolerance float64
+	// Backoff is the factor the limit is multiplied by when decreased.
+	Backoff float64
+}
+
+// ConcurrencyLimiter bounds the requests in flight toward an upstream service with an AIMD
+// algorithm. The limit grows by one every time a full limit worth of requests succeeds, and is
+// multiplied by the backoff when requests fail or the recent latency moves away from the
+// baseline latency, at most once per recent latency.
+//
+// Comparing against a baseline rather than a fixed target lets the limiter adapt to upstreams
+// whose latency changes over time.
+type ConcurrencyLimiter struct {
+	cfg ConcurrencyLimitConfig
+
+	mu        sync.Mutex
+	limit     float64
+	inFlight  int
+	waiters   []chan struct{}
+	recent    float64
+	baseline  float64
+	lastDrop  time.Time
+	samples   []time.Duration
+	next      int
+	total     int64
+	failures  int64
+	decreases int64
+}
+
+// RequestOutcome is how a request let in flight by a ConcurrencyLimiter ended.
+type RequestOutcome int
+
+const (
+	// RequestSucceeded requests add their latency to the averages.
+	RequestSucceeded RequestOutcome = iota
+	// RequestFailed requests failed because of the upstream, and decrease the limit.
+	RequestFailed
+	// RequestCancelled requests were given up by the caller before the upstream answered. They
+	// only free their slot, as neither their outcome nor their latency say anything of the upstream.
+	RequestCancelled
+)
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter starting at cfg.Initial requests in flight.
+// Missing settings take safe defaults.
+func NewConcurrencyLimiter(cfg ConcurrencyLimitConfig) *ConcurrencyLimiter {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Initial < cfg.Min || cfg.Initial > cfg.Max {
+		cfg.Initial = cfg.Min
+	}
+	if cfg.Tolerance <= 1 {
+		cfg.Tolerance = 2
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+
+	return &ConcurrencyLimiter{
+		cfg:   cfg,
+		limit: float64(cfg.Initial),
+	}
+}
+
+// LimiterRegistry holds the ConcurrencyLimiters of the upstreams by name, including the ones of
+// the object services of the tenants, created on first use, so they can all be reported.
+type LimiterRegistry struct {
+	mu       sync.Mutex
+	limiters map[string]*ConcurrencyLimiter
+}
+
+// NewLimiterRegistry returns an empty LimiterRegistry.
+func NewLimiterRegistry() *LimiterRegistry {
+	return &LimiterRegistry{limiters: make(map[string]*ConcurrencyLimiter)}
+}
+
+// register records the limiter of the upstream name, replacing the one it had. Nil registries
+// and limiters record nothing.
+func (lr *LimiterRegistry) register(name string, cl *ConcurrencyLimiter) {
+	if lr == nil || cl == nil {
+		return
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.limiters[name] = cl
+}
+
+// Limiters returns the limiters registered so far, by upstream name.
+func (lr *LimiterRegistry) Limiters() map[string]*ConcurrencyLimiter {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	ls := make(map[string]*ConcurrencyLimiter, len(lr.limiters))
+	for name, cl := range lr.limiters {
+		ls[name] = cl
+	}
+	return ls
+}
+
+// like returns a new ConcurrencyLimiter tuned like cl, for another upstream. A nil limiter
+// returns nil.
+func (cl *ConcurrencyLimiter) like() *ConcurrencyLimiter {
+	if cl == nil {
+		return nil
+	}
+	return NewConcurrencyLimiter(cl.cfg)
+}
+
+// Acquire waits for a request to be allowed in flight. The returned func must be called once the
+// request is done, reporting its outcome. A nil limiter never waits.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context) (func(RequestOutcome), error) {
+	if cl == nil {
+		return func(RequestOutcome) {}, nil
+	}
+
+	cl.mu.Lock()
+	if len(cl.waiters) == 0 && cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		cl.mu.Unlock()
+		return cl.releaser(time.Now()), nil
+	}
+	ready := make(chan struct{})
+	cl.waiters = append(cl.waiters, ready)
+	cl.mu.Unlock()
+
+	select {
+	case <-ready:
+		return cl.releaser(time.Now()), nil
+	case <-ctx.Done():
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+
+		for i, w := range cl.waiters {
+			if w == ready {
+				cl.waiters = append(cl.waiters[:i], cl.waiters[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// The slot was handed over meanwhile, give it to the next request.
+		cl.inFlight--
+		cl.wake()
+		return nil, ctx.Err()
+	}
+}
+
+// releaser returns the func ending a request started at began.
+func (cl *ConcurrencyLimiter) releaser(began time.Time) func(RequestOutcome) {
+	var once sync.Once
+	return func(outcome RequestOutcome) {
+		once.Do(func() {
+			if outcome == RequestCancelled {
+				cl.cancel()
+				return
+			}
+			cl.release(time.Since(began), outcome == RequestFailed)
+		})
+	}
+}
+
+// cancel frees the slot of a request given up by its caller, leaving the limit as it is.
+func (cl *ConcurrencyLimiter) cancel() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inFlight--
+	cl.wake()
+}
+
+// release records the outcome of a request and tunes the limit.
+func (cl *ConcurrencyLimiter) release(latency time.Duration, failed bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	saturated := float64(cl.inFlight) >= cl.limit/2
+	cl.inFlight--
+	cl.record(latency, failed)
+
+	now := time.Now()
+	slow := cl.recent > cl.baseline*cl.cfg.Tolerance
+	switch {
+	case (failed || slow) && now.Sub(cl.lastDrop) >= time.Duration(cl.recent):
+		cl.limit = math.Max(float64(cl.cfg.Min), cl.limit*cl.cfg.Backoff)
+		cl.lastDrop = now
+		cl.decreases++
+	case !failed && !slow && saturated:
+		// The limit is only raised while it is being used, so it doesn't grow unbounded while
+		// the traffic is low.
+		cl.limit = math.Min(float64(cl.cfg.Max), cl.limit+1/cl.limit)
+	}
+
+	cl.wake()
+}
+
+// record adds a latency sample to the averages and to the samples kept for the percentiles.
+func (cl *ConcurrencyLimiter) record(latency time.Duration, failed bool) {
+	cl.total++
+	if failed {
+		cl.failures++
+	}
+
+	l := float64(latency)
+	if cl.baseline == 0 {
+		cl.recent, cl.baseline = l, l
+	} else {
+		cl.recent += limiterRecentWeight * (l - cl.recent)
+		cl.baseline += limiterBaselineWeight * (l - cl.baseline)
+	}
+
+	if len(cl.samples) < limiterSamples {
+		cl.samples = append(cl.samples, latency)
+		return
+	}
+	cl.samples[cl.next] = latency
+	cl.next = (cl.next + 1) % limiterSamples
+}
+
+// wake lets the waiting requests in while the limit allows it.
+func (cl *ConcurrencyLimiter) wake() {
+	for len(cl.waiters) > 0 && cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		close(cl.waiters[0])
+		cl.waiters = cl.waiters[1:]
+	}
+}
+
+// ConcurrencyLimiterStats is a snapshot of the state of a ConcurrencyLimiter. Latencies are given
+// in milliseconds.
+type ConcurrencyLimiterStats struct {
+	Limit     int     `json:"limit"`
+	MinLimit  int     `json:"min_limit"`
+	MaxLimit  int     `json:"max_limit"`
+	InFlight  int     `json:"in_flight"`
+	Waiting   int     `json:"waiting"`
+	Requests  int64   `json:"requests"`
+	Failures  int64   `json:"failures"`
+	Decreases int64   `json:"decreases"`
+	Recent    float64 `json:"recent_latency_ms"`
+	Baseline  float64 `json:"baseline_latency_ms"`
+	P50       float64 `json:"p50_latency_ms"`
+	P90       float64 `json:"p90_latency_ms"`
+	P99       float64 `json:"p99_latency_ms"`
+}
+
+// Stats returns the current limit and the latencies observed by the limiter.
+func (cl *ConcurrencyLimiter) Stats() ConcurrencyLimiterStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	samples := append([]time.Duration(nil), cl.samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	return ConcurrencyLimiterStats{
+		Limit:     int(cl.limit),
+		MinLimit:  cl.cfg.Min,
+		MaxLimit:  cl.cfg.Max,
+		InFlight:  cl.inFlight,
+		Waiting:   len(cl.waiters),
+		Requests:  cl.total,
+		Failures:  cl.failures,
+		Decreases: cl.decreases,
+		Recent:    milliseconds(time.Duration(cl.recent)),
+		Baseline:  milliseconds(time.Duration(cl.baseline)),
+		P50:       milliseconds(percentile(samples, 0.50)),
+		P90:       milliseconds(percentile(samples, 0.90)),
+		P99:       milliseconds(percentile(samples, 0.99)),
+	}
+}
+
+// percentile returns the p percentile of the sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiter_Acquire(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyLimitConfig{Initial: 1, Min: 1, Max: 1})
+
+	done, err := cl.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	// The limit is reached, so the next request waits until the first one is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cl.Acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, cl.Stats().Waiting)
+
+	acquired := make(chan struct{})
+	go func() {
+		next, err := cl.Acquire(context.Background())
+		assert.NoError(t, err)
+		next(RequestSucceeded)
+		close(acquired)
+	}()
+	done(RequestSucceeded)
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiting request was not let in")
+	}
+	assert.Equal(t, 0, cl.Stats().InFlight)
+}
+
+func TestConcurrencyLimiter_limit(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyLimitConfig{Initial: 10, Min: 2, Max: 20, Backoff: 0.5})
+
+	// Successful requests using the limit raise it by one per limit worth of requests.
+	for i := 0; i < 10; i++ {
+		cl.inFlight = 10
+		cl.release(time.Millisecond, false)
+	}
+	assert.Equal(t, 10, cl.Stats().Limit)
+	cl.inFlight = 10
+	cl.release(time.Millisecond, false)
+	assert.Equal(t, 11, cl.Stats().Limit)
+
+	// Failures multiply it by the backoff.
+	cl.inFlight = 1
+	cl.release(time.Millisecond, true)
+	assert.Equal(t, 5, cl.Stats().Limit)
+
+	// Latencies far above the baseline do too, without going below the minimum.
+	cl.lastDrop = time.Time{}
+	cl.inFlight = 1
+	cl.release(time.Second, false)
+	assert.Equal(t, 2, cl.Stats().Limit)
+
+	stats := cl.Stats()
+	assert.Equal(t, int64(13), stats.Requests)
+	assert.Equal(t, int64(1), stats.Failures)
+	assert.Equal(t, int64(2), stats.Decreases)
+	assert.Equal(t, 1000.0, stats.P99)
+}
+
+func TestConcurrencyLimiter_cancelled(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyLimitConfig{Initial: 4, Min: 1, Max: 8, Backoff: 0.5})
+
+	done, err := cl.Acquire(context.Background())
+	assert.NoError(t, err)
+	done(RequestCancelled)
+
+	// Cancelled requests free their slot without being recorded.
+	stats := cl.Stats()
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 4, stats.Limit)
+	assert.Zero(t, stats.Requests)
+	assert.Zero(t, stats.Decreases)
+}
+
+func TestConcurrencyLimiter_nil(t *testing.T) {
+	var cl *ConcurrencyLimiter
+
+	done, err := cl.Acquire(context.Background())
+	assert.NoError(t, err)
+	done(RequestFailed)
+}
@@ -0,0 +1,327 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+)
+
+// objectEventsChannel is the Postgres channel notified of the events written to the outbox.
+const objectEventsChannel = "object_events"
+
+// maxNotifyPayload is the size of the largest payload Postgres accepts on a notification.
+const maxNotifyPayload = 7999
+
+// eventNotifications counts the notifications received, the events published from them and from
+// the polls, the reconnections and the errors of the listeners.
+var eventNotifications = expvar.NewMap("event_notifications")
+
+// idRange is an inclusive range of outbox IDs.
+type idRange struct {
+	first, last int64
+}
+
+// notifyPayload returns the payload notifying the outbox events ids, in ascending order, as
+// ranges of consecutive IDs: "12-40,45". Payloads too large to be sent are left empty, asking the
+// listeners to poll the outbox.
+func notifyPayload(ids []int64) string {
+	var b strings.Builder
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatInt(ids[i], 10))
+		if j > i {
+			b.WriteByte('-')
+			b.WriteString(strconv.FormatInt(ids[j], 10))
+		}
+		if b.Len() > maxNotifyPayload {
+			return ""
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// parseNotifyPayload returns the ranges of outbox IDs held by a notification payload.
+func parseNotifyPayload(payload string) ([]idRange, error) {
+	if payload == "" {
+		return nil, nil
+	}
+
+	var ranges []idRange
+	for _, part := range strings.Split(payload, ",") {
+		first, last := part, part
+		if i := strings.IndexByte(part, '-'); i > 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		r, err := parseIDRange(first, last)
+		if err != nil {
+			return nil, fmt.Errorf("models: invalid notification payload %q", payload)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseIDRange(first, last string) (idRange, error) {
+	var (
+		r   idRange
+		err error
+	)
+	if r.first, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return r, err
+	}
+	if r.last, err = strconv.ParseInt(last, 10, 64); err != nil {
+		return r, err
+	}
+	if r.first > r.last {
+		return r, fmt.Errorf("range %d-%d is reversed", r.first, r.last)
+	}
+	return r, nil
+}
+
+// ListenConfig defines how the events written to the outbox by every replica are listened to.
+// Zero values take the defaults.
+type ListenConfig struct {
+	// DSN is the Postgres connection string of the connection listening to the notifications.
+	DSN string
+	// PollInterval is the time between two reads of the latest events of the outbox, catching up
+	// on the notifications dropped. It is also the time after which an idle connection is
+	// checked. Defaults to 5s.
+	PollInterval time.Duration
+	// Lookback is how old the events read by a poll can be. It must be longer than the
+	// transactions writing them take to commit. Defaults to 30s.
+	Lookback time.Duration
+	// BatchSize is the number of events read at once. Defaults to 500.
+	BatchSize int
+	// ReconnectBackoff is the time waited before connecting again, doubled on every failure up
+	// to MaxReconnectBackoff. Defaults to 1s and 30s.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+}
+
+func (cfg ListenConfig) withDefaults() ListenConfig {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.Lookback <= 0 {
+		cfg.Lookback = 30 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.ReconnectBackoff <= 0 {
+		cfg.ReconnectBackoff = time.Second
+	}
+	if cfg.MaxReconnectBackoff <= 0 {
+		cfg.MaxReconnectBackoff = 30 * time.Second
+	}
+	if cfg.MaxReconnectBackoff < cfg.ReconnectBackoff {
+		cfg.MaxReconnectBackoff = cfg.ReconnectBackoff
+	}
+	return cfg
+}
+
+// EventListener publishes the events written to the outbox by any replica to the sinks of this
+// one, as they are notified on commit. Notifications are not delivered while disconnected, and
+// may be dropped, so the latest events are read again on reconnection and every PollInterval.
+// Events are published at least once, in the order they are notified, so the sinks must ignore
+// the ones they already know about, as the EventBroker does.
+type EventListener struct {
+	db    *gorm.DB
+	cfg   ListenConfig
+	sinks []ObjectEventNotifier
+	log   *log.Logger
+	*worker
+}
+
+// NewEventListener returns the EventListener publishing the events of the outbox to sinks, and
+// starts listening and polling in the background until it is closed.
+func NewEventListener(db *gorm.DB, cfg ListenConfig, sinks []ObjectEventNotifier, log *log.Logger) *EventListener {
+	el := &EventListener{
+		db:     db,
+		cfg:    cfg.withDefaults(),
+		sinks:  sinks,
+		log:    log,
+		worker: newWorker(),
+	}
+	el.start(el.listen)
+	el.start(el.poll)
+	return el
+}
+
+// listen keeps a connection listening to the notifications, connecting again after a backoff when
+// it is lost.
+func (el *EventListener) listen(ctx context.Context) {
+	backoff := el.cfg.ReconnectBackoff
+	for {
+		err := el.serve(ctx, func() { backoff = el.cfg.ReconnectBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		eventNotifications.Add("reconnects", 1)
+		el.log.Printf("listener_error: %v, reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > el.cfg.MaxReconnectBackoff {
+			backoff = el.cfg.MaxReconnectBackoff
+		}
+	}
+}
+
+// serve connects, listens and publishes the notified events until the connection fails. Once
+// listening, it calls listening and catches up on the events written while disconnected.
+func (el *EventListener) serve(ctx context.Context, listening func()) error {
+	conn, err := pgx.Connect(ctx, el.cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "LISTEN "+objectEventsChannel); err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	listening()
+	if _, err := el.Poll(ctx); err != nil {
+		el.log.Printf("listener_poll_error: %v", err)
+	}
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, el.cfg.PollInterval)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		if err != nil {
+			if !pgconn.Timeout(err) {
+				return fmt.Errorf("waiting for notifications: %w", err)
+			}
+			// Idle connections are checked, as a broken one may never fail to wait.
+			pingCtx, cancel := context.WithTimeout(ctx, el.cfg.PollInterval)
+			err = conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("checking connection: %w", err)
+			}
+			continue
+		}
+
+		eventNotifications.Add("received", 1)
+		if err := el.handle(ctx, n.Payload); err != nil {
+			eventNotifications.Add("errors", 1)
+			el.log.Printf("listener_error: %v", err)
+		}
+	}
+}
+
+// poll publishes the latest events every PollInterval.
+func (el *EventListener) poll(ctx context.Context) {
+	every(ctx, el.cfg.PollInterval, func(ctx context.Context) {
+		if _, err := el.Poll(ctx); err != nil && ctx.Err() == nil {
+			eventNotifications.Add("errors", 1)
+			el.log.Printf("listener_poll_error: %v", err)
+		}
+	})
+}
+
+// handle publishes the events named by a notification payload. Payloads naming no events poll
+// the outbox instead.
+func (el *EventListener) handle(ctx context.Context, payload string) error {
+	ctx, span := trace.StartSpan(ctx, "models.EventListener.handle")
+	defer span.End()
+
+	ranges, err := parseNotifyPayload(payload)
+	if err != nil || len(ranges) == 0 {
+		if _, perr := el.Poll(ctx); perr != nil {
+			return perr
+		}
+		return err
+	}
+
+	conds := make([]string, len(ranges))
+	args := make([]interface{}, 0, 2*len(ranges))
+	for i, r := range ranges {
+		conds[i] = "id BETWEEN ? AND ?"
+		args = append(args, r.first, r.last)
+	}
+
+	var rows []OutboxEvent
+	err = el.db.WithContext(ctx).Where(strings.Join(conds, " OR "), args...).Order("id").Find(&rows).Error
+	if err != nil {
+		return fmt.Errorf("models: couldn't read notified events %w", err)
+	}
+	return el.publish(ctx, rows, "notified")
+}
+
+// Poll publishes the events written to the outbox within the lookback, and returns how many were.
+func (el *EventListener) Poll(ctx context.Context) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "models.EventListener.Poll")
+	defer span.End()
+
+	since := time.Now().Add(-el.cfg.Lookback)
+	var after int64
+	published := 0
+	for {
+		var rows []OutboxEvent
+		err := el.db.WithContext(ctx).
+			Where("created_at > ? AND id > ?", since, after).
+			Order("id").
+			Limit(el.cfg.BatchSize).
+			Find(&rows).Error
+		if err != nil {
+			return published, fmt.Errorf("models: couldn't poll outbox %w", err)
+		}
+		if err := el.publish(ctx, rows, "polled"); err != nil {
+			return published, err
+		}
+		published += len(rows)
+		if len(rows) < el.cfg.BatchSize {
+			return published, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// publish decodes rows and sends them to every sink, counting them under key.
+func (el *EventListener) publish(ctx context.Context, rows []OutboxEvent, key string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	events := make([]ObjectEvent, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal(row.Event, &events[i]); err != nil {
+			return fmt.Errorf("models: decoding outbox event %d %w", row.ID, err)
+		}
+		events[i].ID = row.ID
+	}
+
+	var failed error
+	for _, sink := range el.sinks {
+		if err := sink.Notify(ctx, events); err != nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return fmt.Errorf("models: couldn't publish events %w", failed)
+	}
+	eventNotifications.Add(key, int64(len(events)))
+	return nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+)
+
+func TestNotifyPayload(t *testing.T) {
+	var cases = []struct {
+		name   string
+		ids    []int64
+		out    string
+		ranges []idRange
+	}{
+		{"empty", nil, "", nil},
+		{"single", []int64{7}, "7", []idRange{{7, 7}}},
+		{"ranges", []int64{12, 13, 14, 20, 22, 23}, "12-14,20,22-23", []idRange{{12, 14}, {20, 20}, {22, 23}}},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			payload := notifyPayload(cs.ids)
+			assert.Equal(t, cs.out, payload)
+
+			ranges, err := parseNotifyPayload(payload)
+			assert.NoError(t, err)
+			assert.Equal(t, cs.ranges, ranges)
+		})
+	}
+
+	t.Run("tooLarge", func(t *testing.T) {
+		var ids []int64
+		for id := int64(1e9); len(ids) < 1000; id += 2 {
+			ids = append(ids, id)
+		}
+		assert.Equal(t, "", notifyPayload(ids), "listeners poll the events of large payloads")
+	})
+
+	for _, payload := range []string{"a", "1-", "3-2", "1,,2"} {
+		_, err := parseNotifyPayload(payload)
+		assert.Error(t, err, payload)
+	}
+}
+
+func TestEventListener_serve(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	sink := &testSink{}
+	el := &EventListener{
+		db:    db,
+		cfg:   ListenConfig{DSN: db.Dialector.(*postgres.Dialector).DSN, PollInterval: time.Hour}.withDefaults(),
+		sinks: []ObjectEventNotifier{sink},
+		log:   log.New(os.Stderr, "", 0),
+	}
+
+	// Events written before listening are caught up on.
+	now := time.Now().Truncate(time.Second).UTC()
+	event := ObjectEvent{Type: EventObjectOnline, Time: now, Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 1}}
+	assert.NoError(t, writeOutbox(db, []ObjectEvent{event}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listening := make(chan struct{})
+	served := make(chan error, 1)
+	go func() { served <- el.serve(ctx, func() { close(listening) }) }()
+	select {
+	case <-listening:
+	case err := <-served:
+		t.Fatalf("listener stopped before listening: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is not listening")
+	}
+
+	assert.NoError(t, writeOutbox(db, []ObjectEvent{event, event}))
+
+	var events []ObjectEvent
+	assert.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		events = append(events[:0], sink.events...)
+		return len(events) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	for i, e := range events {
+		assert.Greater(t, e.ID, int64(0), "events carry their outbox ID")
+		e.ID = 0
+		assert.Equal(t, event, e, i)
+	}
+}
+
+func TestEventListener_Poll(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	sink := &testSink{}
+	el := &EventListener{db: db, cfg: ListenConfig{BatchSize: 2, Lookback: time.Minute}.withDefaults(), sinks: []ObjectEventNotifier{sink}}
+
+	now := time.Now().Truncate(time.Second).UTC()
+	old := ObjectEvent{Type: EventObjectOffline, Time: now.Add(-time.Hour), Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 1}}
+	recent := ObjectEvent{Type: EventObjectOnline, Time: now, Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 2}}
+	assert.NoError(t, writeOutbox(db, []ObjectEvent{old, recent, recent, recent}))
+
+	n, err := el.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n, "events older than the lookback are not read")
+	for _, e := range sink.events {
+		assert.Equal(t, int64(2), e.Object.ID)
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// loadLatencyWeight is the weight of the latest sample on the processing latency average.
+const loadLatencyWeight = 0.2
+
+// LoadReporter is implemented by the services processing work after the request is answered.
+type LoadReporter interface {
+	// Load returns the backlog of the service.
+	Load() Load
+}
+
+// Load describes the work waiting to be completed by a service.
+type Load struct {
+	// Pending is the number of items accepted but not processed yet.
+	Pending int64
+	// Latency is the moving average of the time spent processing an item.
+	Latency time.Duration
+}
+
+// loadTracker measures the backlog and processing latency of a service.
+type loadTracker struct {
+	mu      sync.Mutex
+	pending int64
+	latency float64
+}
+
+// start records an item entering the backlog. The returned func must be called once the item
+// is processed.
+func (lt *loadTracker) start() func() {
+	began := time.Now()
+
+	lt.mu.Lock()
+	lt.pending++
+	lt.mu.Unlock()
+
+	return func() {
+		elapsed := float64(time.Since(began))
+
+		lt.mu.Lock()
+		defer lt.mu.Unlock()
+
+		lt.pending--
+		if lt.latency == 0 {
+			lt.latency = elapsed
+			return
+		}
+		lt.latency += loadLatencyWeight * (elapsed - lt.latency)
+	}
+}
+
+// Load returns the current backlog.
+func (lt *loadTracker) Load() Load {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	return Load{Pending: lt.pending, Latency: time.Duration(lt.latency)}
+}
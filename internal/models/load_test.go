@@ -0,0 +1,24 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTracker(t *testing.T) {
+	var lt loadTracker
+
+	first, second := lt.start(), lt.start()
+	assert.Equal(t, int64(2), lt.Load().Pending)
+
+	time.Sleep(10 * time.Millisecond)
+	first()
+	load := lt.Load()
+	assert.Equal(t, int64(1), load.Pending)
+	assert.GreaterOrEqual(t, int64(load.Latency), int64(10*time.Millisecond))
+
+	second()
+	assert.Equal(t, int64(0), lt.Load().Pending)
+}
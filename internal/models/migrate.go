@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migrate keeps the database schema up to date with the models.
+func Migrate(db *gorm.DB) error {
+
+	// Callbacks used to be identified by their ID alone. The stored ones are moved to the default
+	// tenant, which is added to the primary key.
+	if m := db.Migrator(); m.HasTable(&Callback{}) && !m.HasColumn(&Callback{}, "tenant") {
+		if err := migrateCallbackTenants(db); err != nil {
+			return fmt.Errorf("models: adding tenant to callbacks table %w", err)
+		}
+	}
+
+	return db.AutoMigrate(&Callback{}, &IdempotencyKey{}, &APIKey{}, &Tenant{}, &rateLimitBucket{},
+		&WebhookSubscription{}, &WebhookDelivery{}, &WebhookAttempt{}, &OutboxEvent{}, &outboxPublished{},
+		&outboxLease{})
+}
+
+// migrateCallbackTenants adds the tenant column to the callbacks table, backfilled with
+// DefaultTenant, and rebuilds the primary key on the tenant and the ID, in a single transaction.
+func migrateCallbackTenants(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		stmts := []struct {
+			sql  string
+			args []interface{}
+		}{
+			{`ALTER TABLE callbacks ADD COLUMN tenant varchar(64)`, nil},
+			{`UPDATE callbacks SET tenant = ?`, []interface{}{DefaultTenant}},
+			{`ALTER TABLE callbacks ALTER COLUMN tenant SET NOT NULL`, nil},
+			{`ALTER TABLE callbacks DROP CONSTRAINT IF EXISTS callbacks_pkey`, nil},
+			{`ALTER TABLE callbacks ADD PRIMARY KEY (tenant, id)`, nil},
+		}
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt.sql, stmt.args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
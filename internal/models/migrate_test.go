@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate_callbackTenants(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+
+	// Callbacks as stored before tenants.
+	assert.NoError(t, db.Exec(`DROP TABLE callbacks`).Error)
+	assert.NoError(t, db.Exec(`CREATE TABLE callbacks (id bigint PRIMARY KEY, online boolean NOT NULL, timestamp bigint NOT NULL)`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO callbacks (id, online, timestamp) VALUES (1, true, 10), (2, false, 20)`).Error)
+
+	assert.NoError(t, Migrate(db))
+
+	var cs []Callback
+	assert.NoError(t, db.Order("id").Find(&cs).Error)
+	assert.Equal(t, []Callback{
+		{Tenant: DefaultTenant, ID: 1, Online: true, Timestamp: 10},
+		{Tenant: DefaultTenant, ID: 2, Online: false, Timestamp: 20},
+	}, cs, "stored callbacks are kept, on the default tenant")
+
+	assert.NoError(t, db.Create(&Callback{Tenant: "acme", ID: 1, Timestamp: 30}).Error, "IDs are unique within a tenant")
+	assert.Error(t, db.Create(&Callback{Tenant: DefaultTenant, ID: 1, Timestamp: 30}).Error)
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// Types of the changes of the stored objects.
+const (
+	// EventObjectOnline is raised when an object is stored online, for the first time or after it
+	// was offline.
+	EventObjectOnline = "object.online"
+	// EventObjectOffline is raised when a stored object is found offline.
+	EventObjectOffline = "object.offline"
+	// EventObjectExpired is raised when an object is deleted after its retention window.
+	EventObjectExpired = "object.expired"
+)
+
+// ObjectEventTypes lists every type of object event.
+var ObjectEventTypes = []string{EventObjectOnline, EventObjectOffline, EventObjectExpired}
+
+// ObjectEvent is a change of the state of a stored object.
+type ObjectEvent struct {
+	// ID orders the events, it is set once the event is written to the outbox. Events published
+	// more than once keep their ID.
+	ID     int64     `json:"id,omitempty"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant"`
+	// Object is the object as stored after the change, or before it was deleted.
+	Object Callback `json:"object"`
+}
+
+// ObjectEventNotifier is told about the changes of the stored objects. It is the sink the
+// OutboxRelay publishes the outbox to.
+type ObjectEventNotifier interface {
+	// Notify is called once the changes of events are stored, with the events of an object in
+	// the order they happened. Events may be notified more than once.
+	Notify(ctx context.Context, events []ObjectEvent) error
+}
+
+// callbackKey identifies a stored callback.
+type callbackKey struct {
+	tenant string
+	id     int64
+}
+
+// transitions returns the events raised by storing cs over the callbacks stored before.
+func transitions(before map[callbackKey]Callback, cs []Callback, now time.Time) []ObjectEvent {
+	var events []ObjectEvent
+	for _, c := range cs {
+		prev, found := before[callbackKey{c.Tenant, c.ID}]
+		switch {
+		case c.Online && (!found || !prev.Online):
+			events = append(events, ObjectEvent{Type: EventObjectOnline, Time: now, Tenant: c.Tenant, Object: c})
+		case !c.Online && found && prev.Online:
+			// Offline objects keep the timestamp they were last seen online with, and the payload
+			// last served if the object service no longer serves any.
+			c.Timestamp = prev.Timestamp
+			if c.Payload == nil {
+				c.Payload = prev.Payload
+			}
+			events = append(events, ObjectEvent{Type: EventObjectOffline, Time: now, Tenant: c.Tenant, Object: c})
+		}
+	}
+	return events
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransitions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	before := map[callbackKey]Callback{
+		{"acme", 1}: {Tenant: "acme", ID: 1, Online: true, Timestamp: 10},
+		{"acme", 2}: {Tenant: "acme", ID: 2, Online: true, Timestamp: 20, Payload: JSONB(`{"id":2}`)},
+		{"acme", 3}: {Tenant: "acme", ID: 3, Online: false, Timestamp: 30},
+	}
+	cs := []Callback{
+		{Tenant: "acme", ID: 1, Online: true, Timestamp: 40},  // still online
+		{Tenant: "acme", ID: 2, Online: false, Timestamp: 40}, // goes offline
+		{Tenant: "acme", ID: 3, Online: true, Timestamp: 40},  // back online
+		{Tenant: "acme", ID: 4, Online: true, Timestamp: 40},  // new
+		{Tenant: "acme", ID: 5, Online: false, Timestamp: 40}, // unknown and offline
+	}
+
+	assert.Equal(t, []ObjectEvent{
+		{Type: EventObjectOffline, Time: now, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 2, Timestamp: 20, Payload: JSONB(`{"id":2}`)}},
+		{Type: EventObjectOnline, Time: now, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 3, Online: true, Timestamp: 40}},
+		{Type: EventObjectOnline, Time: now, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 4, Online: true, Timestamp: 40}},
+	}, transitions(before, cs, now))
+}
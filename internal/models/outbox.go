@@ -0,0 +1,360 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// outboxEvents counts the events of the outbox published, the failed attempts to publish them,
+// and the published events deleted.
+var outboxEvents = expvar.NewMap("outbox_events")
+
+// OutboxEvent is an ObjectEvent written to the outbox in the same transaction as the change of
+// the object that raised it, until it is published to the sinks. PublishedAt is set once it is
+// published to every sink.
+type OutboxEvent struct {
+	ID          int64      `gorm:"primary_key;type:bigserial"`
+	Tenant      string     `gorm:"type:varchar(64);not null"`
+	ObjectID    int64      `gorm:"type:bigint;not null"`
+	Type        string     `gorm:"type:varchar(64);not null"`
+	Event       JSONB      `gorm:"type:jsonb;not null"`
+	CreatedAt   time.Time  `gorm:"not null;index"`
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+// outboxPublished records an event of the outbox published to a sink.
+type outboxPublished struct {
+	Sink    string `gorm:"type:varchar(64);primary_key"`
+	EventID int64  `gorm:"primary_key;autoIncrement:false"`
+}
+
+// TableName sets the name of the table storing the published events.
+func (outboxPublished) TableName() string {
+	return "outbox_published"
+}
+
+// outboxLease is held by the replica publishing the outbox to a sink, so the events are
+// published to it in order, by one replica at a time.
+type outboxLease struct {
+	Sink      string    `gorm:"type:varchar(64);primary_key"`
+	Owner     string    `gorm:"type:varchar(64);not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// writeOutbox adds events to the outbox, as part of the transaction tx, and notifies the
+// listeners of every replica of them once tx commits.
+func writeOutbox(tx *gorm.DB, events []ObjectEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]OutboxEvent, len(events))
+	for i, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("models: encoding object event %w", err)
+		}
+		rows[i] = OutboxEvent{
+			Tenant:    e.Tenant,
+			ObjectID:  e.Object.ID,
+			Type:      e.Type,
+			Event:     JSONB(b),
+			CreatedAt: e.Time,
+		}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return tx.Exec("SELECT pg_notify(?, ?)", objectEventsChannel, notifyPayload(ids)).Error
+}
+
+// OutboxConfig defines how the outbox is relayed. Zero values take the defaults.
+type OutboxConfig struct {
+	// PollInterval is the time between two checks for events to publish. Defaults to 1s.
+	PollInterval time.Duration
+	// BatchSize is the number of events published at once. Defaults to 100.
+	BatchSize int
+	// Retention is the time published events are kept before being deleted. Defaults to 24h.
+	Retention time.Duration
+	// Lease is the time a replica keeps publishing to a sink after it last renewed its lease,
+	// with every batch and while a batch is being published, before another one can take over.
+	// Defaults to 30s.
+	Lease time.Duration
+}
+
+func (cfg OutboxConfig) withDefaults() OutboxConfig {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 30 * time.Second
+	}
+	return cfg
+}
+
+// OutboxSink is a sink the outbox is published to. The events published to it are recorded
+// under its Name, so a failing sink doesn't hold the others back.
+type OutboxSink struct {
+	Name string
+	ObjectEventNotifier
+}
+
+// multiError reports the errors of several sinks at once.
+type multiError []error
+
+func (me multiError) Error() string {
+	msgs := make([]string, len(me))
+	for i, err := range me {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// OutboxRelay publishes the events of the outbox to its sinks, at least once and in the order they
+// were written, so the events of an object are never published out of order. Every sink has its
+// own progress: batches failing on a sink are published again to that sink only.
+type OutboxRelay struct {
+	db    *gorm.DB
+	cfg   OutboxConfig
+	sinks []OutboxSink
+	log   *log.Logger
+	// owner identifies the leases of the relay.
+	owner string
+
+	lastCleanup time.Time
+	*worker
+}
+
+// NewOutboxRelay returns the OutboxRelay publishing the outbox to sinks, and starts relaying it
+// in the background until it is closed. Every replica may run one, a single one relays to a sink
+// at a time.
+func NewOutboxRelay(db *gorm.DB, cfg OutboxConfig, sinks []OutboxSink, log *log.Logger) *OutboxRelay {
+	or := &OutboxRelay{
+		db:     db,
+		cfg:    cfg.withDefaults(),
+		sinks:  sinks,
+		log:    log,
+		owner:  newLeaseOwner(),
+		worker: newWorker(),
+	}
+	or.start(or.run)
+	return or
+}
+
+// run relays the outbox every PollInterval, and deletes the events published before the
+// retention once per retention.
+func (or *OutboxRelay) run(ctx context.Context) {
+	every(ctx, or.cfg.PollInterval, func(ctx context.Context) {
+		for {
+			n, err := or.Relay(ctx)
+			if err != nil && ctx.Err() == nil {
+				or.log.Printf("outbox_error: %v", err)
+			}
+			// Full batches may leave more events to publish.
+			if err != nil || n < or.cfg.BatchSize {
+				break
+			}
+		}
+
+		if time.Since(or.lastCleanup) >= or.cfg.Retention {
+			or.lastCleanup = time.Now()
+			if err := or.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				or.log.Printf("outbox_cleanup_error: %v", err)
+			}
+		}
+	})
+}
+
+// newLeaseOwner returns a random identifier of the leases of a relay.
+func newLeaseOwner() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Relay publishes to every sink the oldest batch of events not yet published to it, and returns
+// the size of the largest batch. Nothing is published to the sinks leased by another replica.
+// The errors of every sink failing are returned together.
+func (or *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "models.OutboxRelay.Relay")
+	defer span.End()
+
+	var (
+		largest int
+		errs    multiError
+	)
+	for _, sink := range or.sinks {
+		n, err := or.relay(ctx, sink)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", sink.Name, err))
+		}
+		if n > largest {
+			largest = n
+		}
+	}
+	if len(errs) > 0 {
+		return largest, fmt.Errorf("models: couldn't relay outbox %w", errs)
+	}
+	return largest, nil
+}
+
+// outboxLeaseSQL takes or renews the lease of the relay on a sink, unless another relay holds
+// it. No row is affected when the lease is held by another relay.
+const outboxLeaseSQL = `
+INSERT INTO outbox_leases (sink, owner, expires_at) VALUES (@sink, @owner, @expires)
+ON CONFLICT (sink) DO UPDATE SET owner = @owner, expires_at = @expires
+WHERE outbox_leases.owner = @owner OR outbox_leases.expires_at < @now`
+
+// takeLease takes or renews the lease of the relay on sink, and reports whether the relay holds
+// it.
+func (or *OutboxRelay) takeLease(ctx context.Context, sink string) (bool, error) {
+	now := time.Now()
+	res := or.db.WithContext(ctx).Exec(outboxLeaseSQL,
+		sql.Named("sink", sink),
+		sql.Named("owner", or.owner),
+		sql.Named("expires", now.Add(or.cfg.Lease)),
+		sql.Named("now", now),
+	)
+	if res.Error != nil {
+		return false, fmt.Errorf("taking lease %w", res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// holdLease renews the lease of the relay on sink every third of the lease, until the returned
+// func is called, so a sink slow to be told about a batch isn't taken over meanwhile. The returned
+// context is cancelled if the lease is lost anyway.
+func (or *OutboxRelay) holdLease(ctx context.Context, sink string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(or.cfg.Lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Failed renewals are tried again until the lease is over, and found lost then.
+			held, err := or.takeLease(ctx, sink)
+			if err == nil && !held {
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
+// relay publishes to sink the oldest batch of events not yet published to it, and returns its
+// size. No transaction is held while the sink is told about the events: the lease on the sink,
+// renewed meanwhile, keeps the other replicas from publishing to it.
+func (or *OutboxRelay) relay(ctx context.Context, sink OutboxSink) (int, error) {
+	db := or.db.WithContext(ctx)
+
+	held, err := or.takeLease(ctx, sink.Name)
+	if err != nil || !held {
+		return 0, err
+	}
+
+	var rows []OutboxEvent
+	err = db.Where("published_at IS NULL AND NOT EXISTS (SELECT 1 FROM outbox_published p WHERE p.sink = ? AND p.event_id = outbox_events.id)", sink.Name).
+		Order("id").Limit(or.cfg.BatchSize).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	events := make([]ObjectEvent, len(rows))
+	published := make([]outboxPublished, len(rows))
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal(row.Event, &events[i]); err != nil {
+			return 0, fmt.Errorf("decoding outbox event %d: %w", row.ID, err)
+		}
+		events[i].ID, ids[i] = row.ID, row.ID
+		published[i] = outboxPublished{Sink: sink.Name, EventID: row.ID}
+	}
+
+	nctx, release := or.holdLease(ctx, sink.Name)
+	err = sink.Notify(nctx, events)
+	release()
+	if err != nil {
+		outboxEvents.Add("errors", 1)
+		return 0, err
+	}
+
+	names := make([]string, len(or.sinks))
+	for i, s := range or.sinks {
+		names[i] = s.Name
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&published).Error; err != nil {
+			return err
+		}
+		// Events published to every sink are done with.
+		res := tx.Exec(`UPDATE outbox_events SET published_at = ? WHERE id IN ?
+			AND (SELECT count(*) FROM outbox_published p WHERE p.event_id = outbox_events.id AND p.sink IN ?) = ?`,
+			time.Now(), ids, names, len(names))
+		if res.Error != nil {
+			return res.Error
+		}
+		outboxEvents.Add("published", res.RowsAffected)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("recording published events %w", err)
+	}
+	return len(rows), nil
+}
+
+// Cleanup deletes the events published before the retention.
+func (or *OutboxRelay) Cleanup(ctx context.Context) error {
+	ctx, span := trace.StartSpan(ctx, "models.OutboxRelay.Cleanup")
+	defer span.End()
+
+	before := time.Now().Add(-or.cfg.Retention)
+	var deleted int64
+	err := or.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM outbox_published WHERE event_id IN (SELECT id FROM outbox_events WHERE published_at < ?)", before).Error
+		if err != nil {
+			return err
+		}
+		res := tx.Where("published_at < ?", before).Delete(&OutboxEvent{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return fmt.Errorf("models: couldn't clean up outbox %w", err)
+	}
+	outboxEvents.Add("deleted", deleted)
+	return nil
+}
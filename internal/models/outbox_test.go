@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSink is an ObjectEventNotifier keeping the events it is told about, failing with err. It
+// takes delay to be told about them.
+type testSink struct {
+	mu     sync.Mutex
+	err    error
+	delay  time.Duration
+	events []ObjectEvent
+}
+
+func (t *testSink) Notify(ctx context.Context, events []ObjectEvent) error {
+	time.Sleep(t.delay)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+func TestMultiError(t *testing.T) {
+	err := fmt.Errorf("models: couldn't relay outbox %w", multiError{errors.New("sink file: disk full"), errors.New("sink webhook: timeout")})
+	assert.EqualError(t, err, "models: couldn't relay outbox sink file: disk full; sink webhook: timeout")
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Second).UTC()
+	var events []ObjectEvent
+	for _, typ := range []string{EventObjectOnline, EventObjectOffline, EventObjectOnline} {
+		events = append(events, ObjectEvent{Type: typ, Time: now, Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 1}})
+	}
+	assert.NoError(t, writeOutbox(db, events))
+
+	failing, ok := &testSink{err: errors.New("sink down")}, &testSink{}
+	cfg := OutboxConfig{BatchSize: 2, Retention: time.Millisecond}.withDefaults()
+	or := &OutboxRelay{db: db, cfg: cfg, owner: newLeaseOwner(), sinks: []OutboxSink{{"failing", failing}, {"ok", ok}}}
+
+	n, err := or.Relay(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n, "sinks are not held back by the failing ones")
+	assert.Len(t, ok.events, 2)
+	assert.Empty(t, failing.events, "failed batches are published again")
+
+	other := &OutboxRelay{db: db, cfg: cfg, owner: newLeaseOwner(), sinks: or.sinks}
+	n, err = other.Relay(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n, "sinks are leased to a single relay")
+
+	failing.err = nil
+	for _, want := range []int{2, 1, 0} {
+		n, err := or.Relay(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+	for _, sink := range []*testSink{failing, ok} {
+		if assert.Len(t, sink.events, len(events)) {
+			for i, e := range sink.events {
+				assert.Greater(t, e.ID, int64(0), "events carry their outbox ID")
+				if i > 0 {
+					assert.Greater(t, e.ID, sink.events[i-1].ID, "events are published in order")
+				}
+				e.ID = 0
+				assert.Equal(t, events[i], e)
+			}
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, or.Cleanup(ctx))
+	var count int64
+	db.Model(&OutboxEvent{}).Count(&count)
+	assert.Zero(t, count)
+	db.Model(&outboxPublished{}).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestOutboxRelay_Relay_slowSink(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+	ctx := context.Background()
+
+	events := []ObjectEvent{{Type: EventObjectOnline, Time: time.Now(), Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 1}}}
+	assert.NoError(t, writeOutbox(db, events))
+
+	slow := &testSink{delay: 200 * time.Millisecond}
+	cfg := OutboxConfig{Lease: 60 * time.Millisecond}.withDefaults()
+	or := &OutboxRelay{db: db, cfg: cfg, owner: newLeaseOwner(), sinks: []OutboxSink{{"slow", slow}}}
+	other := &OutboxRelay{db: db, cfg: cfg, owner: newLeaseOwner(), sinks: or.sinks}
+
+	relayed := make(chan int)
+	go func() {
+		n, err := or.Relay(ctx)
+		assert.NoError(t, err)
+		relayed <- n
+	}()
+
+	// The lease is renewed while the sink is told about the batch, however long it takes.
+	time.Sleep(150 * time.Millisecond)
+	held, err := other.takeLease(ctx, "slow")
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	assert.Equal(t, 1, <-relayed)
+	slow.mu.Lock()
+	assert.Len(t, slow.events, 1)
+	slow.mu.Unlock()
+}
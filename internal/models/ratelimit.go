@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+)
+
+// rateLimitSweepInterval is how often the buckets that were not used for a while are removed.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitService keeps the token buckets limiting the rate of the requests of every client.
+type RateLimitService interface {
+	// Allow takes a token from the bucket identified by key, refilled as defined by limit. The
+	// request is allowed if a token was available.
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimit defines a token bucket. A zero Rate disables the limit.
+type RateLimit struct {
+	// Rate is the number of tokens added to the bucket every second.
+	Rate float64
+	// Burst is the capacity of the bucket, the number of requests allowed at once.
+	Burst int
+}
+
+// Enabled reports whether the limit must be applied.
+func (rl RateLimit) Enabled() bool {
+	return rl.Rate > 0 && rl.Burst > 0
+}
+
+// RateLimitResult is the state of a bucket after a token was requested from it.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left on the bucket.
+	Remaining int
+	// RetryAfter is the time until a token is available, zero if there is one.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// take computes the result of taking a token from a bucket holding tokens, and the tokens left.
+func (rl RateLimit) take(tokens float64) (RateLimitResult, float64) {
+	res := RateLimitResult{Allowed: tokens >= 1}
+	if res.Allowed {
+		tokens--
+	} else {
+		res.RetryAfter = rl.duration(1 - tokens)
+	}
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = rl.duration(float64(rl.Burst) - tokens)
+	return res, tokens
+}
+
+// duration returns the time needed to add n tokens to the bucket.
+func (rl RateLimit) duration(n float64) time.Duration {
+	return time.Duration(n / rl.Rate * float64(time.Second))
+}
+
+// NewMemoryRateLimitService returns a RateLimitService keeping the buckets in memory. Every
+// replica of the service applies the limits on its own.
+func NewMemoryRateLimitService() RateLimitService {
+	return &rateLimitMemory{buckets: make(map[string]*tokenBucket)}
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+type rateLimitMemory struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// Allow refills the bucket of key for the time elapsed since it was last used and takes a token.
+func (rm *rateLimitMemory) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.sweep(now)
+
+	b, ok := rm.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		rm.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	res, tokens := limit.take(b.tokens)
+	b.tokens = tokens
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep removes the buckets that are full, as they are the same as a missing bucket.
+func (rm *rateLimitMemory) sweep(now time.Time) {
+	if now.Sub(rm.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rm.lastSweep = now
+
+	for key, b := range rm.buckets {
+		if now.After(b.full) {
+			delete(rm.buckets, key)
+		}
+	}
+}
+
+// rateLimitBucket stores a token bucket shared by every replica of the service.
+type rateLimitBucket struct {
+	Key       string    `gorm:"primary_key;type:varchar(640)"`
+	Tokens    float64   `gorm:"not null"`
+	Allowed   bool      `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// NewRateLimitService returns a RateLimitService keeping the buckets on the database, so the
+// limits are shared by every replica of the service.
+func NewRateLimitService(db *gorm.DB, log *log.Logger) RateLimitService {
+	return &rateLimitGorm{db: db, log: log}
+}
+
+type rateLimitGorm struct {
+	db  *gorm.DB
+	log *log.Logger
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// rateLimitAllowSQL refills and takes a token from a bucket in a single statement, so concurrent
+// requests from several replicas can't take the same token. The refilled token count is repeated
+// as the statement can't name it.
+const rateLimitAllowSQL = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (@key, @burst - 1, true, now())
+ON CONFLICT (key) DO UPDATE SET
+	allowed = LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * @rate) >= 1,
+	tokens = LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * @rate) -
+		CASE WHEN LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * @rate) >= 1 THEN 1 ELSE 0 END,
+	updated_at = now()
+RETURNING tokens, allowed`
+
+// Allow takes a token from the bucket stored for key.
+func (rg *rateLimitGorm) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	ctx, span := trace.StartSpan(ctx, "models.rateLimitGorm.Allow")
+	defer span.End()
+
+	rg.sweep(ctx)
+
+	var b rateLimitBucket
+	err := rg.db.WithContext(ctx).Raw(rateLimitAllowSQL,
+		sql.Named("key", key),
+		sql.Named("burst", limit.Burst),
+		sql.Named("rate", limit.Rate),
+	).Scan(&b).Error
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("models: couldn't take rate limit token %w", err)
+	}
+
+	// The token, if any, was already taken: the result is computed from the tokens before it.
+	before := b.Tokens
+	if b.Allowed {
+		before++
+	}
+	res, _ := limit.take(before)
+	return res, nil
+}
+
+// sweep removes in the background the buckets not used for an hour. Buckets refilled at any
+// useful rate are full by then.
+func (rg *rateLimitGorm) sweep(ctx context.Context) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	if time.Since(rg.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rg.lastSweep = time.Now()
+
+	bctx := detach(ctx)
+	go func() {
+		err := rg.db.WithContext(bctx).
+			Where("updated_at < ?", time.Now().Add(-time.Hour)).
+			Delete(&rateLimitBucket{}).Error
+		if err != nil {
+			rg.log.Printf("rate_limit_sweep_error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit_take(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 4}
+
+	res, tokens := limit.take(4)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 3, Reset: 500 * time.Millisecond}, res)
+	assert.Equal(t, 3.0, tokens)
+
+	res, tokens = limit.take(0.5)
+	assert.Equal(t, RateLimitResult{Allowed: false, Remaining: 0, RetryAfter: 250 * time.Millisecond, Reset: 1750 * time.Millisecond}, res)
+	assert.Equal(t, 0.5, tokens)
+}
+
+func TestRateLimitMemory_Allow(t *testing.T) {
+	rls := NewMemoryRateLimitService()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	for i, allowed := range []bool{true, true, false} {
+		res, err := rls.Allow(ctx, "client", limit)
+		assert.NoError(t, err)
+		assert.Equal(t, allowed, res.Allowed, "request %d", i)
+	}
+
+	// Buckets are independent.
+	res, err := rls.Allow(ctx, "other", limit)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// NewWriterSink returns the ObjectEventNotifier writing the events to w, one JSON document per
+// line. It is meant to publish the outbox to the standard output.
+func NewWriterSink(w io.Writer) ObjectEventNotifier {
+	return &writerSink{w: w}
+}
+
+// NewFileSink returns the ObjectEventNotifier appending the events to the file at path, one JSON
+// document per line. Events are synced to disk before Notify returns.
+func NewFileSink(path string) (ObjectEventNotifier, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("models: opening event file %w", err)
+	}
+	return &writerSink{w: f, sync: f.Sync}, nil
+}
+
+type writerSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	sync func() error
+}
+
+// Notify writes events in a single write, so the lines of concurrent calls don't interleave.
+func (ws *writerSink) Notify(ctx context.Context, events []ObjectEvent) error {
+	var buf []byte
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("models: encoding object event %w", err)
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, err := ws.w.Write(buf); err != nil {
+		return fmt.Errorf("models: writing object events %w", err)
+	}
+	if ws.sync != nil {
+		if err := ws.sync(); err != nil {
+			return fmt.Errorf("models: syncing object events %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	err := sink.Notify(context.Background(), []ObjectEvent{
+		{Type: EventObjectOnline, Time: now, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 1, Online: true}},
+		{Type: EventObjectExpired, Time: now, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 2}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"object.online","time":"2021-06-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":1,"online":true,"timestamp":0}}
+{"type":"object.expired","time":"2021-06-01T00:00:00Z","tenant":"acme","object":{"tenant":"acme","id":2,"online":false,"timestamp":0}}
+`, buf.String())
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("{}\n"), 0o644))
+
+	sink, err := NewFileSink(path)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Notify(context.Background(), []ObjectEvent{{Type: EventObjectOffline, Tenant: "acme"}}))
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n"+`{"type":"object.offline","time":"0001-01-01T00:00:00Z","tenant":"acme","object":{"tenant":"","id":0,"online":false,"timestamp":0}}`+"\n",
+		string(b), "events are appended")
+
+	_, err = NewFileSink(filepath.Join(path, "events.ndjson"))
+	assert.Error(t, err)
+}
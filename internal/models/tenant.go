@@ -0,0 +1,196 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultTenant owns the callbacks that are not sent on behalf of any other tenant. It does
+	// not need to be stored, every setting not stored for it takes the service default.
+	DefaultTenant = "default"
+
+	// tenantCacheTTL is the time the settings of a tenant are kept in memory.
+	tenantCacheTTL = 30 * time.Second
+)
+
+// tenantIDPattern defines the valid tenant IDs. IDs are used on URLs and log lines.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// TenantService defines how the tenants sharing the service are managed.
+type TenantService interface {
+	// Get returns the settings of the tenant identified by id. The DefaultTenant is always found.
+	Get(ctx context.Context, id string) (Tenant, error)
+
+	// Save creates or replaces a tenant.
+	Save(ctx context.Context, t Tenant) error
+
+	// List returns every stored tenant.
+	List(ctx context.Context) ([]Tenant, error)
+
+	// Delete removes the tenant identified by id. Its objects are left to expire.
+	Delete(ctx context.Context, id string) error
+}
+
+// Tenant holds the settings of a team hosting its objects on the service. Zero values fall back
+// to the service defaults.
+type Tenant struct {
+	ID string `gorm:"primary_key;type:varchar(64)" json:"id"`
+	// ObjectServiceURL is the address of the service queried for the status of the tenant objects.
+	ObjectServiceURL string `gorm:"type:varchar(2048);not null;default:''" json:"object_service_url"`
+	// Retention is the time an object is kept after it was last seen online.
+	Retention time.Duration `gorm:"type:bigint;not null;default:0" json:"retention"`
+	// MaxObjectsPerCallback limits the number of distinct object IDs of a single callback.
+	MaxObjectsPerCallback int       `gorm:"not null;default:0" json:"max_objects_per_callback"`
+	CreatedAt             time.Time `gorm:"not null" json:"created_at"`
+}
+
+// ValidTenantID reports whether id can identify a tenant.
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// NewTenantService returns a TenantService storing the tenants on the database. Tenants are
+// cached for a short time, so updates may take some seconds to be seen by every replica.
+func NewTenantService(db *gorm.DB) TenantService {
+	return &tenantCache{
+		TenantService: &tenantGorm{db},
+		entries:       make(map[string]tenantCacheEntry),
+	}
+}
+
+type tenantCacheEntry struct {
+	tenant  Tenant
+	err     error
+	expires time.Time
+}
+
+// tenantCache keeps the result of the tenant lookups in memory, as they happen on every request.
+type tenantCache struct {
+	TenantService
+
+	mu      sync.Mutex
+	entries map[string]tenantCacheEntry
+}
+
+// Get returns the cached tenant, looking it up when missing or expired. Lookups failing with
+// ErrNotFound are cached too.
+func (tc *tenantCache) Get(ctx context.Context, id string) (Tenant, error) {
+	now := time.Now()
+
+	tc.mu.Lock()
+	e, ok := tc.entries[id]
+	tc.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.tenant, e.err
+	}
+
+	t, err := tc.TenantService.Get(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return Tenant{}, err
+	}
+
+	tc.mu.Lock()
+	tc.entries[id] = tenantCacheEntry{tenant: t, err: err, expires: now.Add(tenantCacheTTL)}
+	tc.mu.Unlock()
+
+	return t, err
+}
+
+// Save stores t and drops it from the cache.
+func (tc *tenantCache) Save(ctx context.Context, t Tenant) error {
+	tc.forget(t.ID)
+	return tc.TenantService.Save(ctx, t)
+}
+
+// Delete removes the tenant and drops it from the cache.
+func (tc *tenantCache) Delete(ctx context.Context, id string) error {
+	tc.forget(id)
+	return tc.TenantService.Delete(ctx, id)
+}
+
+func (tc *tenantCache) forget(id string) {
+	tc.mu.Lock()
+	delete(tc.entries, id)
+	tc.mu.Unlock()
+}
+
+type tenantGorm struct {
+	db *gorm.DB
+}
+
+// Get finds the tenant by its ID.
+func (tg *tenantGorm) Get(ctx context.Context, id string) (Tenant, error) {
+	ctx, span := trace.StartSpan(ctx, "models.tenantGorm.Get")
+	defer span.End()
+
+	var t Tenant
+	err := tg.db.WithContext(ctx).Where("id = ?", id).First(&t).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound) && id == DefaultTenant:
+		return Tenant{ID: DefaultTenant}, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return Tenant{}, ErrNotFound
+	case err != nil:
+		return Tenant{}, fmt.Errorf("models: couldn't find tenant %w", err)
+	}
+
+	return t, nil
+}
+
+// Save inserts t, replacing the settings of a tenant with the same ID.
+func (tg *tenantGorm) Save(ctx context.Context, t Tenant) error {
+	ctx, span := trace.StartSpan(ctx, "models.tenantGorm.Save")
+	defer span.End()
+
+	if !ValidTenantID(t.ID) {
+		return ErrInvalidTenant
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+
+	err := tg.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"object_service_url", "retention", "max_objects_per_callback"}),
+	}).Create(&t).Error
+	if err != nil {
+		return fmt.Errorf("models: couldn't save tenant %w", err)
+	}
+	return nil
+}
+
+// List returns every tenant ordered by ID.
+func (tg *tenantGorm) List(ctx context.Context) ([]Tenant, error) {
+	ctx, span := trace.StartSpan(ctx, "models.tenantGorm.List")
+	defer span.End()
+
+	var ts []Tenant
+	if err := tg.db.WithContext(ctx).Order("id").Find(&ts).Error; err != nil {
+		return nil, fmt.Errorf("models: couldn't list tenants %w", err)
+	}
+	return ts, nil
+}
+
+// Delete removes the tenant identified by id.
+func (tg *tenantGorm) Delete(ctx context.Context, id string) error {
+	ctx, span := trace.StartSpan(ctx, "models.tenantGorm.Delete")
+	defer span.End()
+
+	res := tg.db.WithContext(ctx).Where("id = ?", id).Delete(&Tenant{})
+	if res.Error != nil {
+		return fmt.Errorf("models: couldn't delete tenant %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"strings"
+
+	"github.com/noelruault/go-callback-service/internal/objectservice"
+)
+
+// DefaultUpstream names the object service of the objects no route sends elsewhere: the service
+// of their tenant, or the one of Upstream.URL.
+const DefaultUpstream = "default"
+
+// tenantUpstreamPrefix prefixes the names of the object services of the tenants, followed by the
+// tenant ID: "tenant:acme".
+const tenantUpstreamPrefix = "tenant:"
+
+// IsTenantUpstream reports whether name is the name of the object service of a tenant, which
+// named services can't take.
+func IsTenantUpstream(name string) bool {
+	return strings.HasPrefix(name, tenantUpstreamPrefix)
+}
+
+// Upstream defines how the object services are queried for the status of the objects.
+type Upstream struct {
+	// Client sends the requests to the default upstream. A nil Client is replaced by one with the
+	// default settings.
+	Client objectservice.Client
+	// URL is the address of the object service of the tenants with no service of their own.
+	URL string
+	// TenantClient sends the requests to the object services of the tenants. The tenants pick
+	// these addresses, so it must carry none of the credentials of Client, which are only meant
+	// for URL. A nil TenantClient is replaced by one with the default settings.
+	TenantClient objectservice.Client
+	// Limiter bounds the lookups in flight toward the default upstream. The named services, and
+	// the services of the tenants, have their own limiter, tuned like this one. A nil Limiter
+	// doesn't bound them.
+	Limiter *ConcurrencyLimiter
+	// Limiters is given the limiter of every upstream, by name, the ones of the services of the
+	// tenants as they are first used. A nil Limiters records none.
+	Limiters *LimiterRegistry
+	// Hedge defines when slow lookups are sent again. Every upstream hedges its lookups from its
+	// own latencies.
+	Hedge HedgeConfig
+	// BatchSize is the number of objects looked up with a single request, on the services
+	// serving batches. Values below 2 disable batches.
+	BatchSize int
+
+	// Services are the named object services the objects can be routed to.
+	Services []UpstreamService
+	// Routes pick the service of the objects of the tenants with no service of their own. They
+	// are tried in order and the first matching one is used. Objects matching none, or routed to
+	// an unknown service, go to the default upstream.
+	Routes []UpstreamRoute
+}
+
+// UpstreamService is a named object service. Services have their own client, so their own
+// timeouts, credentials and health tracking, and their own concurrency limit.
+type UpstreamService struct {
+	Name string
+	URL  string
+	// Client sends the requests to the service. A nil Client is replaced by one with the default
+	// settings.
+	Client objectservice.Client
+	// Limiter bounds the lookups in flight toward the service. A nil Limiter is replaced by one
+	// tuned like Upstream.Limiter.
+	Limiter *ConcurrencyLimiter
+}
+
+// UpstreamRoute sends the objects of Tenant matching every other field it sets to the service
+// named Service. Routes only apply to the objects of their tenant: the source is named by the
+// clients, so it can't pick the service of the objects of another tenant.
+type UpstreamRoute struct {
+	Service string
+	// Tenant is the tenant whose objects are routed.
+	Tenant string
+	// Source matches the objects of the callbacks naming it as their source.
+	Source string
+	// MinID and MaxID match the objects within an inclusive ID range. Zero leaves a bound open.
+	MinID int64
+	MaxID int64
+}
+
+// matches reports whether c is sent along r.
+func (r UpstreamRoute) matches(c Callback) bool {
+	switch {
+	case r.Tenant != tenantOf(c):
+		return false
+	case r.Source != "" && r.Source != c.Source:
+		return false
+	case r.MinID != 0 && c.ID < r.MinID:
+		return false
+	case r.MaxID != 0 && c.ID > r.MaxID:
+		return false
+	}
+	return true
+}
+
+// upstream is an object service the status of an object is looked up on. Every upstream bounds
+// and hedges its lookups from its own latencies.
+type upstream struct {
+	name    string
+	url     string
+	client  objectservice.Client
+	limiter *ConcurrencyLimiter
+	hedger  *hedger
+}
+
+// route returns the object service c is looked up on: the object service of the tenant of c if
+// it has one, else the service of the first route matching c, else the default upstream.
+func (cv *callbackValidator) route(ctx context.Context, c Callback) (upstream, error) {
+	url, err := cv.tenantServiceURL(ctx, tenantOf(c))
+	if err != nil {
+		return upstream{}, err
+	}
+	if url != "" {
+		return cv.tenantUpstream(tenantOf(c), url), nil
+	}
+
+	for _, r := range cv.routes {
+		if !r.matches(c) {
+			continue
+		}
+		if up, ok := cv.services[r.Service]; ok {
+			return up, nil
+		}
+	}
+
+	return upstream{
+		name:    DefaultUpstream,
+		url:     cv.serviceURL,
+		client:  cv.client,
+		limiter: cv.limiter,
+		hedger:  cv.hedger,
+	}, nil
+}
+
+// tenantServiceURL returns the address of the object service of tenant, or an empty string if it
+// has none of its own.
+func (cv *callbackValidator) tenantServiceURL(ctx context.Context, tenant string) (string, error) {
+	if cv.tenants == nil {
+		return "", nil
+	}
+
+	t, err := cv.tenants.Get(ctx, tenant)
+	if err != nil {
+		return "", err
+	}
+	return t.ObjectServiceURL, nil
+}
+
+// tenantUpstream returns the upstream of the object service of tenant, at url, named after the
+// tenant. It is created on first use, and again when the tenant moves it, with a limiter and a
+// hedger of its own, and is sent no credentials.
+func (cv *callbackValidator) tenantUpstream(tenant, url string) upstream {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	if up, ok := cv.tenantUpstreams[tenant]; ok && up.url == url {
+		return up
+	}
+	if cv.tenantUpstreams == nil {
+		cv.tenantUpstreams = make(map[string]upstream)
+	}
+	up := upstream{
+		name:    tenantUpstreamPrefix + tenant,
+		url:     url,
+		client:  cv.tenantClient,
+		limiter: cv.limiter.like(),
+		hedger:  newHedger(cv.hedge),
+	}
+	cv.tenantUpstreams[tenant] = up
+	cv.limiters.register(up.name, up.limiter)
+	return up
+}
+
+// newUpstreams returns the named services of u by name, each with its own limiter and hedger,
+// registered on u.Limiters.
+func newUpstreams(u Upstream) map[string]upstream {
+	services := make(map[string]upstream, len(u.Services))
+	for _, s := range u.Services {
+		client := s.Client
+		if client == nil {
+			client = objectservice.New(objectservice.Config{})
+		}
+		limiter := s.Limiter
+		if limiter == nil {
+			limiter = u.Limiter.like()
+		}
+		services[s.Name] = upstream{
+			name:    s.Name,
+			url:     s.URL,
+			client:  client,
+			limiter: limiter,
+			hedger:  newHedger(u.Hedge),
+		}
+		u.Limiters.register(s.Name, limiter)
+	}
+	return services
+}
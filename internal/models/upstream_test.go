@@ -0,0 +1,259 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/objectservice"
+)
+
+// testObjectClient is an objectservice.Client serving the objects it holds, in batches if batch
+// is set.
+type testObjectClient struct {
+	objects map[int64]objectservice.Object
+	err     error
+	healthy bool
+	batch   bool
+
+	batches int32
+	singles int32
+}
+
+func (t *testObjectClient) Object(ctx context.Context, baseURL string, id int64) (objectservice.Object, error) {
+	if t.err != nil {
+		return objectservice.Object{}, t.err
+	}
+	atomic.AddInt32(&t.singles, 1)
+	o, ok := t.objects[id]
+	if !ok {
+		return objectservice.Object{}, objectservice.ErrObjectGone
+	}
+	return o, nil
+}
+
+func (t *testObjectClient) Objects(ctx context.Context, baseURL string, ids []int64) ([]objectservice.Object, error) {
+	if !t.batch {
+		return nil, objectservice.ErrBatchUnsupported
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	atomic.AddInt32(&t.batches, 1)
+
+	var found []objectservice.Object
+	for _, id := range ids {
+		if o, ok := t.objects[id]; ok {
+			found = append(found, o)
+		}
+	}
+	return found, nil
+}
+
+func (t *testObjectClient) Healthy(baseURL string) bool { return t.healthy }
+
+// testTenants is a TenantService serving the tenants it holds, and the default tenant.
+type testTenants struct {
+	TenantService
+	tenants map[string]Tenant
+}
+
+func (t testTenants) Get(ctx context.Context, id string) (Tenant, error) {
+	if tn, ok := t.tenants[id]; ok {
+		return tn, nil
+	}
+	if id == DefaultTenant {
+		return Tenant{ID: DefaultTenant}, nil
+	}
+	return Tenant{}, ErrNotFound
+}
+
+func TestCallbackValidator_Status(t *testing.T) {
+	client := &testObjectClient{objects: map[int64]objectservice.Object{
+		7: {ID: 7, Online: true, Raw: json.RawMessage(`{"id":7,"online":true,"region":"eu"}`)},
+	}}
+	cv := &callbackValidator{client: client}
+
+	c, err := cv.Status(context.Background(), Callback{Tenant: "acme", ID: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, Callback{
+		Tenant:   "acme",
+		ID:       7,
+		Online:   true,
+		Payload:  JSONB(`{"id":7,"online":true,"region":"eu"}`),
+		Upstream: DefaultUpstream,
+	}, c)
+
+	client.err = objectservice.ErrInvalidResponse
+	_, err = cv.Status(context.Background(), Callback{ID: 7})
+	assert.Equal(t, ErrInvalidJSONInput, err)
+
+	client.err = objectservice.ErrServerError
+	_, err = cv.Status(context.Background(), Callback{ID: 7})
+	assert.True(t, errors.Is(err, objectservice.ErrServerError))
+
+	client.err = objectservice.ErrObjectGone
+	c, err = cv.Status(context.Background(), Callback{Tenant: "acme", ID: 7})
+	assert.NoError(t, err, "objects gone from the upstream are offline")
+	assert.Equal(t, Callback{Tenant: "acme", ID: 7, Upstream: DefaultUpstream}, c)
+}
+
+func TestCallbackValidator_Upsert_unhealthy(t *testing.T) {
+	cv := &callbackValidator{client: &testObjectClient{healthy: false}}
+
+	err := cv.Upsert(context.Background(), []Callback{{ID: 1}})
+	assert.Equal(t, ErrServerNotReachable, err)
+}
+
+func TestCallbackValidator_statuses(t *testing.T) {
+	objects := map[int64]objectservice.Object{
+		1: {ID: 1, Online: true},
+		2: {ID: 2, Online: false},
+		3: {ID: 3, Online: true},
+	}
+	cs := []Callback{{Tenant: "acme", ID: 1}, {Tenant: "acme", ID: 2}, {Tenant: "acme", ID: 3}, {Tenant: "acme", ID: 4}}
+	// Objects unknown to the upstream are offline.
+	want := []Callback{
+		{Tenant: "acme", ID: 1, Online: true, Upstream: DefaultUpstream},
+		{Tenant: "acme", ID: 2, Online: false, Upstream: DefaultUpstream},
+		{Tenant: "acme", ID: 3, Online: true, Upstream: DefaultUpstream},
+		{Tenant: "acme", ID: 4, Online: false, Upstream: DefaultUpstream},
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		client := &testObjectClient{objects: objects, batch: true}
+		cv := &callbackValidator{client: client}
+
+		fetched, errs := cv.statuses(context.Background(), upstream{name: DefaultUpstream, client: client}, cs)
+		assert.Empty(t, errs)
+		assert.Equal(t, want, fetched)
+		assert.Equal(t, int32(1), client.batches)
+		assert.Equal(t, int32(0), client.singles)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		client := &testObjectClient{objects: objects}
+		cv := &callbackValidator{client: client}
+
+		fetched, errs := cv.statuses(context.Background(), upstream{name: DefaultUpstream, client: client}, cs)
+		assert.Empty(t, errs)
+		sort.Slice(fetched, func(i, j int) bool { return fetched[i].ID < fetched[j].ID })
+		assert.Equal(t, want, fetched)
+		assert.Equal(t, int32(4), client.singles)
+	})
+
+	t.Run("batchError", func(t *testing.T) {
+		client := &testObjectClient{objects: objects, batch: true, err: objectservice.ErrServerError}
+		cv := &callbackValidator{client: client}
+
+		fetched, errs := cv.statuses(context.Background(), upstream{name: DefaultUpstream, client: client}, cs)
+		assert.Empty(t, fetched)
+		assert.Len(t, errs, 1)
+	})
+}
+
+func TestCallbackValidator_route(t *testing.T) {
+	limiter := NewConcurrencyLimiter(ConcurrencyLimitConfig{Initial: 4, Min: 1, Max: 8})
+	limiters := NewLimiterRegistry()
+	client, tenantClient := &testObjectClient{}, &testObjectClient{}
+	cv := &callbackValidator{
+		tenants: testTenants{tenants: map[string]Tenant{
+			"acme":    {ID: "acme"},
+			"globex":  {ID: "globex"},
+			"initech": {ID: "initech", ObjectServiceURL: "http://initech"},
+		}},
+		client:       client,
+		tenantClient: tenantClient,
+		limiter:      limiter,
+		serviceURL:   "http://objects",
+		limiters:     limiters,
+		services: newUpstreams(Upstream{Limiter: limiter, Limiters: limiters, Services: []UpstreamService{
+			{Name: "catalog", URL: "http://catalog", Client: &testObjectClient{}},
+			{Name: "legacy", URL: "http://legacy", Client: &testObjectClient{}},
+		}}),
+		routes: []UpstreamRoute{
+			{Service: "legacy", Tenant: DefaultTenant, MinID: 1000, MaxID: 1999},
+			{Service: "catalog", Tenant: "acme"},
+			{Service: "catalog", Tenant: DefaultTenant, Source: "catalog-feed"},
+			{Service: "missing", Tenant: "globex"},
+			{Service: "catalog", Tenant: "initech"},
+		},
+	}
+
+	var cases = []struct {
+		name     string
+		callback Callback
+		outName  string
+		outURL   string
+	}{
+		{"default", Callback{ID: 1}, DefaultUpstream, "http://objects"},
+		{"idRange", Callback{ID: 1500}, "legacy", "http://legacy"},
+		{"idRangeBound", Callback{ID: 1999}, "legacy", "http://legacy"},
+		{"idRangeOtherTenant", Callback{Tenant: "globex", ID: 1500}, DefaultUpstream, "http://objects"},
+		{"tenant", Callback{Tenant: "acme", ID: 2000}, "catalog", "http://catalog"},
+		{"source", Callback{ID: 2, Source: "catalog-feed"}, "catalog", "http://catalog"},
+		{"sourceOtherTenant", Callback{Tenant: "globex", ID: 2, Source: "catalog-feed"}, DefaultUpstream, "http://objects"},
+		{"unknownService", Callback{Tenant: "globex", ID: 3}, DefaultUpstream, "http://objects"},
+		{"tenantService", Callback{Tenant: "initech", ID: 4}, "tenant:initech", "http://initech"},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			up, err := cv.route(context.Background(), cs.callback)
+			assert.NoError(t, err)
+			assert.Equal(t, cs.outName, up.name)
+			assert.Equal(t, cs.outURL, up.url)
+		})
+	}
+
+	// Every upstream has its own limiter.
+	def, _ := cv.route(context.Background(), Callback{ID: 1})
+	catalog, _ := cv.route(context.Background(), Callback{Tenant: "acme", ID: 1})
+	legacy, _ := cv.route(context.Background(), Callback{ID: 1000})
+	tenant, _ := cv.route(context.Background(), Callback{Tenant: "initech", ID: 1})
+	again, _ := cv.route(context.Background(), Callback{Tenant: "initech", ID: 2})
+	assert.Same(t, limiter, def.limiter)
+	for _, up := range []upstream{catalog, legacy, tenant} {
+		assert.NotNil(t, up.limiter)
+		assert.NotSame(t, limiter, up.limiter, up.url)
+	}
+	assert.NotSame(t, catalog.limiter, legacy.limiter)
+	assert.Same(t, tenant.limiter, again.limiter, "the upstreams of the tenants are kept")
+
+	// The limiters of the services of the tenants are registered once they are used.
+	ls := limiters.Limiters()
+	assert.Len(t, ls, 3)
+	assert.Same(t, catalog.limiter, ls["catalog"])
+	assert.Same(t, legacy.limiter, ls["legacy"])
+	assert.Same(t, tenant.limiter, ls["tenant:initech"])
+
+	// A tenant moving its service gets a new upstream, under the same name.
+	cv.tenants = testTenants{tenants: map[string]Tenant{"initech": {ID: "initech", ObjectServiceURL: "http://initech-2"}}}
+	moved, _ := cv.route(context.Background(), Callback{Tenant: "initech", ID: 1})
+	assert.Equal(t, "http://initech-2", moved.url)
+	assert.NotSame(t, tenant.limiter, moved.limiter)
+	assert.Same(t, moved.limiter, limiters.Limiters()["tenant:initech"])
+
+	// The services of the tenants are not sent the credentials of the default upstream.
+	assert.Same(t, client, def.client)
+	assert.Same(t, tenantClient, tenant.client)
+}
+
+func TestCallbackValidator_Status_routed(t *testing.T) {
+	catalog := &testObjectClient{objects: map[int64]objectservice.Object{5: {ID: 5, Online: true}}}
+	cv := &callbackValidator{
+		client:   &testObjectClient{err: objectservice.ErrServerError},
+		services: newUpstreams(Upstream{Services: []UpstreamService{{Name: "catalog", Client: catalog}}}),
+		routes:   []UpstreamRoute{{Service: "catalog", Tenant: DefaultTenant, Source: "catalog-feed"}},
+	}
+
+	c, err := cv.Status(context.Background(), Callback{ID: 5, Source: "catalog-feed"})
+	assert.NoError(t, err)
+	assert.Equal(t, Callback{Tenant: DefaultTenant, ID: 5, Online: true, Upstream: "catalog"}, c)
+	assert.Equal(t, int32(1), catalog.singles)
+}
@@ -0,0 +1,700 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// Headers sent with every webhook delivery, next to the web.SignatureHeader.
+const (
+	WebhookEventHeader    = "X-Webhook-Event"
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+	WebhookAttemptHeader  = "X-Webhook-Attempt"
+)
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookSecretBytes is the number of random bytes of a generated webhook secret.
+const webhookSecretBytes = 32
+
+// webhookDeliveries counts the delivery attempts by outcome: delivered, retried or failed, and the
+// deliveries deleted once done with.
+var webhookDeliveries = expvar.NewMap("webhook_deliveries")
+
+// WebhookService defines how the webhooks told about the object events of a tenant are managed.
+// Notify queues a delivery of every event to each subscription of its tenant accepting it, sent
+// in the background.
+type WebhookService interface {
+	ObjectEventNotifier
+
+	// Subscribe stores ws. A random secret is generated if ws has none.
+	Subscribe(ctx context.Context, ws WebhookSubscription) (WebhookSubscription, error)
+
+	// Subscriptions returns the subscriptions of tenant.
+	Subscriptions(ctx context.Context, tenant string) ([]WebhookSubscription, error)
+
+	// Unsubscribe deletes the subscription of tenant identified by id, and its deliveries.
+	Unsubscribe(ctx context.Context, tenant string, id int64) error
+
+	// Deliveries returns the latest deliveries of the subscription of tenant identified by id,
+	// with their attempts.
+	Deliveries(ctx context.Context, tenant string, id int64) ([]WebhookDelivery, error)
+
+	// Close stops sending the deliveries due.
+	Close()
+}
+
+// WebhookConfig defines how the webhook deliveries are sent. Zero values take the defaults.
+type WebhookConfig struct {
+	// Timeout limits every delivery attempt. Defaults to 10s.
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts after which a delivery fails. Defaults to 8.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt, doubled after every failed one up to
+	// MaxBackoff. Default to 10s and 1h.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is the time between two checks for the deliveries due. Defaults to 1s.
+	PollInterval time.Duration
+	// BatchSize is the number of deliveries sent concurrently per check. Defaults to 50.
+	BatchSize int
+	// Retention is the time delivered and failed deliveries are kept, with their attempts,
+	// before being deleted. Defaults to 7 days.
+	Retention time.Duration
+	// AllowPrivateNetworks lets webhooks target loopback, private and link-local addresses,
+	// which are refused otherwise so tenants can't reach the internal services.
+	AllowPrivateNetworks bool
+}
+
+func (cfg WebhookConfig) withDefaults() WebhookConfig {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 10 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Hour
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 7 * 24 * time.Hour
+	}
+	return cfg
+}
+
+// backoff returns the wait before the attempt following the failed attempt n.
+func (cfg WebhookConfig) backoff(n int) time.Duration {
+	d := cfg.Backoff
+	for i := 1; i < n && d < cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > cfg.MaxBackoff {
+		d = cfg.MaxBackoff
+	}
+	return d
+}
+
+// WebhookSubscription is a URL told about the object events of a tenant.
+type WebhookSubscription struct {
+	ID     int64  `gorm:"primary_key;type:bigserial" json:"id"`
+	Tenant string `gorm:"type:varchar(64);not null;index" json:"tenant"`
+	URL    string `gorm:"type:varchar(2048);not null" json:"url"`
+	// Events lists the types of the events sent. Empty means every type.
+	Events EventFilter `gorm:"type:varchar(255);not null;default:''" json:"events"`
+	// Secret signs the deliveries. It is only returned when the subscription is created.
+	Secret    string    `gorm:"type:varchar(255);not null" json:"secret,omitempty"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+// EventFilter is a list of object event types, stored comma separated.
+type EventFilter []string
+
+// Accepts reports whether events of type typ pass the filter.
+func (f EventFilter) Accepts(typ string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, t := range f {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements the driver.Valuer interface.
+func (f EventFilter) Value() (driver.Value, error) {
+	return strings.Join(f, ","), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (f *EventFilter) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("models: can't scan %T into an event filter", src)
+	}
+
+	*f = nil
+	if s != "" {
+		*f = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// validate checks the URL and the event types of ws.
+func (ws WebhookSubscription) validate() error {
+	u, err := url.Parse(ws.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	for _, t := range ws.Events {
+		valid := false
+		for _, known := range ObjectEventTypes {
+			valid = valid || t == known
+		}
+		if !valid {
+			return ErrInvalidEventType
+		}
+	}
+	return nil
+}
+
+// privateNetworks are the ranges webhooks can't target, next to the loopback, link-local and
+// unspecified addresses.
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip is an address webhooks can target.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvePublic resolves host and checks every address it resolves to is public. Hosts that
+// don't resolve are refused too.
+func resolvePublic(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return ErrForbiddenWebhookURL
+	case err != nil:
+		return fmt.Errorf("models: resolving webhook host %w", err)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return ErrForbiddenWebhookURL
+		}
+	}
+	return nil
+}
+
+// dialPublic is the net.Dialer Control refusing the connections to addresses that are not
+// public, checked once resolved so hosts resolving differently after being subscribed, and
+// redirects, are refused too.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return ErrForbiddenWebhookURL
+	}
+	return nil
+}
+
+// newWebhookClient returns the client sending the deliveries. Deliveries are not sent through
+// the proxies of the environment, so the addresses dialed are the ones of the webhooks.
+func newWebhookClient(cfg WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout, KeepAlive: 30 * time.Second}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = dialPublic
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// WebhookDelivery is an object event to be sent, or sent, to a subscription.
+type WebhookDelivery struct {
+	ID             int64      `gorm:"primary_key;type:bigserial" json:"id"`
+	SubscriptionID int64      `gorm:"not null;index;uniqueIndex:idx_webhook_deliveries_event,priority:1" json:"subscription_id"`
+	Tenant         string     `gorm:"type:varchar(64);not null" json:"tenant"`
+	Event          string     `gorm:"type:varchar(64);not null" json:"event"`
+	ObjectID       int64      `gorm:"type:bigint;not null" json:"object_id"`
+	Body           JSONB      `gorm:"type:jsonb;not null" json:"-"`
+	Status         string     `gorm:"type:varchar(16);not null;index" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null;index" json:"next_attempt_at"`
+	CreatedAt      time.Time  `gorm:"not null" json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+
+	// EventID is the outbox ID of the event, so it is queued once per subscription however many
+	// times it is notified.
+	EventID *int64 `gorm:"uniqueIndex:idx_webhook_deliveries_event,priority:2" json:"-"`
+
+	AttemptLog []WebhookAttempt `gorm:"-" json:"attempt_log"`
+}
+
+// WebhookAttempt records a single try to send a delivery.
+type WebhookAttempt struct {
+	ID         int64     `gorm:"primary_key;type:bigserial" json:"-"`
+	DeliveryID int64     `gorm:"not null;index" json:"-"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	StatusCode int       `gorm:"not null;default:0" json:"status_code,omitempty"`
+	Error      string    `gorm:"type:text;not null;default:''" json:"error,omitempty"`
+	DurationMS int64     `gorm:"not null" json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+}
+
+// deliveriesListed is the number of deliveries returned by Deliveries.
+const deliveriesListed = 100
+
+// NewWebhookService returns the WebhookService keeping the subscriptions and deliveries on the
+// database. The deliveries due are sent, and the ones done with deleted after the retention, by a
+// goroutine started here, shared with every replica, until the service is closed.
+func NewWebhookService(db *gorm.DB, cfg WebhookConfig, log *log.Logger) WebhookService {
+	cfg = cfg.withDefaults()
+	wg := &webhookGorm{
+		db:     db,
+		cfg:    cfg,
+		client: newWebhookClient(cfg),
+		log:    log,
+		worker: newWorker(),
+	}
+	wg.start(wg.run)
+	return wg
+}
+
+type webhookGorm struct {
+	db     *gorm.DB
+	cfg    WebhookConfig
+	client *http.Client
+	log    *log.Logger
+
+	lastCleanup time.Time
+	*worker
+}
+
+// Subscribe validates and stores ws. Unless AllowPrivateNetworks is set, its host must only
+// resolve to public addresses.
+func (wg *webhookGorm) Subscribe(ctx context.Context, ws WebhookSubscription) (WebhookSubscription, error) {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Subscribe")
+	defer span.End()
+
+	if ws.Tenant == "" {
+		ws.Tenant = DefaultTenant
+	}
+	if err := ws.validate(); err != nil {
+		return WebhookSubscription{}, err
+	}
+	if !wg.cfg.AllowPrivateNetworks {
+		u, _ := url.Parse(ws.URL)
+		if err := resolvePublic(ctx, u.Hostname()); err != nil {
+			return WebhookSubscription{}, err
+		}
+	}
+	if ws.Secret == "" {
+		b := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			return WebhookSubscription{}, fmt.Errorf("models: generating webhook secret %w", err)
+		}
+		ws.Secret = "whsec_" + base64.RawURLEncoding.EncodeToString(b)
+	}
+	ws.ID, ws.CreatedAt = 0, time.Now()
+
+	if err := wg.db.WithContext(ctx).Create(&ws).Error; err != nil {
+		return WebhookSubscription{}, fmt.Errorf("models: couldn't create webhook subscription %w", err)
+	}
+	return ws, nil
+}
+
+// Subscriptions returns the subscriptions of tenant, without their secrets.
+func (wg *webhookGorm) Subscriptions(ctx context.Context, tenant string) ([]WebhookSubscription, error) {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Subscriptions")
+	defer span.End()
+
+	var list []WebhookSubscription
+	err := wg.db.WithContext(ctx).Where("tenant = ?", tenant).Order("id").Find(&list).Error
+	if err != nil {
+		return nil, fmt.Errorf("models: couldn't list webhook subscriptions %w", err)
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+	return list, nil
+}
+
+// Unsubscribe deletes the subscription and its deliveries. Deliveries being sent are dropped once
+// their attempt ends.
+func (wg *webhookGorm) Unsubscribe(ctx context.Context, tenant string, id int64) error {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Unsubscribe")
+	defer span.End()
+
+	return wg.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("tenant = ? AND id = ?", tenant, id).Delete(&WebhookSubscription{})
+		if res.Error != nil {
+			return fmt.Errorf("models: couldn't delete webhook subscription %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		err := tx.Exec("DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE subscription_id = ?)", id).Error
+		if err == nil {
+			err = tx.Where("subscription_id = ?", id).Delete(&WebhookDelivery{}).Error
+		}
+		if err != nil {
+			return fmt.Errorf("models: couldn't delete webhook deliveries %w", err)
+		}
+		return nil
+	})
+}
+
+// Deliveries returns the latest deliveries of the subscription, newest first.
+func (wg *webhookGorm) Deliveries(ctx context.Context, tenant string, id int64) ([]WebhookDelivery, error) {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Deliveries")
+	defer span.End()
+	db := wg.db.WithContext(ctx)
+
+	var ws WebhookSubscription
+	err := db.Where("tenant = ? AND id = ?", tenant, id).Take(&ws).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, fmt.Errorf("models: couldn't find webhook subscription %w", err)
+	}
+
+	var list []WebhookDelivery
+	err = db.Where("subscription_id = ?", id).Order("id DESC").Limit(deliveriesListed).Find(&list).Error
+	if err != nil {
+		return nil, fmt.Errorf("models: couldn't list webhook deliveries %w", err)
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	ids := make([]int64, len(list))
+	index := make(map[int64]int, len(list))
+	for i, d := range list {
+		ids[i], index[d.ID] = d.ID, i
+	}
+	var attempts []WebhookAttempt
+	if err := db.Where("delivery_id IN ?", ids).Order("id").Find(&attempts).Error; err != nil {
+		return nil, fmt.Errorf("models: couldn't list webhook attempts %w", err)
+	}
+	for _, a := range attempts {
+		i := index[a.DeliveryID]
+		list[i].AttemptLog = append(list[i].AttemptLog, a)
+	}
+	return list, nil
+}
+
+// Notify queues a delivery of every event to the subscriptions of its tenant accepting its type.
+// Events of the outbox notified again are not queued twice.
+func (wg *webhookGorm) Notify(ctx context.Context, events []ObjectEvent) error {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Notify")
+	defer span.End()
+	db := wg.db.WithContext(ctx)
+
+	subs := make(map[string][]WebhookSubscription)
+	var deliveries []WebhookDelivery
+	now := time.Now()
+	for _, e := range events {
+		list, ok := subs[e.Tenant]
+		if !ok {
+			if err := db.Where("tenant = ?", e.Tenant).Find(&list).Error; err != nil {
+				return fmt.Errorf("models: couldn't list webhook subscriptions %w", err)
+			}
+			subs[e.Tenant] = list
+		}
+
+		var body []byte
+		for _, ws := range list {
+			if !ws.Events.Accepts(e.Type) {
+				continue
+			}
+			if body == nil {
+				b, err := json.Marshal(e)
+				if err != nil {
+					return fmt.Errorf("models: encoding object event %w", err)
+				}
+				body = b
+			}
+			var eventID *int64
+			if e.ID != 0 {
+				id := e.ID
+				eventID = &id
+			}
+			deliveries = append(deliveries, WebhookDelivery{
+				SubscriptionID: ws.ID,
+				EventID:        eventID,
+				Tenant:         e.Tenant,
+				Event:          e.Type,
+				ObjectID:       e.Object.ID,
+				Body:           JSONB(body),
+				Status:         DeliveryPending,
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+		return fmt.Errorf("models: couldn't queue webhook deliveries %w", err)
+	}
+	return nil
+}
+
+// webhookClaimSQL takes the deliveries due, pushing their next attempt past the timeout of an
+// attempt, so no other replica sends them meanwhile: the deliveries claimed are sent at once.
+// Deliveries of a replica stopped while sending are taken again once that time is over.
+// Deliveries wait for the earlier ones of the same object and subscription, so a webhook is told
+// about an object in order.
+const webhookClaimSQL = `
+UPDATE webhook_deliveries SET next_attempt_at = @lease
+WHERE id IN (
+	SELECT d.id FROM webhook_deliveries d
+	WHERE d.status = @pending AND d.next_attempt_at <= @now
+	AND NOT EXISTS (
+		SELECT 1 FROM webhook_deliveries p
+		WHERE p.subscription_id = d.subscription_id AND p.tenant = d.tenant
+		AND p.object_id = d.object_id AND p.status = @pending AND p.id < d.id
+	)
+	ORDER BY d.next_attempt_at
+	LIMIT @limit
+	FOR UPDATE OF d SKIP LOCKED
+)
+RETURNING *`
+
+// run sends the deliveries due, every PollInterval, and deletes the deliveries done with before
+// the retention once per retention.
+func (wg *webhookGorm) run(ctx context.Context) {
+	every(ctx, wg.cfg.PollInterval, func(ctx context.Context) {
+		for {
+			n, err := wg.sendDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				wg.log.Printf("webhook_error: %v", err)
+			}
+			// Full batches may leave more deliveries due.
+			if err != nil || n < wg.cfg.BatchSize {
+				break
+			}
+		}
+
+		if time.Since(wg.lastCleanup) >= wg.cfg.Retention {
+			wg.lastCleanup = time.Now()
+			if err := wg.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				wg.log.Printf("webhook_cleanup_error: %v", err)
+			}
+		}
+	})
+}
+
+// Cleanup deletes the deliveries delivered or given up that were queued before the retention,
+// and their attempts. Pending deliveries are kept however old they are.
+func (wg *webhookGorm) Cleanup(ctx context.Context) error {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.Cleanup")
+	defer span.End()
+
+	before := time.Now().Add(-wg.cfg.Retention)
+	var deleted int64
+	err := wg.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE status <> ? AND created_at < ?)", DeliveryPending, before).Error
+		if err != nil {
+			return err
+		}
+		res := tx.Where("status <> ? AND created_at < ?", DeliveryPending, before).Delete(&WebhookDelivery{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return fmt.Errorf("models: couldn't clean up webhook deliveries %w", err)
+	}
+	webhookDeliveries.Add("deleted", deleted)
+	return nil
+}
+
+// sendDue sends a batch of the deliveries due and returns how many were sent. They are sent
+// concurrently, so the batch ends within the timeout of an attempt, before their lease does.
+// Deliveries claimed together are about different objects or subscriptions.
+func (wg *webhookGorm) sendDue(ctx context.Context) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "models.webhookGorm.sendDue")
+	defer span.End()
+	db := wg.db.WithContext(ctx)
+
+	now := time.Now()
+	var due []WebhookDelivery
+	err := db.Raw(webhookClaimSQL,
+		sql.Named("lease", now.Add(2*wg.cfg.Timeout)),
+		sql.Named("pending", DeliveryPending),
+		sql.Named("now", now),
+		sql.Named("limit", wg.cfg.BatchSize),
+	).Scan(&due).Error
+	if err != nil {
+		return 0, fmt.Errorf("models: couldn't claim webhook deliveries %w", err)
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(due))
+	for _, d := range due {
+		ids = append(ids, d.SubscriptionID)
+	}
+	var list []WebhookSubscription
+	if err := db.Where("id IN ?", ids).Find(&list).Error; err != nil {
+		return 0, fmt.Errorf("models: couldn't find webhook subscriptions %w", err)
+	}
+	subs := make(map[int64]WebhookSubscription, len(list))
+	for _, ws := range list {
+		subs[ws.ID] = ws
+	}
+
+	var sending sync.WaitGroup
+	for _, d := range due {
+		ws, ok := subs[d.SubscriptionID]
+		if !ok {
+			// The subscription was deleted after the delivery was claimed.
+			continue
+		}
+		sending.Add(1)
+		go func(d WebhookDelivery) {
+			defer sending.Done()
+			if err := wg.attempt(ctx, ws, d); err != nil {
+				wg.log.Printf("webhook_error: delivery %d: %v", d.ID, err)
+			}
+		}(d)
+	}
+	sending.Wait()
+	return len(due), nil
+}
+
+// attempt sends d to ws and records the attempt and its outcome.
+func (wg *webhookGorm) attempt(ctx context.Context, ws WebhookSubscription, d WebhookDelivery) error {
+	d.Attempts++
+	start := time.Now()
+	status, sendErr := wg.send(ctx, ws, d)
+
+	a := WebhookAttempt{
+		DeliveryID: d.ID,
+		Attempt:    d.Attempts,
+		StatusCode: status,
+		DurationMS: time.Since(start).Milliseconds(),
+		CreatedAt:  time.Now(),
+	}
+	updates := map[string]interface{}{"attempts": d.Attempts}
+	switch {
+	case sendErr == nil:
+		webhookDeliveries.Add("delivered", 1)
+		updates["status"], updates["delivered_at"] = DeliveryDelivered, a.CreatedAt
+	case d.Attempts >= wg.cfg.MaxAttempts:
+		webhookDeliveries.Add("failed", 1)
+		a.Error = sendErr.Error()
+		updates["status"] = DeliveryFailed
+	default:
+		webhookDeliveries.Add("retried", 1)
+		a.Error = sendErr.Error()
+		updates["next_attempt_at"] = a.CreatedAt.Add(wg.cfg.backoff(d.Attempts))
+	}
+
+	return wg.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&a).Error; err != nil {
+			return fmt.Errorf("models: couldn't record webhook attempt %w", err)
+		}
+		err := tx.Model(&WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error
+		if err != nil {
+			return fmt.Errorf("models: couldn't update webhook delivery %w", err)
+		}
+		return nil
+	})
+}
+
+// send posts the body of d to ws, signed with its secret. Answers out of the 2xx range are
+// errors.
+func (wg *webhookGorm) send(ctx context.Context, ws WebhookSubscription, d WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, wg.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(web.SignatureHeader, web.Sign(d.Body, time.Now(), ws.Secret))
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(d.Attempts))
+
+	resp, err := wg.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
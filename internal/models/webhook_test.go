@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+func TestWebhookConfig_backoff(t *testing.T) {
+	cfg := WebhookConfig{Backoff: time.Second, MaxBackoff: 10 * time.Second}.withDefaults()
+
+	var waits []time.Duration
+	for n := 1; n <= 6; n++ {
+		waits = append(waits, cfg.backoff(n))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, waits)
+}
+
+func TestEventFilter(t *testing.T) {
+	f := EventFilter{EventObjectOnline, EventObjectExpired}
+	assert.True(t, f.Accepts(EventObjectOnline))
+	assert.False(t, f.Accepts(EventObjectOffline))
+	assert.True(t, EventFilter(nil).Accepts(EventObjectOffline), "empty filters accept every event")
+
+	v, err := f.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "object.online,object.expired", v)
+
+	var scanned EventFilter
+	assert.NoError(t, scanned.Scan([]byte("object.offline")))
+	assert.Equal(t, EventFilter{EventObjectOffline}, scanned)
+	assert.NoError(t, scanned.Scan(""))
+	assert.Nil(t, scanned)
+}
+
+func TestWebhookSubscription_validate(t *testing.T) {
+	var cases = []struct {
+		name   string
+		ws     WebhookSubscription
+		outerr error
+	}{
+		{"ok", WebhookSubscription{URL: "https://hooks.example.com/objects", Events: EventFilter{EventObjectOffline}}, nil},
+		{"everyEvent", WebhookSubscription{URL: "http://hooks:8080"}, nil},
+		{"relativeURL", WebhookSubscription{URL: "/objects"}, ErrInvalidWebhookURL},
+		{"scheme", WebhookSubscription{URL: "ftp://hooks.example.com"}, ErrInvalidWebhookURL},
+		{"unknownEvent", WebhookSubscription{URL: "https://hooks.example.com", Events: EventFilter{"object.created"}}, ErrInvalidEventType},
+	}
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			assert.Equal(t, cs.outerr, cs.ws.validate())
+		})
+	}
+}
+
+func TestWebhookGorm_send(t *testing.T) {
+	body := []byte(`{"type":"object.online","tenant":"acme","object":{"id":7}}`)
+	status := http.StatusNoContent
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		err := web.VerifySignature(r.Header.Get(web.SignatureHeader), b, []string{"whsec_test"}, time.Minute, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, EventObjectOnline, r.Header.Get(WebhookEventHeader))
+		assert.Equal(t, "42", r.Header.Get(WebhookDeliveryHeader))
+		assert.Equal(t, "3", r.Header.Get(WebhookAttemptHeader))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	wg := &webhookGorm{cfg: WebhookConfig{}.withDefaults(), client: srv.Client()}
+	ws := WebhookSubscription{URL: srv.URL, Secret: "whsec_test"}
+	d := WebhookDelivery{ID: 42, Event: EventObjectOnline, Body: JSONB(body), Attempts: 3}
+
+	code, err := wg.send(context.Background(), ws, d)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	status = http.StatusInternalServerError
+	code, err = wg.send(context.Background(), ws, d)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	ws.Secret = "whsec_other"
+	code, err = wg.send(context.Background(), ws, d)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code, "deliveries are signed with the secret of the subscription")
+}
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.20.0.1":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+	} {
+		assert.Equal(t, want, publicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestWebhookGorm_Subscribe_privateNetworks(t *testing.T) {
+	wg := &webhookGorm{cfg: WebhookConfig{}.withDefaults()}
+
+	for _, u := range []string{"http://127.0.0.1:8080/hooks", "http://[::1]/hooks", "http://169.254.169.254/latest", "http://localhost/hooks"} {
+		_, err := wg.Subscribe(context.Background(), WebhookSubscription{URL: u})
+		assert.Equal(t, ErrForbiddenWebhookURL, err, u)
+	}
+}
+
+func TestNewWebhookClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	_, err := newWebhookClient(WebhookConfig{}.withDefaults()).Get(srv.URL)
+	assert.True(t, errors.Is(err, ErrForbiddenWebhookURL), "private addresses are refused when dialed: %v", err)
+
+	resp, err := newWebhookClient(WebhookConfig{AllowPrivateNetworks: true}.withDefaults()).Get(srv.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+}
+
+func TestWebhookGorm_Notify(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+	ctx := context.Background()
+
+	wg := &webhookGorm{db: db, cfg: WebhookConfig{}.withDefaults()}
+	ws, err := wg.Subscribe(ctx, WebhookSubscription{Tenant: "acme", URL: "https://93.184.216.34/hooks", Events: EventFilter{EventObjectOnline}})
+	assert.NoError(t, err)
+
+	events := []ObjectEvent{
+		{ID: 1, Type: EventObjectOnline, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 7}},
+		{ID: 2, Type: EventObjectOffline, Tenant: "acme", Object: Callback{Tenant: "acme", ID: 7}},
+		{ID: 3, Type: EventObjectOnline, Tenant: DefaultTenant, Object: Callback{Tenant: DefaultTenant, ID: 7}},
+	}
+	assert.NoError(t, wg.Notify(ctx, events))
+	assert.NoError(t, wg.Notify(ctx, events[:1]), "events notified again are ignored")
+
+	deliveries, err := wg.Deliveries(ctx, "acme", ws.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1, "deliveries are queued for the events of the tenant accepted by the subscription") {
+		assert.Equal(t, EventObjectOnline, deliveries[0].Event)
+		assert.Equal(t, int64(7), deliveries[0].ObjectID)
+	}
+}
+
+func TestWebhookGorm_Cleanup(t *testing.T) {
+	db := NewTestDatabase(t)
+	defer CleanupTestDatabase(db)
+	ctx := context.Background()
+
+	wg := &webhookGorm{db: db, cfg: WebhookConfig{Retention: time.Hour}.withDefaults()}
+	old, recent := time.Now().Add(-2*time.Hour), time.Now()
+	deliveries := []WebhookDelivery{
+		{SubscriptionID: 1, Tenant: "acme", Event: EventObjectOnline, ObjectID: 1, Body: JSONB(`{}`), Status: DeliveryDelivered, NextAttemptAt: old, CreatedAt: old},
+		{SubscriptionID: 1, Tenant: "acme", Event: EventObjectOnline, ObjectID: 2, Body: JSONB(`{}`), Status: DeliveryFailed, NextAttemptAt: old, CreatedAt: old},
+		{SubscriptionID: 1, Tenant: "acme", Event: EventObjectOnline, ObjectID: 3, Body: JSONB(`{}`), Status: DeliveryPending, NextAttemptAt: old, CreatedAt: old},
+		{SubscriptionID: 1, Tenant: "acme", Event: EventObjectOnline, ObjectID: 4, Body: JSONB(`{}`), Status: DeliveryDelivered, NextAttemptAt: recent, CreatedAt: recent},
+	}
+	assert.NoError(t, db.Create(&deliveries).Error)
+	for _, d := range deliveries {
+		assert.NoError(t, db.Create(&WebhookAttempt{DeliveryID: d.ID, Attempt: 1, CreatedAt: d.CreatedAt}).Error)
+	}
+
+	assert.NoError(t, wg.Cleanup(ctx))
+
+	var kept []WebhookDelivery
+	assert.NoError(t, db.Order("object_id").Find(&kept).Error)
+	if assert.Len(t, kept, 2, "pending and recent deliveries are kept") {
+		assert.Equal(t, int64(3), kept[0].ObjectID)
+		assert.Equal(t, int64(4), kept[1].ObjectID)
+	}
+	var attempts int64
+	db.Model(&WebhookAttempt{}).Count(&attempts)
+	assert.Equal(t, int64(2), attempts, "the attempts of the deleted deliveries are deleted with them")
+}
@@ -0,0 +1,398 @@
+// Package objectservice provides the client of the services holding the status of the objects.
+package objectservice
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	// objectsPath is the path the objects are served on, followed by their ID.
+	objectsPath = "/objects/"
+
+	// batchPath is the path serving several objects at once, listed on the ids query parameter.
+	batchPath = "/objects"
+)
+
+// Client fetches objects from the object services. Implementations must be safe for concurrent
+// use.
+type Client interface {
+	// Object returns the object identified by id from the object service at baseURL.
+	Object(ctx context.Context, baseURL string, id int64) (Object, error)
+
+	// Objects returns the objects identified by ids from the object service at baseURL in a single
+	// request. Objects unknown to the service are left out. Services not serving batches return
+	// ErrBatchUnsupported.
+	Objects(ctx context.Context, baseURL string, ids []int64) ([]Object, error)
+
+	// Healthy reports whether the object service at baseURL can be expected to answer. Services
+	// failing repeatedly are reported unhealthy until they are given another try.
+	Healthy(baseURL string) bool
+}
+
+// Object is the status of an object, as served by the object services.
+type Object struct {
+	ID     int64 `json:"id"`
+	Online bool  `json:"online"`
+
+	// Raw is the whole object document as served, including the fields unknown to this package.
+	Raw json.RawMessage `json:"-"`
+}
+
+// Config holds the settings of the client. Zero values take the defaults of New.
+type Config struct {
+	// UserAgent is sent on every request.
+	UserAgent string
+
+	// Token is sent as a bearer token on every request, if set. TokenFile is read for the token
+	// instead, and read again when it changes, checking it at most once per TokenReload.
+	Token       string
+	TokenFile   string
+	TokenReload time.Duration
+
+	// Headers are sent on every request, replacing the default ones of the same name.
+	Headers http.Header
+
+	// TLS holds the certificate authorities trusted and the client certificates presented, see
+	// LoadTLSConfig. Nil takes the system defaults.
+	TLS *tls.Config
+
+	// Proxy is the HTTP proxy the requests go through. Nil takes the proxy of the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy *url.URL
+
+	// Timeout bounds a whole request, DialTimeout and TLSHandshakeTimeout the connection set up.
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+
+	// MaxIdleConns and MaxIdleConnsPerHost size the pool of kept alive connections, which are
+	// closed once idle for IdleConnTimeout. MaxConnsPerHost bounds the connections to a service,
+	// zero means no limit.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+
+	// UnhealthyAfter is the number of consecutive failures after which a service is reported
+	// unhealthy. It is given another try HealthRetry after its last failure.
+	UnhealthyAfter int
+	HealthRetry    time.Duration
+
+	// BatchRetry is how long a service found not to serve batches is not sent any.
+	BatchRetry time.Duration
+
+	// Retries is the number of times a request failing with a retryable error is sent again,
+	// waiting RetryBackoff before the first retry and twice as long before every other one.
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// withDefaults returns cfg with its zero values replaced by the defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "go-callback-service"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 2 * time.Second
+	}
+	if cfg.TLSHandshakeTimeout <= 0 {
+		cfg.TLSHandshakeTimeout = 2 * time.Second
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = 100
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = 100
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = 90 * time.Second
+	}
+	if cfg.UnhealthyAfter <= 0 {
+		cfg.UnhealthyAfter = 5
+	}
+	if cfg.HealthRetry <= 0 {
+		cfg.HealthRetry = 5 * time.Second
+	}
+	if cfg.BatchRetry <= 0 {
+		cfg.BatchRetry = 10 * time.Minute
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+	if cfg.TokenReload <= 0 {
+		cfg.TokenReload = 30 * time.Second
+	}
+	return cfg
+}
+
+// New returns a Client sharing a single connection pool among all of its requests.
+func New(cfg Config) Client {
+	cfg = cfg.withDefaults()
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != nil {
+		proxy = http.ProxyURL(cfg.Proxy)
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		tlsConfig = cfg.TLS.Clone()
+	}
+
+	transport := &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+	}
+
+	var token *tokenFile
+	if cfg.TokenFile != "" {
+		token = &tokenFile{path: cfg.TokenFile, every: cfg.TokenReload}
+	}
+
+	return &client{
+		cfg:   cfg,
+		token: token,
+		http: &http.Client{
+			Transport:     transport,
+			Timeout:       cfg.Timeout,
+			CheckRedirect: checkRedirect,
+		},
+		health:  make(map[string]*health),
+		noBatch: make(map[string]time.Time),
+	}
+}
+
+// maxRedirects is the number of redirects followed by a request, as for the default http.Client.
+const maxRedirects = 10
+
+// noRedirectKey marks the contexts of the requests whose redirects are answered to the caller
+// rather than followed.
+type noRedirectKey struct{}
+
+// checkRedirect follows up to maxRedirects redirects, unless the request asked for none.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.Context().Value(noRedirectKey{}) != nil {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("objectservice: stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
+
+// health tracks the recent failures of an object service.
+type health struct {
+	failures    int
+	lastFailure time.Time
+}
+
+type client struct {
+	cfg   Config
+	http  *http.Client
+	token *tokenFile
+
+	mu     sync.Mutex
+	health map[string]*health
+	// noBatch holds when the services not serving batches were found out.
+	noBatch map[string]time.Time
+}
+
+// Object requests the object identified by id. A 404 answer is reported as ErrObjectGone, and
+// answers that are not JSON, not an object or about another object are rejected.
+func (c *client) Object(ctx context.Context, baseURL string, id int64) (Object, error) {
+	ctx, span := trace.StartSpan(ctx, "objectservice.client.Object")
+	defer span.End()
+
+	resp, err := c.get(ctx, baseURL, baseURL+objectsPath+strconv.FormatInt(id, 10))
+	if err != nil {
+		return Object{}, count(err)
+	}
+	defer resp.Body.Close()
+
+	span.AddAttributes(trace.Int64Attribute("status_code", int64(resp.StatusCode)))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Object{}, count(ErrObjectGone)
+	case resp.StatusCode != http.StatusOK:
+		return Object{}, count(ErrUnexpectedStatus)
+	}
+
+	o, err := decodeObject(resp)
+	if err != nil {
+		return Object{}, count(err)
+	}
+	if o.ID != id {
+		return Object{}, count(ErrIDMismatch)
+	}
+	return o, nil
+}
+
+// Objects requests the objects identified by ids with a single GET /objects?ids=1,2,3 request.
+// Services answering with a redirect, a 4xx status or 501 are taken as not serving batches, and
+// are not sent any for BatchRetry: the batch path may well be served by the route of the single
+// objects. Answers holding objects that were not asked for are rejected whole.
+func (c *client) Objects(ctx context.Context, baseURL string, ids []int64) ([]Object, error) {
+	ctx, span := trace.StartSpan(ctx, "objectservice.client.Objects")
+	defer span.End()
+	span.AddAttributes(trace.Int64Attribute("ids", int64(len(ids))))
+
+	c.mu.Lock()
+	since, found := c.noBatch[baseURL]
+	c.mu.Unlock()
+	if found && time.Since(since) < c.cfg.BatchRetry {
+		return nil, ErrBatchUnsupported
+	}
+
+	list := make([]string, len(ids))
+	asked := make(map[int64]bool, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.FormatInt(id, 10)
+		asked[id] = true
+	}
+	ctx = context.WithValue(ctx, noRedirectKey{}, true)
+	resp, err := c.get(ctx, baseURL, baseURL+batchPath+"?ids="+strings.Join(list, ","))
+	if err != nil {
+		return nil, count(err)
+	}
+	defer resp.Body.Close()
+
+	span.AddAttributes(trace.Int64Attribute("status_code", int64(resp.StatusCode)))
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 500,
+		resp.StatusCode == http.StatusNotImplemented:
+		c.mu.Lock()
+		c.noBatch[baseURL] = time.Now()
+		c.mu.Unlock()
+		return nil, ErrBatchUnsupported
+	case resp.StatusCode != http.StatusOK:
+		return nil, count(ErrUnexpectedStatus)
+	}
+
+	objects, err := decodeObjects(resp)
+	if err != nil {
+		return nil, count(err)
+	}
+	for _, o := range objects {
+		if !asked[o.ID] {
+			return nil, count(ErrIDMismatch)
+		}
+	}
+	return objects, nil
+}
+
+// get sends a GET request to url, on the service at baseURL. Requests that can't be sent or are
+// answered with a 5xx status are retried up to Retries times, and count as failures of the
+// service. The caller must close the body of the returned response.
+func (c *client) get(ctx context.Context, baseURL, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("objectservice: building request %w", err)
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	for name, values := range c.cfg.Headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	token := c.cfg.Token
+	if c.token != nil {
+		if token, err = c.token.get(); err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	backoff := c.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.http.Do(req)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Requests cancelled by the caller say nothing of the service.
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		case err != nil:
+			c.report(baseURL, false)
+			err = fmt.Errorf("%w: %v", ErrUnavailable, err)
+		case resp.StatusCode >= http.StatusInternalServerError:
+			c.report(baseURL, false)
+			resp.Body.Close()
+			err = ErrServerError
+		default:
+			c.report(baseURL, true)
+			return resp, nil
+		}
+
+		if attempt >= c.cfg.Retries {
+			return nil, err
+		}
+		retries.Add(1)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// Healthy reports whether the service at baseURL failed less than UnhealthyAfter times in a row,
+// or failed long enough ago to be given another try.
+func (c *client) Healthy(baseURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.health[baseURL]
+	if !ok || h.failures < c.cfg.UnhealthyAfter {
+		return true
+	}
+	return time.Since(h.lastFailure) >= c.cfg.HealthRetry
+}
+
+// report records the outcome of a request to the service at baseURL.
+func (c *client) report(baseURL string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, found := c.health[baseURL]
+	if !found {
+		h = &health{}
+		c.health[baseURL] = h
+	}
+
+	if ok {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	h.lastFailure = time.Now()
+}
@@ -0,0 +1,264 @@
+package objectservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeJSON answers body as JSON.
+func writeJSON(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, body)
+}
+
+func TestClient_Object(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		switch r.URL.Path {
+		case "/objects/1":
+			writeJSON(w, `{"id":1,"online":true,"region":"eu"}`)
+		case "/objects/2":
+			writeJSON(w, `not json`)
+		case "/objects/3":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/objects/4":
+			fmt.Fprint(w, `{"id":4,"online":true}`)
+		case "/objects/5":
+			writeJSON(w, `{"id":6,"online":true}`)
+		case "/objects/6":
+			writeJSON(w, `{}`)
+		case "/objects/7":
+			writeJSON(w, `{"id":7,"online":"yes"}`)
+		case "/objects/8":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/objects/9":
+			w.Header().Set("Content-Type", "application/vnd.objects+json; charset=utf-8")
+			fmt.Fprint(w, `{"id":9,"online":false}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(Config{UserAgent: "tests"})
+
+	var cases = []struct {
+		name   string
+		id     int64
+		outObj Object
+		outErr error
+	}{
+		{"ok", 1, Object{ID: 1, Online: true, Raw: json.RawMessage(`{"id":1,"online":true,"region":"eu"}`)}, nil},
+		{"invalidResponse", 2, Object{}, ErrInvalidResponse},
+		{"serverError", 3, Object{}, ErrServerError},
+		{"contentType", 4, Object{}, ErrContentType},
+		{"idMismatch", 5, Object{}, ErrIDMismatch},
+		{"missingFields", 6, Object{}, ErrInvalidSchema},
+		{"wrongType", 7, Object{}, ErrInvalidSchema},
+		{"unexpectedStatus", 8, Object{}, ErrUnexpectedStatus},
+		{"jsonSuffix", 9, Object{ID: 9, Raw: json.RawMessage(`{"id":9,"online":false}`)}, nil},
+		{"gone", 10, Object{}, ErrObjectGone},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			o, err := c.Object(context.Background(), srv.URL, cs.id)
+			assert.Equal(t, cs.outErr, err)
+			assert.Equal(t, cs.outObj, o)
+			assert.Equal(t, "tests", userAgent)
+		})
+	}
+}
+
+func TestClient_Object_retries(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, `{"id":1,"online":true}`)
+	}))
+	defer srv.Close()
+
+	o, err := New(Config{Retries: 1, RetryBackoff: time.Millisecond}).Object(context.Background(), srv.URL, 1)
+	assert.Equal(t, ErrServerError, err)
+	assert.True(t, Retryable(err))
+	assert.Equal(t, 2, requests)
+
+	o, err = New(Config{Retries: 2, RetryBackoff: time.Millisecond}).Object(context.Background(), srv.URL, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Object{ID: 1, Online: true, Raw: json.RawMessage(`{"id":1,"online":true}`)}, o)
+	assert.Equal(t, 3, requests)
+}
+
+func TestClient_headers(t *testing.T) {
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		writeJSON(w, `{"id":1,"online":true}`)
+	}))
+	defer srv.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(tokenPath, []byte("fr0m-f1le\n"), 0600))
+
+	ctx := context.Background()
+	New(Config{}).Object(ctx, srv.URL, 1)
+	New(Config{Token: "s3cr3t", Headers: http.Header{"x-tenant": {"acme"}}}).Object(ctx, srv.URL, 1)
+	New(Config{Token: "s3cr3t", TokenFile: tokenPath}).Object(ctx, srv.URL, 1)
+
+	if assert.Len(t, headers, 3) {
+		assert.Empty(t, headers[0].Get("Authorization"))
+		assert.Equal(t, "Bearer s3cr3t", headers[1].Get("Authorization"))
+		assert.Equal(t, "acme", headers[1].Get("X-Tenant"))
+		assert.Equal(t, "Bearer fr0m-f1le", headers[2].Get("Authorization"), "token files take precedence")
+	}
+
+	_, err := New(Config{TokenFile: tokenPath + ".missing"}).Object(ctx, srv.URL, 1)
+	assert.True(t, errors.Is(err, ErrCredentials))
+	assert.Len(t, headers, 3, "requests without credentials are not sent")
+}
+
+func TestClient_proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		writeJSON(w, `{"id":1,"online":true}`)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	_, err := New(Config{Proxy: proxyURL}).Object(context.Background(), "http://objects.internal", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://objects.internal/objects/1", proxied)
+}
+
+func TestClient_Healthy(t *testing.T) {
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, `{"id":1,"online":true}`)
+	}))
+	defer srv.Close()
+
+	c := New(Config{UnhealthyAfter: 2, HealthRetry: 20 * time.Millisecond})
+	ctx := context.Background()
+
+	assert.True(t, c.Healthy(srv.URL), "services are healthy until they fail")
+	c.Object(ctx, srv.URL, 1)
+	assert.True(t, c.Healthy(srv.URL))
+	c.Object(ctx, srv.URL, 1)
+	assert.False(t, c.Healthy(srv.URL))
+
+	// Unhealthy services are given another try after a while, and recover on success.
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, c.Healthy(srv.URL))
+	failing = false
+	c.Object(ctx, srv.URL, 1)
+	assert.True(t, c.Healthy(srv.URL))
+
+	// Requests that can't be sent count as failures too.
+	srv.Close()
+	c.Object(ctx, srv.URL, 1)
+	c.Object(ctx, srv.URL, 1)
+	assert.False(t, c.Healthy(srv.URL))
+}
+
+func TestClient_Objects(t *testing.T) {
+	batchSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/objects", r.URL.Path)
+		switch r.URL.Query().Get("ids") {
+		case "1,2,3":
+			writeJSON(w, `{"objects":[{"id":1,"online":true,"region":"eu"},{"id":3,"online":false}]}`)
+		case "4":
+			writeJSON(w, `{"objects":[{"id":5,"online":true}]}`)
+		case "6":
+			writeJSON(w, `{"objects":[{"id":6}]}`)
+		default:
+			writeJSON(w, `{"items":[]}`)
+		}
+	}))
+	defer batchSrv.Close()
+
+	var singleRequests int
+	singleSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		singleRequests++
+		http.NotFound(w, r)
+	}))
+	defer singleSrv.Close()
+
+	c := New(Config{})
+	ctx := context.Background()
+
+	objects, err := c.Objects(ctx, batchSrv.URL, []int64{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{
+		{ID: 1, Online: true, Raw: json.RawMessage(`{"id":1,"online":true,"region":"eu"}`)},
+		{ID: 3, Raw: json.RawMessage(`{"id":3,"online":false}`)},
+	}, objects, "documents are kept whole")
+
+	_, err = c.Objects(ctx, batchSrv.URL, []int64{4})
+	assert.Equal(t, ErrIDMismatch, err, "objects not asked for are rejected")
+	_, err = c.Objects(ctx, batchSrv.URL, []int64{6})
+	assert.Equal(t, ErrInvalidSchema, err)
+	_, err = c.Objects(ctx, batchSrv.URL, []int64{7})
+	assert.Equal(t, ErrInvalidSchema, err)
+
+	// Services not serving batches are remembered, so they are asked only once.
+	_, err = c.Objects(ctx, singleSrv.URL, []int64{1, 2})
+	assert.Equal(t, ErrBatchUnsupported, err)
+	_, err = c.Objects(ctx, singleSrv.URL, []int64{1, 2})
+	assert.Equal(t, ErrBatchUnsupported, err)
+	assert.Equal(t, 1, singleRequests)
+	assert.True(t, c.Healthy(singleSrv.URL), "services not serving batches are not failing")
+}
+
+func TestClient_Objects_singleRoute(t *testing.T) {
+	// The service only serves /objects/, so the mux redirects /objects to it.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/objects/1" {
+			http.Error(w, "invalid object ID", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, `{"id":1,"online":true}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(Config{})
+	ctx := context.Background()
+
+	_, err := c.Objects(ctx, srv.URL, []int64{1, 2})
+	assert.Equal(t, ErrBatchUnsupported, err, "redirects fall back to single lookups")
+	o, err := c.Object(ctx, srv.URL, 1)
+	assert.NoError(t, err)
+	assert.True(t, o.Online)
+
+	// Services answering the batch path with a client error don't serve batches either.
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusUnprocessableEntity} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		_, err := c.Objects(ctx, srv.URL, []int64{1, 2})
+		assert.Equal(t, ErrBatchUnsupported, err, "status %d", status)
+		srv.Close()
+	}
+}
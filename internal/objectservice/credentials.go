@@ -0,0 +1,95 @@
+package objectservice
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// LoadTLSConfig returns the TLS settings trusting the certificate authorities of the PEM bundle
+// at caFile, on top of the system ones, and presenting the client certificate of certFile and
+// keyFile. Empty paths leave the matching setting out.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("objectservice: reading CA bundle %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("objectservice: no certificate found on CA bundle %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("objectservice: loading client certificate %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// tokenFile serves the bearer token held by a file, reading it again whenever the file changes,
+// so tokens can be rotated without restarting. The file is checked at most once per every.
+type tokenFile struct {
+	path  string
+	every time.Duration
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	checked time.Time
+}
+
+// get returns the current token. Files that can't be read keep the last token read, if any.
+func (tf *tokenFile) get() (string, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	if tf.token != "" && time.Since(tf.checked) < tf.every {
+		return tf.token, nil
+	}
+	tf.checked = time.Now()
+
+	info, err := os.Stat(tf.path)
+	switch {
+	case err != nil && tf.token != "":
+		return tf.token, nil
+	case err != nil:
+		return "", fmt.Errorf("%w: %v", ErrCredentials, err)
+	case tf.token != "" && info.ModTime().Equal(tf.modTime):
+		return tf.token, nil
+	}
+
+	b, err := ioutil.ReadFile(tf.path)
+	if err != nil {
+		if tf.token != "" {
+			return tf.token, nil
+		}
+		return "", fmt.Errorf("%w: %v", ErrCredentials, err)
+	}
+	token := string(bytes.TrimSpace(b))
+	if token == "" {
+		if tf.token != "" {
+			return tf.token, nil
+		}
+		return "", fmt.Errorf("%w: %s is empty", ErrCredentials, tf.path)
+	}
+
+	tf.token, tf.modTime = token, info.ModTime()
+	return tf.token, nil
+}
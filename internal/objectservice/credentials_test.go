@@ -0,0 +1,70 @@
+package objectservice
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"id":1,"online":true}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, ca, 0600))
+
+	_, err := New(Config{}).Object(context.Background(), srv.URL, 1)
+	assert.True(t, errors.Is(err, ErrUnavailable), "servers signed by unknown authorities are not trusted")
+
+	tlsConfig, err := LoadTLSConfig(caFile, "", "")
+	assert.NoError(t, err)
+	o, err := New(Config{TLS: tlsConfig}).Object(context.Background(), srv.URL, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), o.ID)
+
+	_, err = LoadTLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+	assert.Error(t, err)
+	_, err = LoadTLSConfig("", caFile, caFile)
+	assert.Error(t, err, "certificates without their key are rejected")
+}
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	tf := &tokenFile{path: path, every: time.Millisecond}
+
+	_, err := tf.get()
+	assert.True(t, errors.Is(err, ErrCredentials))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+	token, err := tf.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "first", token)
+
+	// Rotated tokens are read once the file changes.
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	token, err = tf.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+
+	// The last token is kept while the file can't be read.
+	assert.NoError(t, os.Remove(path))
+	time.Sleep(2 * time.Millisecond)
+	token, err = tf.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+}
@@ -0,0 +1,65 @@
+package objectservice
+
+// These errors are returned by the Client and describe why an object could not be fetched. Every
+// failure is counted by code on the object_service_errors metric.
+const (
+	ErrUnavailable      ClientError = "objectservice: unavailable, object service can't be reached"
+	ErrServerError      ClientError = "objectservice: server_error, object service failed to answer"
+	ErrInvalidResponse  ClientError = "objectservice: invalid_response, object service answer cannot be parsed"
+	ErrBatchUnsupported ClientError = "objectservice: batch_unsupported, object service does not serve batches"
+	ErrObjectGone       ClientError = "objectservice: object_gone, object does not exist on the object service"
+	ErrUnexpectedStatus ClientError = "objectservice: unexpected_status, object service answered with an unexpected status code"
+	ErrContentType      ClientError = "objectservice: invalid_content_type, object service answer is not json"
+	ErrInvalidSchema    ClientError = "objectservice: invalid_schema, object service answer misses required fields or holds values of the wrong type"
+	ErrIDMismatch       ClientError = "objectservice: id_mismatch, object service answered for an object that was not asked for"
+	ErrCredentials      ClientError = "objectservice: credentials_unavailable, bearer token for the object service can't be read"
+)
+
+// ClientError defines errors exported by this package. This type implement a Code() method that
+// extracts a unique error code defined for each error value exported.
+type ClientError string
+
+// Error returns the exact original message of the e value.
+func (e ClientError) Error() string {
+	return string(e)
+}
+
+// Code extracts the error code string present on the value of e.
+//
+// An error code is defined as the string after the package prefix and colon, and before the comma that follows this string. Example:
+//
+//	"objectservice: error_code, this is a validation error"
+func (e ClientError) Code() string {
+	// remove the prefix
+	s := string(e)[len("objectservice: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[:i]
+			break
+		}
+	}
+
+	return s
+}
+
+// Detail extracts the error detail string present on the value of e.
+//
+// An error detail is defined as the string after the package prefix and colon, and after the comma that follows this string. Example:
+//
+//	"objectservice: error_code, this is the error detail string"
+func (e ClientError) Detail() string {
+	// remove the prefix
+	s := string(e)[len("objectservice: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[i+2:] // +2 removes the comma and the space
+			break
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,130 @@
+package objectservice
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	// failures counts the failed requests by error code.
+	failures = expvar.NewMap("object_service_errors")
+
+	// retries counts the requests sent again after a retryable error.
+	retries = expvar.NewInt("object_service_retries")
+)
+
+// count adds err to the failures metric, and returns it.
+func count(err error) error {
+	var ce ClientError
+	if errors.As(err, &ce) {
+		failures.Add(ce.Code(), 1)
+	}
+	return err
+}
+
+// Retryable reports whether a request failing with err can be sent again: the service could not
+// be reached or failed to answer. Requests sent again by the client already failed with the
+// returned error Retries more times.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrServerError)
+}
+
+// objectSchema is the object as served by the object services. Fields are pointers so missing
+// fields can be told apart from zero values.
+type objectSchema struct {
+	ID     *int64 `json:"id"`
+	Online *bool  `json:"online"`
+}
+
+// validate checks the required fields are present and returns the object served as data.
+func (raw objectSchema) validate(data json.RawMessage) (Object, error) {
+	if raw.ID == nil || raw.Online == nil {
+		return Object{}, ErrInvalidSchema
+	}
+	return Object{ID: *raw.ID, Online: *raw.Online, Raw: data}, nil
+}
+
+// parseObject reads the object document data.
+func parseObject(data json.RawMessage) (Object, error) {
+	var raw objectSchema
+	if err := unmarshal(data, &raw); err != nil {
+		return Object{}, err
+	}
+	return raw.validate(data)
+}
+
+// decodeObject reads the object answered on resp.
+func decodeObject(resp *http.Response) (Object, error) {
+	if err := checkContentType(resp); err != nil {
+		return Object{}, err
+	}
+
+	var data json.RawMessage
+	if err := decode(resp, &data); err != nil {
+		return Object{}, err
+	}
+	return parseObject(data)
+}
+
+// decodeObjects reads the batch of objects answered on resp.
+func decodeObjects(resp *http.Response) ([]Object, error) {
+	if err := checkContentType(resp); err != nil {
+		return nil, err
+	}
+
+	var batch struct {
+		Objects *[]json.RawMessage `json:"objects"`
+	}
+	if err := decode(resp, &batch); err != nil {
+		return nil, err
+	}
+	if batch.Objects == nil {
+		return nil, ErrInvalidSchema
+	}
+
+	objects := make([]Object, 0, len(*batch.Objects))
+	for _, data := range *batch.Objects {
+		o, err := parseObject(data)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// checkContentType accepts the JSON media types, application/json and any +json suffixed type.
+func checkContentType(resp *http.Response) error {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return ErrContentType
+	}
+	return nil
+}
+
+// decode reads the JSON body of resp into v.
+func decode(resp *http.Response, v interface{}) error {
+	return jsonError(json.NewDecoder(resp.Body).Decode(v))
+}
+
+// unmarshal reads the JSON document data into v.
+func unmarshal(data []byte, v interface{}) error {
+	return jsonError(json.Unmarshal(data, v))
+}
+
+// jsonError maps a decoding error to a ClientError. Values of the wrong type are schema errors,
+// any other decoding error means the document is not valid JSON.
+func jsonError(err error) error {
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &te):
+		return ErrInvalidSchema
+	case err != nil:
+		return ErrInvalidResponse
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package callbackpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// CallbackServiceClient is the client API for CallbackService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CallbackServiceClient interface {
+	// SubmitCallback adds objects, like a /callback request. Their status is fetched from the
+	// object service in the background.
+	SubmitCallback(ctx context.Context, in *SubmitCallbackRequest, opts ...grpc.CallOption) (*SubmitCallbackResponse, error)
+	// GetObject returns a stored object.
+	GetObject(ctx context.Context, in *GetObjectRequest, opts ...grpc.CallOption) (*Object, error)
+	// ListObjects pages through the stored objects ordered by ID.
+	ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error)
+	// WatchObjects streams the changes of the objects until the client cancels it.
+	WatchObjects(ctx context.Context, in *WatchObjectsRequest, opts ...grpc.CallOption) (CallbackService_WatchObjectsClient, error)
+}
+
+type callbackServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCallbackServiceClient(cc grpc.ClientConnInterface) CallbackServiceClient {
+	return &callbackServiceClient{cc}
+}
+
+func (c *callbackServiceClient) SubmitCallback(ctx context.Context, in *SubmitCallbackRequest, opts ...grpc.CallOption) (*SubmitCallbackResponse, error) {
+	out := new(SubmitCallbackResponse)
+	err := c.cc.Invoke(ctx, "/callback.v1.CallbackService/SubmitCallback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *callbackServiceClient) GetObject(ctx context.Context, in *GetObjectRequest, opts ...grpc.CallOption) (*Object, error) {
+	out := new(Object)
+	err := c.cc.Invoke(ctx, "/callback.v1.CallbackService/GetObject", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *callbackServiceClient) ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error) {
+	out := new(ListObjectsResponse)
+	err := c.cc.Invoke(ctx, "/callback.v1.CallbackService/ListObjects", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *callbackServiceClient) WatchObjects(ctx context.Context, in *WatchObjectsRequest, opts ...grpc.CallOption) (CallbackService_WatchObjectsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CallbackService_serviceDesc.Streams[0], "/callback.v1.CallbackService/WatchObjects", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &callbackServiceWatchObjectsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CallbackService_WatchObjectsClient interface {
+	Recv() (*ObjectEvent, error)
+	grpc.ClientStream
+}
+
+type callbackServiceWatchObjectsClient struct {
+	grpc.ClientStream
+}
+
+func (x *callbackServiceWatchObjectsClient) Recv() (*ObjectEvent, error) {
+	m := new(ObjectEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CallbackServiceServer is the server API for CallbackService service.
+// All implementations must embed UnimplementedCallbackServiceServer
+// for forward compatibility
+type CallbackServiceServer interface {
+	// SubmitCallback adds objects, like a /callback request. Their status is fetched from the
+	// object service in the background.
+	SubmitCallback(context.Context, *SubmitCallbackRequest) (*SubmitCallbackResponse, error)
+	// GetObject returns a stored object.
+	GetObject(context.Context, *GetObjectRequest) (*Object, error)
+	// ListObjects pages through the stored objects ordered by ID.
+	ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error)
+	// WatchObjects streams the changes of the objects until the client cancels it.
+	WatchObjects(*WatchObjectsRequest, CallbackService_WatchObjectsServer) error
+	mustEmbedUnimplementedCallbackServiceServer()
+}
+
+// UnimplementedCallbackServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCallbackServiceServer struct {
+}
+
+func (UnimplementedCallbackServiceServer) SubmitCallback(context.Context, *SubmitCallbackRequest) (*SubmitCallbackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitCallback not implemented")
+}
+func (UnimplementedCallbackServiceServer) GetObject(context.Context, *GetObjectRequest) (*Object, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetObject not implemented")
+}
+func (UnimplementedCallbackServiceServer) ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListObjects not implemented")
+}
+func (UnimplementedCallbackServiceServer) WatchObjects(*WatchObjectsRequest, CallbackService_WatchObjectsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchObjects not implemented")
+}
+func (UnimplementedCallbackServiceServer) mustEmbedUnimplementedCallbackServiceServer() {}
+
+// UnsafeCallbackServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CallbackServiceServer will
+// result in compilation errors.
+type UnsafeCallbackServiceServer interface {
+	mustEmbedUnimplementedCallbackServiceServer()
+}
+
+func RegisterCallbackServiceServer(s grpc.ServiceRegistrar, srv CallbackServiceServer) {
+	s.RegisterService(&_CallbackService_serviceDesc, srv)
+}
+
+func _CallbackService_SubmitCallback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitCallbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CallbackServiceServer).SubmitCallback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/callback.v1.CallbackService/SubmitCallback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CallbackServiceServer).SubmitCallback(ctx, req.(*SubmitCallbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CallbackService_GetObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetObjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CallbackServiceServer).GetObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/callback.v1.CallbackService/GetObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CallbackServiceServer).GetObject(ctx, req.(*GetObjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CallbackService_ListObjects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListObjectsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CallbackServiceServer).ListObjects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/callback.v1.CallbackService/ListObjects",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CallbackServiceServer).ListObjects(ctx, req.(*ListObjectsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CallbackService_WatchObjects_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchObjectsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CallbackServiceServer).WatchObjects(m, &callbackServiceWatchObjectsServer{stream})
+}
+
+type CallbackService_WatchObjectsServer interface {
+	Send(*ObjectEvent) error
+	grpc.ServerStream
+}
+
+type callbackServiceWatchObjectsServer struct {
+	grpc.ServerStream
+}
+
+func (x *callbackServiceWatchObjectsServer) Send(m *ObjectEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _CallbackService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "callback.v1.CallbackService",
+	HandlerType: (*CallbackServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitCallback",
+			Handler:    _CallbackService_SubmitCallback_Handler,
+		},
+		{
+			MethodName: "GetObject",
+			Handler:    _CallbackService_GetObject_Handler,
+		},
+		{
+			MethodName: "ListObjects",
+			Handler:    _CallbackService_ListObjects_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchObjects",
+			Handler:       _CallbackService_WatchObjects_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "callback/v1/callback.proto",
+}
@@ -0,0 +1,3 @@
+// Package rpc contains the definition of the gRPC layer, serving the callbackpb API next to
+// the HTTP one.
+package rpc
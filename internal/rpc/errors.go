@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+)
+
+// These errors are returned by the services and can be used to provide error codes to the
+// API results.
+const (
+	ErrTooManyObjects   RPCError = "rpc: too_many_objects, callback holds more object IDs than allowed for the tenant"
+	ErrInvalidParameter RPCError = "rpc: invalid_parameter, a request field is not valid"
+	ErrStreamBehind     RPCError = "rpc: stream_behind, stream fell behind the changes of the objects, resume it from the last event"
+	ErrUnsignedCalls    RPCError = "rpc: unsigned_calls, callbacks must be signed, which grpc calls can't be"
+	ErrShuttingDown     RPCError = "rpc: shutting_down, the service is stopping, resume the stream from the last event"
+)
+
+// PublicError is an error that returns a string code that can be presented to the API user.
+type PublicError interface {
+	error
+	Code() string
+	Detail() string
+}
+
+// RPCError defines errors exported by this package. This type implement a Code() method that
+// extracts a unique error code defined for each error value exported.
+type RPCError string
+
+// Error returns the exact original message of the e value.
+func (e RPCError) Error() string {
+	return string(e)
+}
+
+// Code extracts the error code string present on the value of e.
+//
+// An error code is defined as the string after the package prefix and colon, and before the comma that follows this string. Example:
+//		"rpc: error_code, this is a validation error"
+func (e RPCError) Code() string {
+	// remove the prefix
+	s := string(e)[len("rpc: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[:i]
+			break
+		}
+	}
+
+	return s
+}
+
+// Detail extracts the error detail string present on the value of e.
+//
+// An error detail is defined as the string after the package prefix and colon, and after the comma that follows this string. Example:
+//		"rpc: error_code, this is the error detail string"
+func (e RPCError) Detail() string {
+	// remove the prefix
+	s := string(e)[len("rpc: "):]
+
+	// extract the error code
+	for i := 1; i < len(s); i++ {
+		if s[i] == ',' {
+			s = s[i+2:] // +2 removes the comma and the space
+			break
+		}
+	}
+
+	return s
+}
+
+// statusError returns the gRPC status answering err. The message of public errors holds their
+// error code and detail, like the bodies of the HTTP errors, other errors are reported as
+// internal.
+func statusError(err error) error {
+	return statusErrorCode(codeOf(err), err)
+}
+
+// statusErrorCode returns the gRPC status answering err with code.
+func statusErrorCode(code codes.Code, err error) error {
+	var pe PublicError
+	if !errors.As(err, &pe) {
+		return status.Error(codes.Internal, "server_error")
+	}
+	return status.Error(code, fmt.Sprintf("%s: %s", pe.Code(), pe.Detail()))
+}
+
+// codeOf returns the gRPC code matching err.
+func codeOf(err error) codes.Code {
+	switch {
+	case errors.Is(err, models.ErrNotFound), errors.Is(err, mw.ErrTenantNotFound):
+		return codes.NotFound
+	case errors.Is(err, models.ErrInvalidAPIKey), errors.Is(err, mw.ErrUnauthenticated):
+		return codes.Unauthenticated
+	case errors.Is(err, mw.ErrTenantForbidden), errors.Is(err, mw.ErrTenantAnonymous),
+		errors.Is(err, ErrUnsignedCalls):
+		return codes.PermissionDenied
+	case errors.Is(err, mw.ErrOverloaded), errors.Is(err, ErrStreamBehind), errors.Is(err, ErrShuttingDown):
+		return codes.Unavailable
+	case errors.Is(err, ErrTooManyObjects), errors.Is(err, mw.ErrRateLimited):
+		return codes.ResourceExhausted
+	case errors.Is(err, ErrInvalidParameter), errors.Is(err, models.ErrInvalidPayloadPath):
+		return codes.InvalidArgument
+	}
+	return codes.Internal
+}
@@ -0,0 +1,205 @@
+package rpc
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/web"
+)
+
+// apiKeyMetadata is the metadata that can carry the API key of a call, as an alternative to a
+// bearer authorization metadata.
+var apiKeyMetadata = strings.ToLower(mw.APIKeyHeader)
+
+// submitCallbackMethod is the full name of the SubmitCallback method.
+const submitCallbackMethod = "/callback.v1.CallbackService/SubmitCallback"
+
+// unary runs the unary calls through intercept.
+func (s *Server) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var resp interface{}
+	err := s.intercept(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// stream runs the streaming calls through intercept.
+func (s *Server) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.intercept(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// serverStream is a grpc.ServerStream carrying the context built by intercept.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// intercept does for every call what the App and the middlewares of the HTTP API do for every
+// request: it records the web.Values of the call on its context, traces it, authenticates it when
+// API keys are required, limits its rate, and logs it in the format:
+// TraceID : (OK) : /callback.v1.CallbackService/GetObject -> IP ADDR (latency)
+//
+// Calls of an authenticated client are suffixed with: : client ID
+func (s *Server) intercept(ctx context.Context, method string, call func(context.Context) error) error {
+	ctx, span := trace.StartSpan(ctx, "internal.platform.rpc")
+	defer span.End()
+
+	v := web.Values{
+		TraceID: span.SpanContext().TraceID.String(),
+		Start:   time.Now(),
+	}
+	ctx = context.WithValue(ctx, web.KeyValues, &v)
+
+	err := s.authenticate(ctx, &v)
+	if err == nil {
+		err = s.rateLimit(ctx, method, &v)
+	}
+	if err == nil {
+		err = call(ctx)
+	}
+
+	st := status.Convert(err)
+	span.SetStatus(trace.Status{Code: int32(st.Code()), Message: st.Message()})
+	if v.ClientID != "" {
+		span.AddAttributes(trace.StringAttribute("client_id", v.ClientID))
+	}
+	if v.TenantID != "" {
+		span.AddAttributes(trace.StringAttribute("tenant_id", v.TenantID))
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	format := "%s : (%s) : %s -> %s (%s)"
+	args := []interface{}{
+		v.TraceID, st.Code(),
+		method,
+		addr, time.Since(v.Start),
+	}
+	if v.ClientID != "" {
+		format += " : client %s"
+		args = append(args, v.ClientID)
+	}
+	s.log.Printf(format, args...)
+
+	return err
+}
+
+// authenticate rejects the calls without a valid API key, when they are required, and records
+// the client owning the key and its tenant on v.
+func (s *Server) authenticate(ctx context.Context, v *web.Values) error {
+	if !s.cfg.RequireAPIKey {
+		return nil
+	}
+
+	key := callAPIKey(ctx)
+	if key == "" {
+		return statusError(mw.ErrUnauthenticated)
+	}
+	ak, err := s.keys.Authenticate(ctx, key)
+	if err != nil {
+		return statusError(err)
+	}
+
+	v.ClientID = ak.Client
+	v.TenantID = ak.Tenant
+	return nil
+}
+
+// rateLimit rejects the calls of a client exceeding the limit of method, as the RateLimit
+// middleware does on the matching HTTP route, whose buckets it shares. Rejected calls carry a
+// retry-after header. Calls are let through if the buckets can't be reached.
+func (s *Server) rateLimit(ctx context.Context, method string, v *web.Values) error {
+	route, limit := "objects", s.cfg.ObjectsRateLimit
+	if method == submitCallbackMethod {
+		route, limit = "callback", s.cfg.CallbackRateLimit
+	}
+	if s.cfg.RateLimits == nil || !limit.Enabled() {
+		return nil
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	res, err := s.cfg.RateLimits.Allow(ctx, route+":"+mw.RateLimitIdentity(v, addr), limit)
+	if err != nil {
+		s.log.Printf("%s : rate_limit_error: %v", v.TraceID, err)
+		return nil
+	}
+	if !res.Allowed {
+		retryAfter := strconv.FormatInt(int64(math.Ceil(res.RetryAfter.Seconds())), 10)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+		return statusError(mw.ErrRateLimited)
+	}
+	return nil
+}
+
+// callAPIKey returns the API key sent on the metadata of the call, if any.
+func callAPIKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(apiKeyMetadata); len(keys) > 0 && keys[0] != "" {
+		return keys[0]
+	}
+
+	for _, auth := range md.Get("authorization") {
+		if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return strings.TrimSpace(auth[len("Bearer "):])
+		}
+	}
+	return ""
+}
+
+// tenant resolves the tenant a call is made for, as the Tenant middleware does for the HTTP
+// requests: the tenant of the authenticated client, or the requested one. Calls with neither
+// belong to models.DefaultTenant, and anonymous calls can't request another tenant.
+func (s *Server) tenant(ctx context.Context, requested string) (models.Tenant, error) {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		v = &web.Values{}
+	}
+
+	id := requested
+	switch {
+	case v.TenantID != "" && id != "" && id != v.TenantID:
+		return models.Tenant{}, mw.ErrTenantForbidden
+	case v.TenantID == "" && id != "" && id != models.DefaultTenant:
+		return models.Tenant{}, mw.ErrTenantAnonymous
+	case v.TenantID != "":
+		id = v.TenantID
+	case id == "":
+		id = models.DefaultTenant
+	}
+
+	t, err := s.tenants.Get(ctx, id)
+	switch {
+	case err == models.ErrNotFound:
+		return models.Tenant{}, mw.ErrTenantNotFound
+	case err != nil:
+		return models.Tenant{}, err
+	}
+
+	v.TenantID = t.ID
+	trace.FromContext(ctx).AddAttributes(trace.StringAttribute("tenant_id", t.ID))
+	return t, nil
+}
@@ -0,0 +1,277 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+
+	mw "github.com/noelruault/go-callback-service/internal/middleware"
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/rpc/callbackpb"
+)
+
+const (
+	// maxWatchIDs is the maximum number of object IDs a watch can be filtered by.
+	maxWatchIDs = 1000
+	// maxPayloadMatches is the maximum number of payload filters of a single request.
+	maxPayloadMatches = 10
+)
+
+// Config holds the settings used to build the gRPC server.
+type Config struct {
+	// RequireAPIKey makes every call without a valid API key fail.
+	RequireAPIKey bool
+
+	// MaxMessageBytes limits the size of the received messages. Zero takes the gRPC default, 4MiB.
+	MaxMessageBytes int
+
+	// Admission sheds the submitted callbacks while the backlog of status lookups is too large.
+	Admission mw.AdmissionConfig
+
+	// CallbackRateLimit limits the SubmitCallback calls of every client, ObjectsRateLimit the
+	// calls reading the objects. They take the buckets of RateLimits shared with the matching
+	// HTTP routes. Zero limits are not applied.
+	RateLimits        models.RateLimitService
+	CallbackRateLimit models.RateLimit
+	ObjectsRateLimit  models.RateLimit
+
+	// SignedCallbacks is set when the callbacks must be signed. Calls are not, so SubmitCallback
+	// is refused.
+	SignedCallbacks bool
+}
+
+// Server implements the callbackpb.CallbackService on top of the same services as the HTTP API.
+type Server struct {
+	callbackpb.UnimplementedCallbackServiceServer
+
+	csvc    models.CallbackService
+	tenants models.TenantService
+	keys    models.APIKeyService
+	broker  *models.EventBroker
+	cfg     Config
+
+	log *log.Logger
+}
+
+// NewServer returns the gRPC server of the API, reading and submitting the objects through csvc
+// and streaming the events of broker. Calls are traced, logged and authenticated like the HTTP
+// requests.
+func NewServer(log *log.Logger, db *gorm.DB, csvc models.CallbackService, broker *models.EventBroker, cfg Config) *grpc.Server {
+	s := &Server{
+		csvc:    csvc,
+		tenants: models.NewTenantService(db),
+		keys:    models.NewAPIKeyService(db),
+		broker:  broker,
+		cfg:     cfg,
+		log:     log,
+	}
+	return s.register()
+}
+
+// register returns a gRPC server serving s.
+func (s *Server) register() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unary),
+		grpc.StreamInterceptor(s.stream),
+	}
+	if s.cfg.MaxMessageBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.cfg.MaxMessageBytes))
+	}
+
+	gs := grpc.NewServer(opts...)
+	callbackpb.RegisterCallbackServiceServer(gs, s)
+	return gs
+}
+
+// SubmitCallback stores the distinct objects of the request, and fetches their status in the
+// background. Calls are refused when the callbacks must be signed.
+func (s *Server) SubmitCallback(ctx context.Context, req *callbackpb.SubmitCallbackRequest) (*callbackpb.SubmitCallbackResponse, error) {
+	ctx, span := trace.StartSpan(ctx, "rpc.Server.SubmitCallback")
+	defer span.End()
+
+	if s.cfg.SignedCallbacks {
+		return nil, statusError(ErrUnsignedCalls)
+	}
+
+	t, err := s.tenant(ctx, req.GetTenant())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if ac := s.cfg.Admission; ac.Enabled() && ac.Shed(s.csvc.Load()) != "" {
+		return nil, statusError(mw.ErrOverloaded)
+	}
+
+	seen := make(map[int64]bool, len(req.GetObjectIds()))
+	cs := make([]models.Callback, 0, len(req.GetObjectIds()))
+	for _, id := range req.GetObjectIds() {
+		if !seen[id] {
+			seen[id] = true
+			cs = append(cs, models.Callback{Tenant: t.ID, ID: id, Source: req.GetSource()})
+		}
+	}
+	if max := t.MaxObjectsPerCallback; max > 0 && len(cs) > max {
+		return nil, statusError(ErrTooManyObjects)
+	}
+
+	if len(cs) > 0 {
+		if err := s.csvc.Upsert(ctx, cs); err != nil {
+			// The object services can't take the objects for now, as a 406 does on /callback.
+			return nil, statusErrorCode(codes.Unavailable, err)
+		}
+	}
+	return &callbackpb.SubmitCallbackResponse{Accepted: int32(len(cs))}, nil
+}
+
+// GetObject returns the object identified by the id of the request.
+func (s *Server) GetObject(ctx context.Context, req *callbackpb.GetObjectRequest) (*callbackpb.Object, error) {
+	ctx, span := trace.StartSpan(ctx, "rpc.Server.GetObject")
+	defer span.End()
+
+	t, err := s.tenant(ctx, req.GetTenant())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	c, err := s.csvc.Find(ctx, t.ID, req.GetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return object(c), nil
+}
+
+// ListObjects returns the objects of the tenant ordered by ID, paged through the after and limit
+// fields, and filtered by the payload ones.
+func (s *Server) ListObjects(ctx context.Context, req *callbackpb.ListObjectsRequest) (*callbackpb.ListObjectsResponse, error) {
+	ctx, span := trace.StartSpan(ctx, "rpc.Server.ListObjects")
+	defer span.End()
+
+	t, err := s.tenant(ctx, req.GetTenant())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	if req.GetLimit() < 0 || len(req.GetPayload()) > maxPayloadMatches {
+		return nil, statusError(ErrInvalidParameter)
+	}
+	// Limits above the maximum are capped, and full pages are followed by another one.
+	filter := models.CallbackFilter{AfterID: req.GetAfter(), Limit: int(req.GetLimit())}
+	filter.Limit = filter.PageSize()
+	for _, pm := range req.GetPayload() {
+		path, err := models.ParsePayloadPath(pm.GetPath())
+		if err != nil {
+			return nil, statusError(err)
+		}
+		filter.Payload = append(filter.Payload, models.PayloadMatch{Path: path, Value: pm.GetValue()})
+	}
+
+	cs, err := s.csvc.List(ctx, t.ID, filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	resp := &callbackpb.ListObjectsResponse{Objects: make([]*callbackpb.Object, len(cs))}
+	for i, c := range cs {
+		resp.Objects[i] = object(c)
+	}
+	if len(cs) == filter.Limit {
+		resp.NextAfter = &cs[len(cs)-1].ID
+	}
+	return resp, nil
+}
+
+// WatchObjects streams the changes of the objects of the tenant, filtered by the ids and online
+// fields, until the client cancels the call. Watches given a last_event_id resume after that
+// event, if it is still buffered. Watches falling behind, or still running when the service
+// stops, end with Unavailable, and are resumed the same way.
+func (s *Server) WatchObjects(req *callbackpb.WatchObjectsRequest, stream callbackpb.CallbackService_WatchObjectsServer) error {
+	ctx, span := trace.StartSpan(stream.Context(), "rpc.Server.WatchObjects")
+	defer span.End()
+
+	t, err := s.tenant(ctx, req.GetTenant())
+	if err != nil {
+		return statusError(err)
+	}
+	if len(req.GetIds()) > maxWatchIDs || req.GetLastEventId() < 0 {
+		return statusError(ErrInvalidParameter)
+	}
+	var ids map[int64]bool
+	if len(req.GetIds()) > 0 {
+		ids = make(map[int64]bool, len(req.GetIds()))
+		for _, id := range req.GetIds() {
+			ids[id] = true
+		}
+	}
+	matches := func(e models.ObjectEvent) bool {
+		return e.Tenant == t.ID &&
+			(ids == nil || ids[e.Object.ID]) &&
+			(!req.GetOnline() || e.Object.Online)
+	}
+
+	replay, events, cancel := s.broker.Subscribe(req.GetLastEventId())
+	defer cancel()
+
+	for _, e := range replay {
+		if matches(e) {
+			if err := stream.Send(objectEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				select {
+				case <-s.broker.Done():
+					return statusError(ErrShuttingDown)
+				default:
+					return statusError(ErrStreamBehind)
+				}
+			}
+			if !matches(e) {
+				continue
+			}
+			if err := stream.Send(objectEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// object returns the message of c. Payloads that are not JSON objects are left out.
+func object(c models.Callback) *callbackpb.Object {
+	o := &callbackpb.Object{
+		Tenant:    c.Tenant,
+		Id:        c.ID,
+		Online:    c.Online,
+		Timestamp: c.Timestamp,
+		Upstream:  c.Upstream,
+	}
+	if len(c.Payload) > 0 {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(c.Payload, &fields); err == nil && fields != nil {
+			o.Payload, _ = structpb.NewStruct(fields)
+		}
+	}
+	return o
+}
+
+// objectEvent returns the message of e.
+func objectEvent(e models.ObjectEvent) *callbackpb.ObjectEvent {
+	return &callbackpb.ObjectEvent{
+		Id:     e.ID,
+		Type:   e.Type,
+		Time:   timestamppb.New(e.Time),
+		Tenant: e.Tenant,
+		Object: object(e.Object),
+	}
+}
@@ -0,0 +1,314 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/noelruault/go-callback-service/internal/models"
+	"github.com/noelruault/go-callback-service/internal/rpc/callbackpb"
+)
+
+// testCallbackService is a models.CallbackService storing the objects upserted.
+type testCallbackService struct {
+	models.CallbackService
+
+	mu      sync.Mutex
+	objects map[int64]models.Callback
+}
+
+func (t *testCallbackService) Upsert(ctx context.Context, cs []models.Callback) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range cs {
+		t.objects[c.ID] = c
+	}
+	return nil
+}
+
+func (t *testCallbackService) Find(ctx context.Context, tenant string, id int64) (models.Callback, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.objects[id]
+	if !ok || c.Tenant != tenant {
+		return models.Callback{}, models.ErrNotFound
+	}
+	return c, nil
+}
+
+func (t *testCallbackService) List(ctx context.Context, tenant string, filter models.CallbackFilter) ([]models.Callback, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cs []models.Callback
+	for id := filter.AfterID + 1; len(cs) < filter.Limit; id++ {
+		if c, ok := t.objects[id]; ok && c.Tenant == tenant {
+			cs = append(cs, c)
+		} else {
+			break
+		}
+	}
+	return cs, nil
+}
+
+// testTenantService is a models.TenantService knowing the default and the "acme" tenants.
+type testTenantService struct {
+	models.TenantService
+}
+
+func (testTenantService) Get(ctx context.Context, id string) (models.Tenant, error) {
+	switch id {
+	case models.DefaultTenant:
+		return models.Tenant{ID: id}, nil
+	case "acme":
+		return models.Tenant{ID: id, MaxObjectsPerCallback: 3}, nil
+	}
+	return models.Tenant{}, models.ErrNotFound
+}
+
+// testAPIKeyService is a models.APIKeyService knowing a single key, of the "acme" tenant.
+type testAPIKeyService struct {
+	models.APIKeyService
+}
+
+func (testAPIKeyService) Authenticate(ctx context.Context, key string) (models.APIKey, error) {
+	if key != "cbk_valid" {
+		return models.APIKey{}, models.ErrInvalidAPIKey
+	}
+	return models.APIKey{ID: 1, Client: "exporter", Tenant: "acme"}, nil
+}
+
+// dialServer serves s in memory and returns a client connected to it.
+func dialServer(t *testing.T, s *Server) callbackpb.CallbackServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	gs := s.register()
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return callbackpb.NewCallbackServiceClient(conn)
+}
+
+func newTestServer(cfg Config) (*Server, *testCallbackService) {
+	csvc := &testCallbackService{objects: make(map[int64]models.Callback)}
+	return &Server{
+		csvc:    csvc,
+		tenants: testTenantService{},
+		keys:    testAPIKeyService{},
+		broker:  models.NewEventBroker(10),
+		cfg:     cfg,
+		log:     log.New(ioutil.Discard, "", 0),
+	}, csvc
+}
+
+func TestServer_SubmitCallback(t *testing.T) {
+	var cases = []struct {
+		name    string
+		key     string
+		req     *callbackpb.SubmitCallbackRequest
+		outCode codes.Code
+		out     int32
+	}{
+		{"anonymous", "", &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1, 2, 2}}, codes.Unauthenticated, 0},
+		{"invalidKey", "cbk_invalid", &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1}}, codes.Unauthenticated, 0},
+		{"authenticated", "cbk_valid", &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1, 2, 2}}, codes.OK, 2},
+		{"ownTenant", "cbk_valid", &callbackpb.SubmitCallbackRequest{Tenant: "acme", ObjectIds: []int64{1}}, codes.OK, 1},
+		{"otherTenant", "cbk_valid", &callbackpb.SubmitCallbackRequest{Tenant: models.DefaultTenant, ObjectIds: []int64{1}}, codes.PermissionDenied, 0},
+		{"tooManyObjects", "cbk_valid", &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1, 2, 3, 4}}, codes.ResourceExhausted, 0},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			s, csvc := newTestServer(Config{RequireAPIKey: true})
+			client := dialServer(t, s)
+
+			ctx := context.Background()
+			if cs.key != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+cs.key)
+			}
+			resp, err := client.SubmitCallback(ctx, cs.req)
+
+			assert.Equal(t, cs.outCode, status.Code(err), err)
+			if cs.outCode != codes.OK {
+				return
+			}
+			assert.Equal(t, cs.out, resp.GetAccepted())
+			assert.Equal(t, models.Callback{Tenant: "acme", ID: 1}, csvc.objects[1])
+		})
+	}
+}
+
+func TestServer_SubmitCallbackSigned(t *testing.T) {
+	s, csvc := newTestServer(Config{SignedCallbacks: true})
+	client := dialServer(t, s)
+
+	_, err := client.SubmitCallback(context.Background(), &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "calls can't be signed")
+	assert.Empty(t, csvc.objects)
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	s, csvc := newTestServer(Config{
+		RateLimits:       models.NewMemoryRateLimitService(),
+		ObjectsRateLimit: models.RateLimit{Rate: 0.001, Burst: 1},
+	})
+	csvc.objects[1] = models.Callback{Tenant: models.DefaultTenant, ID: 1}
+	client := dialServer(t, s)
+
+	_, err := client.GetObject(context.Background(), &callbackpb.GetObjectRequest{Id: 1})
+	assert.NoError(t, err)
+
+	var header metadata.MD
+	_, err = client.GetObject(context.Background(), &callbackpb.GetObjectRequest{Id: 1}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, header.Get("retry-after"))
+
+	_, err = client.SubmitCallback(context.Background(), &callbackpb.SubmitCallbackRequest{ObjectIds: []int64{1}})
+	assert.NoError(t, err, "callbacks are limited on their own bucket")
+}
+
+func TestServer_GetObject(t *testing.T) {
+	s, csvc := newTestServer(Config{})
+	csvc.objects[1] = models.Callback{Tenant: models.DefaultTenant, ID: 1, Online: true, Payload: models.JSONB(`{"rack":7}`)}
+	client := dialServer(t, s)
+
+	o, err := client.GetObject(context.Background(), &callbackpb.GetObjectRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), o.GetId())
+	assert.True(t, o.GetOnline())
+	assert.Equal(t, 7.0, o.GetPayload().AsMap()["rack"])
+
+	_, err = client.GetObject(context.Background(), &callbackpb.GetObjectRequest{Id: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "not_found: resource not found", status.Convert(err).Message())
+
+	_, err = client.GetObject(context.Background(), &callbackpb.GetObjectRequest{Tenant: "acme", Id: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "anonymous calls can't name another tenant")
+}
+
+func TestServer_ListObjects(t *testing.T) {
+	s, csvc := newTestServer(Config{})
+	for id := int64(1); id <= 3; id++ {
+		csvc.objects[id] = models.Callback{Tenant: models.DefaultTenant, ID: id}
+	}
+	client := dialServer(t, s)
+
+	resp, err := client.ListObjects(context.Background(), &callbackpb.ListObjectsRequest{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetObjects(), 2)
+	if assert.NotNil(t, resp.NextAfter) {
+		assert.Equal(t, int64(2), resp.GetNextAfter())
+	}
+
+	resp, err = client.ListObjects(context.Background(), &callbackpb.ListObjectsRequest{After: 2, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetObjects(), 1)
+	assert.Nil(t, resp.NextAfter, "last pages have no next_after")
+
+	_, err = client.ListObjects(context.Background(), &callbackpb.ListObjectsRequest{Payload: []*callbackpb.PayloadMatch{{Path: "a..b", Value: "x"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_ListObjects_capped(t *testing.T) {
+	s, csvc := newTestServer(Config{})
+	for id := int64(1); id <= models.MaxListLimit+1; id++ {
+		csvc.objects[id] = models.Callback{Tenant: models.DefaultTenant, ID: id}
+	}
+	client := dialServer(t, s)
+
+	for _, limit := range []int32{0, 5000} {
+		resp, err := client.ListObjects(context.Background(), &callbackpb.ListObjectsRequest{Limit: limit})
+		assert.NoError(t, err)
+		assert.Len(t, resp.GetObjects(), models.MaxListLimit)
+		if assert.NotNil(t, resp.NextAfter, "full pages have a next_after") {
+			assert.Equal(t, int64(models.MaxListLimit), resp.GetNextAfter())
+		}
+	}
+}
+
+func TestServer_WatchObjects(t *testing.T) {
+	s, _ := newTestServer(Config{})
+	client := dialServer(t, s)
+
+	t0 := time.Unix(0, 0).UTC()
+	event := func(id int64, tenant string, object int64, online bool) models.ObjectEvent {
+		return models.ObjectEvent{ID: id, Type: models.EventObjectOnline, Time: t0, Tenant: tenant, Object: models.Callback{Tenant: tenant, ID: object, Online: online}}
+	}
+	s.broker.Notify(context.Background(), []models.ObjectEvent{
+		event(1, models.DefaultTenant, 1, true),
+		event(2, models.DefaultTenant, 2, true),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchObjects(ctx, &callbackpb.WatchObjectsRequest{Ids: []int64{2, 3}, Online: true, LastEventId: 1})
+	assert.NoError(t, err)
+
+	e, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), e.GetId(), "watches resume after last_event_id")
+
+	s.broker.Notify(context.Background(), []models.ObjectEvent{
+		event(3, "acme", 3, true),
+		event(4, models.DefaultTenant, 3, false),
+		event(5, models.DefaultTenant, 1, true),
+		event(6, models.DefaultTenant, 3, true),
+	})
+	e, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), e.GetId(), "events of other tenants, objects or going offline are filtered out")
+	assert.Equal(t, models.EventObjectOnline, e.GetType())
+	assert.Equal(t, t0, e.GetTime().AsTime())
+}
+
+func TestServer_WatchObjects_shutdown(t *testing.T) {
+	s, _ := newTestServer(Config{})
+	lis := bufconn.Listen(1 << 20)
+	gs := s.register()
+	go gs.Serve(lis)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := callbackpb.NewCallbackServiceClient(conn).WatchObjects(context.Background(), &callbackpb.WatchObjectsRequest{})
+	assert.NoError(t, err)
+
+	// Closing the broker ends the watches, so the server stops gracefully.
+	s.broker.Close()
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "shutting_down")
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		gs.Stop()
+		t.Fatal("watches kept the server from stopping")
+	}
+}
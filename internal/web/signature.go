@@ -0,0 +1,92 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying the signature of a request body. Its value has the form
+// "t=<unix timestamp>,v1=<hex HMAC-SHA256>", with as many v1 entries as secrets used to sign.
+const SignatureHeader = "X-Callback-Signature"
+
+// These errors are returned when verifying a signature.
+var (
+	ErrSignatureMissing = errors.New("web: signature missing")
+	ErrSignatureInvalid = errors.New("web: signature invalid")
+	ErrSignatureExpired = errors.New("web: signature timestamp outside of the tolerance window")
+)
+
+// Sign returns the SignatureHeader value for body, signed at timestamp with every secret given.
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	parts := []string{"t=" + ts}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+hex.EncodeToString(computeSignature(secret, ts, body)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// VerifySignature checks that header holds a signature of body made with any of secrets, and
+// that its timestamp is no further than tolerance from now.
+func VerifySignature(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var (
+		ts   string
+		sigs [][]byte
+	)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrSignatureInvalid
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return ErrSignatureInvalid
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return ErrSignatureInvalid
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	for _, secret := range secrets {
+		expected := computeSignature(secret, ts, body)
+		for _, sig := range sigs {
+			if hmac.Equal(expected, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// computeSignature returns the HMAC-SHA256 of "<timestamp>.<body>" keyed with secret.
+func computeSignature(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
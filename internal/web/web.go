@@ -64,13 +64,22 @@ type Values struct {
 	TraceID    string
 	StatusCode int
 	Start      time.Time
+	// ClientID identifies the authenticated client that sent the request, if any.
+	ClientID string
+	// TenantID identifies the tenant the request is made for, if any.
+	TenantID string
 }
 
 // Handle associates a handler function with an HTTP Method and URL pattern.
+// Any Middleware provided will be ran only for this route, after the ones of
+// the App.
 //
 // It converts our custom handler type to the std lib Handler type. It captures
 // errors from the handler and serves them to the client in a uniform way.
-func (a *App) Handle(method, url string, h Handler) {
+func (a *App) Handle(method, url string, h Handler, mw ...Middleware) {
+
+	// First wrap handler specific middleware around this handler.
+	h = wrapMiddleware(mw, h)
 
 	// wrap the application's middleware around this endpoint's handler.
 	h = wrapMiddleware(a.mw, h)
@@ -90,11 +99,68 @@ func (a *App) Handle(method, url string, h Handler) {
 		ctx = context.WithValue(ctx, KeyValues, &v)
 
 		h(ctx, w, r)
+
+		if v.ClientID != "" {
+			span.AddAttributes(trace.StringAttribute("client_id", v.ClientID))
+		}
+		if v.TenantID != "" {
+			span.AddAttributes(trace.StringAttribute("tenant_id", v.TenantID))
+		}
 	}
 
 	a.mux.MethodFunc(method, url, fn)
 }
 
+// Group is a set of routes of an App mounted under a common prefix, such as the version of the
+// API they belong to, and sharing middlewares.
+type Group struct {
+	app    *App
+	prefix string
+	mw     []Middleware
+}
+
+// Group returns a group of routes mounted under prefix. An empty prefix mounts them on the root of
+// the App. Any Middleware provided will be ran for every route of the group, after the ones of the
+// App and before the ones of each route.
+func (a *App) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{app: a, prefix: prefix, mw: mw}
+}
+
+// Group returns a group of routes mounted under prefix within g, running the middlewares of g
+// before its own.
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:    g.app,
+		prefix: g.prefix + prefix,
+		mw:     append(append([]Middleware{}, g.mw...), mw...),
+	}
+}
+
+// Prefix returns the prefix the routes of g are mounted under.
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// Handle associates a handler function with an HTTP Method and a URL pattern relative to the
+// prefix of g. Any Middleware provided will be ran only for this route, after the ones of the
+// group.
+func (g *Group) Handle(method, url string, h Handler, mw ...Middleware) {
+	g.app.Handle(method, g.prefix+url, h, append(append([]Middleware{}, g.mw...), mw...)...)
+}
+
+// Param returns the value of the URL parameter key of the route matched by r.
+func Param(r *http.Request, key string) string {
+	return chi.URLParam(r, key)
+}
+
+// Walk calls fn with the method and URL pattern of every route of a, stopping at the first
+// error it returns.
+func (a *App) Walk(fn func(method, route string) error) error {
+	return chi.Walk(a.mux, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		return fn(method, route)
+	})
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.mux.ServeHTTP(w, r)